@@ -0,0 +1,198 @@
+// Command ftsdb serves an ftsdb database over HTTP with the Prometheus query
+// API.
+package main
+
+import (
+	"context"
+	"flag"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Marvin9/ftsdb/ftsdb"
+	"github.com/Marvin9/ftsdb/graphite"
+	"github.com/Marvin9/ftsdb/opentsdb"
+	"github.com/Marvin9/ftsdb/scrape"
+	"github.com/Marvin9/ftsdb/server"
+	"go.uber.org/zap"
+)
+
+// stringsFlag is a flag that can be given several times.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+type config struct {
+	dir                   string
+	listen                string
+	commitInterval        time.Duration
+	compactionInterval    time.Duration
+	retentionTime         time.Duration
+	retentionSize         int64
+	retentionInterval     time.Duration
+	remoteReadSampleLimit int
+	maxRequestSize        int64
+	graphiteListen        string
+	graphiteTemplates     []string
+	openTSDBListen        string
+	scrapeConfig          string
+}
+
+func main() {
+	var cfg config
+	var graphiteTemplates stringsFlag
+
+	flag.StringVar(&cfg.dir, "dir", "ftsdb-data", "directory the database is stored in")
+	flag.StringVar(&cfg.listen, "listen", ":9090", "address to serve the HTTP API on")
+	flag.DurationVar(&cfg.commitInterval, "commit-interval", server.DefaultCommitInterval, "how often to commit the head")
+	flag.DurationVar(&cfg.compactionInterval, "compaction-interval", 5*time.Minute, "how often to compact chunk directories, 0 to not compact")
+	flag.DurationVar(&cfg.retentionTime, "retention-time", 0, "how long before the newest datapoint to keep datapoints for, 0 to keep them forever")
+	flag.Int64Var(&cfg.retentionSize, "retention-size", 0, "most bytes of chunks to keep, 0 for no limit")
+	flag.DurationVar(&cfg.retentionInterval, "retention-interval", time.Minute, "how often to enforce retention")
+	flag.Int64Var(&cfg.maxRequestSize, "max-request-size", server.DefaultMaxRequestSize, "most bytes of the body of a write request, as sent and once decompressed")
+	flag.IntVar(&cfg.remoteReadSampleLimit, "remote-read-sample-limit", server.DefaultRemoteReadSampleLimit, "most samples a remote read query may return, 0 for no limit")
+	flag.StringVar(&cfg.graphiteListen, "graphite-listen", "", "address to receive the Graphite plaintext protocol on, empty to not receive it")
+	flag.Var(&graphiteTemplates, "graphite-template", "template mapping Graphite paths to a metric and labels, like \"host.*.cpu.* .host.metric.metric\", can be given several times")
+	flag.StringVar(&cfg.openTSDBListen, "opentsdb-listen", "", "address to receive the OpenTSDB telnet protocol on, empty to not receive it")
+	flag.StringVar(&cfg.scrapeConfig, "scrape-config", "", "file listing the targets to scrape, empty to not scrape")
+	flag.Parse()
+	cfg.graphiteTemplates = graphiteTemplates
+
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+
+	if err := run(logger, cfg); err != nil {
+		logger.Error("ftsdb stopped", zap.Error(err))
+		os.Exit(1)
+	}
+}
+
+// lineListener is a listener of a plaintext protocol served next to the HTTP
+// API.
+type lineListener interface {
+	Serve(l net.Listener) error
+	Close() error
+}
+
+func run(logger *zap.Logger, cfg config) error {
+	templates := []*graphite.Template{}
+	for _, s := range cfg.graphiteTemplates {
+		template, err := graphite.ParseTemplate(s)
+		if err != nil {
+			return err
+		}
+		templates = append(templates, template)
+	}
+
+	var scrapeConfig *scrape.Config
+	if cfg.scrapeConfig != "" {
+		var err error
+		if scrapeConfig, err = scrape.LoadConfig(cfg.scrapeConfig); err != nil {
+			return err
+		}
+	}
+
+	db, err := ftsdb.NewFTSDB(logger.Named("ftsdb"), cfg.dir)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// writers get to know about samples older than the newest of their series
+	db.SetRejectOutOfOrder(true)
+	db.SetCompactionInterval(cfg.compactionInterval)
+	if cfg.retentionTime > 0 || cfg.retentionSize > 0 {
+		db.SetRetention(cfg.retentionTime, cfg.retentionSize)
+		db.SetRetentionInterval(cfg.retentionInterval)
+	}
+
+	l, err := net.Listen("tcp", cfg.listen)
+	if err != nil {
+		return err
+	}
+
+	srv := server.NewServer(logger.Named("server"), db)
+	srv.SetCommitInterval(cfg.commitInterval)
+	srv.SetRemoteReadSampleLimit(cfg.remoteReadSampleLimit)
+	srv.SetMaxRequestSize(cfg.maxRequestSize)
+
+	listeners := []lineListener{}
+	defer func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}()
+
+	serve := func(name, address string, listener lineListener) error {
+		l, err := net.Listen("tcp", address)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, listener)
+
+		logger.Info("receiving "+name, zap.String("listen", l.Addr().String()))
+		go func() {
+			if err := listener.Serve(l); err != nil {
+				logger.Error("receiving "+name, zap.Error(err))
+			}
+		}()
+		return nil
+	}
+
+	if cfg.graphiteListen != "" {
+		if err := serve("graphite", cfg.graphiteListen, graphite.NewListener(logger.Named("graphite"), db, templates)); err != nil {
+			return err
+		}
+	}
+	if cfg.openTSDBListen != "" {
+		if err := serve("opentsdb", cfg.openTSDBListen, opentsdb.NewListener(logger.Named("opentsdb"), db)); err != nil {
+			return err
+		}
+	}
+
+	signals, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	scrapeCtx, stopScraping := context.WithCancel(context.Background())
+	scraped := make(chan struct{})
+	defer func() {
+		stopScraping()
+		<-scraped
+	}()
+	if scrapeConfig != nil {
+		go func() {
+			scrape.NewManager(logger.Named("scrape"), db, scrapeConfig).Run(scrapeCtx)
+			close(scraped)
+		}()
+	} else {
+		close(scraped)
+	}
+
+	// the listeners and the scrapes stop before the server does, so that
+	// what they appended is in the head it commits
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-signals.Done()
+		for _, listener := range listeners {
+			listener.Close()
+		}
+		stopScraping()
+		<-scraped
+		cancel()
+	}()
+
+	logger.Info("serving", zap.String("listen", l.Addr().String()), zap.String("dir", cfg.dir))
+
+	return srv.Run(ctx, l)
+}
@@ -0,0 +1,42 @@
+package experiments
+
+import (
+	"strconv"
+
+	"github.com/Marvin9/ftsdb/gorilla"
+	"github.com/Marvin9/ftsdb/transformer"
+)
+
+type TimestampEncodingSizes struct {
+	Timestamps int
+	// bytes of the "delta," text ftsdb chunks used to hold
+	DeltaText int
+	// bytes of the Gorilla delta-of-delta bit stream
+	DeltaOfDelta int
+}
+
+func CompareTimestampEncodings(timestamps []int64) TimestampEncodingSizes {
+	sizes := TimestampEncodingSizes{
+		Timestamps: len(timestamps),
+	}
+
+	for idx, ts := range timestamps {
+		delta := ts
+		if idx > 0 {
+			delta = ts - timestamps[idx-1]
+		}
+		sizes.DeltaText += len(strconv.FormatInt(delta, 10)) + 1
+	}
+
+	sizes.DeltaOfDelta = len(gorilla.EncodeTimestamps(timestamps))
+
+	return sizes
+}
+
+func CPUDataTimestamps(cpuData []transformer.CPUData) []int64 {
+	timestamps := make([]int64, len(cpuData))
+	for idx, data := range cpuData {
+		timestamps[idx] = data.Timestamp
+	}
+	return timestamps
+}
@@ -0,0 +1,33 @@
+package experiments
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimestampEncodingSizes(t *testing.T) {
+	// same shape as data/cpu_usage.json, one sample every 50ms for a day
+	regular := make([]int64, 24*60*60*20)
+	for i := range regular {
+		regular[i] = 1709337711578 + int64(i)*50
+	}
+
+	sizes := CompareTimestampEncodings(regular)
+	t.Logf("regular 50ms: %d timestamps, delta text %d bytes, delta-of-delta %d bytes", sizes.Timestamps, sizes.DeltaText, sizes.DeltaOfDelta)
+
+	// about one bit per timestamp
+	require.Less(t, sizes.DeltaOfDelta, sizes.Timestamps/8+16)
+	require.Less(t, sizes.DeltaOfDelta*20, sizes.DeltaText)
+
+	jittered := make([]int64, len(regular))
+	for i := range jittered {
+		jittered[i] = regular[i] + int64(rand.Intn(5))
+	}
+
+	sizes = CompareTimestampEncodings(jittered)
+	t.Logf("jittered 50ms: %d timestamps, delta text %d bytes, delta-of-delta %d bytes", sizes.Timestamps, sizes.DeltaText, sizes.DeltaOfDelta)
+
+	require.Less(t, sizes.DeltaOfDelta, sizes.DeltaText)
+}
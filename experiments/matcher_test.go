@@ -0,0 +1,115 @@
+package experiments
+
+import (
+	"context"
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/Marvin9/ftsdb/ftsdb"
+	"github.com/Marvin9/ftsdb/ftsdb/ftsdbtest"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMatchersAgainstPrometheus runs the same matchers against ftsdb and the
+// prometheus tsdb holding the same series, and expects the same series back.
+func TestMatchersAgainstPrometheus(t *testing.T) {
+	series := []map[string]string{
+		{"host": "web-1", "region": "eu"},
+		{"host": "web-2", "region": "eu"},
+		{"host": "web-10", "region": "us"},
+		{"host": "web-canary", "region": "us"},
+		{"host": "db-1", "region": "eu", "role": "primary"},
+		{"host": "db-2", "region": "us", "role": "replica"},
+		{"region": "eu"},
+		{"host": "", "region": "ap"},
+	}
+	metrics := []string{"cpu", "ram"}
+
+	db, err := tsdb.Open(t.TempDir(), nil, nil, tsdb.DefaultOptions(), nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	fdb := ftsdbtest.NewDB(t)
+	fdb.SetFlushLimit(1)
+
+	app := db.Appender(context.Background())
+	for _, metric := range metrics {
+		m := fdb.CreateMetric(metric)
+
+		for idx, s := range series {
+			withName := map[string]string{labels.MetricName: metric}
+			for k, v := range s {
+				withName[k] = v
+			}
+			_, err := app.Append(0, labels.FromMap(withName), 1, float64(idx))
+			require.NoError(t, err)
+
+			require.NoError(t, m.Append(s, 1, float64(idx)))
+		}
+	}
+	require.NoError(t, app.Commit())
+
+	// half of the series in a chunk, the other half in the head
+	require.NoError(t, fdb.Commit())
+	for _, s := range series[:4] {
+		require.NoError(t, fdb.CreateMetric("cpu").Append(s, 2, 0))
+	}
+
+	querier, err := db.Querier(math.MinInt64, math.MaxInt64)
+	require.NoError(t, err)
+	defer querier.Close()
+
+	matchType := map[labels.MatchType]ftsdb.MatchType{
+		labels.MatchEqual:     ftsdb.MatchEqual,
+		labels.MatchNotEqual:  ftsdb.MatchNotEqual,
+		labels.MatchRegexp:    ftsdb.MatchRegexp,
+		labels.MatchNotRegexp: ftsdb.MatchNotRegexp,
+	}
+
+	for _, selector := range [][]*labels.Matcher{
+		{labels.MustNewMatcher(labels.MatchEqual, "host", "web-1")},
+		{labels.MustNewMatcher(labels.MatchRegexp, "host", "web-.*"), labels.MustNewMatcher(labels.MatchNotEqual, "host", "web-canary")},
+		{labels.MustNewMatcher(labels.MatchRegexp, "host", "web-1")},
+		{labels.MustNewMatcher(labels.MatchRegexp, "host", "web-1.*|db-.*")},
+		{labels.MustNewMatcher(labels.MatchNotRegexp, "host", "web-.*"), labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "cpu")},
+		{labels.MustNewMatcher(labels.MatchEqual, "host", ""), labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+")},
+		{labels.MustNewMatcher(labels.MatchNotEqual, "role", ""), labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, "ram")},
+		{labels.MustNewMatcher(labels.MatchRegexp, "region", "eu|us"), labels.MustNewMatcher(labels.MatchNotRegexp, "role", "primary")},
+		{labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, "c.*"), labels.MustNewMatcher(labels.MatchRegexp, "missing", ".*")},
+	} {
+		expected := []string{}
+		ss := querier.Select(context.Background(), true, nil, selector...)
+		for ss.Next() {
+			expected = append(expected, ss.At().Labels().String())
+		}
+		require.NoError(t, ss.Err())
+
+		query := ftsdb.Query{}
+		for _, m := range selector {
+			query.Where(ftsdb.MustNewMatcher(matchType[m.Type], m.Name, m.Value))
+		}
+
+		found := []string{}
+		it, err := fdb.Find(query)
+		require.NoError(t, err)
+		for it.Next() != nil {
+			s := it.GetSeries()
+
+			// prometheus drops labels with empty values when appending
+			withName := map[string]string{labels.MetricName: s.Metric}
+			for k, v := range s.SeriesValue {
+				if v != "" {
+					withName[k] = v
+				}
+			}
+			found = append(found, labels.FromMap(withName).String())
+		}
+		require.NoError(t, it.Err())
+		sort.Strings(found)
+
+		require.Equal(t, expected, found, "%v", selector)
+	}
+}
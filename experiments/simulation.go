@@ -74,7 +74,9 @@ func PrometheusTSDBFindIterateAll(selector storage.SeriesSet) int {
 	return tot
 }
 
-func FTSDBIterateAll(ss *ftsdb.SeriesIterator) int {
+func FTSDBIterateAll(ss *ftsdb.SeriesIterator, err error) int {
+	noErr(err)
+
 	tot := 0
 	for ss.Next() != nil {
 		it := ss.DatapointsIterator
@@ -85,6 +87,8 @@ func FTSDBIterateAll(ss *ftsdb.SeriesIterator) int {
 			tot++
 		}
 	}
+	noErr(ss.Err())
+
 	// fmt.Println("ftsdb", tot)
 	return tot
 }
@@ -136,7 +140,8 @@ func BasicFTSDB(logger *zap.Logger) {
 		"host": "wind",
 	}
 
-	tsdb := ftsdb.NewFTSDB(logger, GetIngestionDir())
+	tsdb, err := ftsdb.NewFTSDB(logger, GetIngestionDir())
+	noErr(err)
 
 	defer tsdb.Close()
 
@@ -206,7 +211,8 @@ func RangeFTSDB(logger *zap.Logger) {
 		"host": "wind",
 	}
 
-	tsdb := ftsdb.NewFTSDB(logger, GetIngestionDir())
+	tsdb, err := ftsdb.NewFTSDB(logger, GetIngestionDir())
+	noErr(err)
 	defer tsdb.Close()
 
 	metric := tsdb.CreateMetric("jay")
@@ -277,7 +283,8 @@ func RangesFTSDB(logger *zap.Logger) {
 		"host": "wind",
 	}
 
-	tsdb := ftsdb.NewFTSDB(logger, GetIngestionDir())
+	tsdb, err := ftsdb.NewFTSDB(logger, GetIngestionDir())
+	noErr(err)
 	defer tsdb.Close()
 
 	metric := tsdb.CreateMetric("jay")
@@ -347,7 +354,8 @@ func HeavyAppendPrometheusTSDB(seriesList []map[string]int, points int) string {
 }
 
 func HeavyAppendFTSDB(logger *zap.Logger, seriesList []map[string]int, points int) {
-	tsdb := ftsdb.NewFTSDB(logger, GetIngestionDir())
+	tsdb, err := ftsdb.NewFTSDB(logger, GetIngestionDir())
+	noErr(err)
 	defer tsdb.Close()
 
 	metric := tsdb.CreateMetric("jay")
@@ -414,7 +422,8 @@ func RealCPUUsageDataPrometheusTSDB(cpuData []transformer.CPUData, logger *zap.L
 func RealCPUUsageDataFTSDB(logger *zap.Logger, cpuData []transformer.CPUData) {
 	series := labels.FromStrings("host", "macbook")
 
-	tsdb := ftsdb.NewFTSDB(logger, GetIngestionDir())
+	tsdb, err := ftsdb.NewFTSDB(logger, GetIngestionDir())
+	noErr(err)
 	defer tsdb.Close()
 
 	metric := tsdb.CreateMetric("mayur")
@@ -458,7 +467,8 @@ func RealCPUUsageDataConsequentAppendWritePrometheusTSDB(logger *zap.Logger, cpu
 func RealCPUUsageDataConsequentAppendWriteFTSDB(logger *zap.Logger, cpuData []transformer.CPUData) {
 	series := labels.FromStrings("host", "macbook")
 
-	tsdb := ftsdb.NewFTSDB(logger, GetIngestionDir())
+	tsdb, err := ftsdb.NewFTSDB(logger, GetIngestionDir())
+	noErr(err)
 	defer tsdb.Close()
 
 	query := ftsdb.Query{}
@@ -509,7 +519,8 @@ func RealCPUUsageRangeDataPrometheusTSDB(logger *zap.Logger, cpuData []transform
 func RealCPUUsageRangeDataFTSDB(logger *zap.Logger, cpuData []transformer.CPUData) {
 	series := labels.FromStrings("host", "macbook")
 
-	tsdb := ftsdb.NewFTSDB(logger, GetIngestionDir())
+	tsdb, err := ftsdb.NewFTSDB(logger, GetIngestionDir())
+	noErr(err)
 	defer tsdb.Close()
 
 	metric := tsdb.CreateMetric("mayur")
@@ -555,7 +566,8 @@ func AppendMillionPointsPrometheusTSDB() string {
 }
 
 func AppendMillionPointsFTSDB(logger *zap.Logger) {
-	tsdb := ftsdb.NewFTSDB(logger, GetIngestionDir())
+	tsdb, err := ftsdb.NewFTSDB(logger, GetIngestionDir())
+	noErr(err)
 	defer tsdb.Close()
 	m := tsdb.CreateMetric("met")
 	for i := 1; i <= 1000000; i++ {
@@ -566,7 +578,8 @@ func AppendMillionPointsFTSDB(logger *zap.Logger) {
 }
 
 func AppendPointsWithLabelsFTSDB(logger *zap.Logger, points int) {
-	tsdb := ftsdb.NewFTSDB(logger, GetIngestionDir())
+	tsdb, err := ftsdb.NewFTSDB(logger, GetIngestionDir())
+	noErr(err)
 	defer tsdb.Close()
 	m := tsdb.CreateMetric("met")
 	for i := 1; i <= points; i++ {
@@ -647,7 +660,8 @@ func HeavyAppendWriteDiskPrometheusTSDB(seriesList []map[string]int, points int)
 }
 
 func HeavyAppendWriteDiskFTSDB(logger *zap.Logger, seriesList []map[string]int, points int) {
-	tsdb := ftsdb.NewFTSDB(logger, GetIngestionDir())
+	tsdb, err := ftsdb.NewFTSDB(logger, GetIngestionDir())
+	noErr(err)
 	defer tsdb.Close()
 
 	metric := tsdb.CreateMetric("jay")
@@ -712,7 +726,8 @@ func RealRAMUsageDataPrometheusTSDB(cpuData, ramData []transformer.CPUData, seri
 }
 
 func RealRAMUsageDataFTSDB(logger *zap.Logger, cpuData, ramData []transformer.CPUData, seriesList []map[string]string) {
-	tsdb := ftsdb.NewFTSDB(logger, GetIngestionDir())
+	tsdb, err := ftsdb.NewFTSDB(logger, GetIngestionDir())
+	noErr(err)
 	defer tsdb.Close()
 
 	metric := tsdb.CreateMetric("mayur")
@@ -0,0 +1,266 @@
+package ftsdb
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+type AggregateOp int
+
+const (
+	AggregateSum AggregateOp = iota
+	AggregateAvg
+	AggregateMin
+	AggregateMax
+	AggregateCount
+	AggregateStddev
+	AggregateQuantile
+)
+
+// DefaultLookback is how far back a step looks for the latest datapoint of a
+// series when Aggregation.Lookback is not set, five minutes in milliseconds
+// like the PromQL default.
+const DefaultLookback int64 = 5 * 60 * 1000
+
+// Aggregation describes an aggregation over the series of a query, evaluated
+// like a PromQL aggregation over an instant vector at every step.
+//
+// Steps are the multiples of Step within [Start, End]. At each of them a
+// series contributes its latest datapoint no older than Lookback. Series are
+// grouped by the labels in By, or by all labels but those in Without. The
+// metric is never part of a group, so series of different metrics with the
+// same labels are aggregated together. The query aggregated over should
+// cover Start-Lookback to End.
+type Aggregation struct {
+	Op AggregateOp
+	// Quantile is the φ of AggregateQuantile.
+	Quantile float64
+
+	By      []string
+	Without []string
+
+	Start    int64
+	End      int64
+	Step     int64
+	Lookback int64
+}
+
+type AggregatedSeries struct {
+	Labels     map[string]string
+	Datapoints []Datapoint
+}
+
+// Aggregate consumes ss and returns one series per group, sorted by labels.
+// Steps a group has no datapoints for are left out of its series.
+func Aggregate(ss *SeriesIterator, agg Aggregation) ([]AggregatedSeries, error) {
+	if agg.Step <= 0 {
+		return nil, errors.New("aggregation step must be positive")
+	}
+	if agg.By != nil && len(agg.Without) > 0 {
+		return nil, errors.New("aggregation can not be both by and without labels")
+	}
+
+	lookback := agg.Lookback
+	if lookback <= 0 {
+		lookback = DefaultLookback
+	}
+
+	timestamps := StepTimestamps(agg.Start, agg.End, agg.Step)
+	steps := len(timestamps)
+
+	groups := map[string]*aggregateGroup{}
+
+	for s := ss.Next(); s != nil; s = s.Next() {
+		series := s.GetSeries()
+
+		ls := groupLabels(series.SeriesValue, agg)
+		key := seriesKey("", ls)
+
+		group, found := groups[key]
+		if !found {
+			group = &aggregateGroup{
+				labels: ls,
+				steps:  make([]aggregateStep, steps),
+			}
+			groups[key] = group
+		}
+
+		var last Datapoint
+		hasLast := false
+
+		dd := s.DatapointsIterator.Next()
+		for step := 0; step < steps; step++ {
+			ts := timestamps[step]
+
+			for dd != nil && dd.GetDatapoint().Timestamp <= ts {
+				last = dd.GetDatapoint()
+				hasLast = true
+				dd = dd.Next()
+			}
+
+			if hasLast && last.Timestamp >= ts-lookback {
+				group.steps[step].add(last.Value, agg.Op == AggregateQuantile)
+			}
+		}
+	}
+
+	if err := ss.Err(); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := make([]AggregatedSeries, 0, len(groups))
+
+	for _, key := range keys {
+		group := groups[key]
+
+		datapoints := []Datapoint{}
+		for step := range group.steps {
+			if group.steps[step].count == 0 {
+				continue
+			}
+			datapoints = append(datapoints, Datapoint{
+				Timestamp: timestamps[step],
+				Value:     group.steps[step].result(agg),
+			})
+		}
+
+		result = append(result, AggregatedSeries{
+			Labels:     group.labels.Map(),
+			Datapoints: datapoints,
+		})
+	}
+
+	return result, nil
+}
+
+// groupLabels returns the labels of the group series belongs to. An empty
+// but non nil By puts every series into a single group.
+func groupLabels(series map[string]string, agg Aggregation) Labels {
+	grouped := map[string]string{}
+
+	if agg.By != nil {
+		for _, name := range agg.By {
+			if value := series[name]; value != "" {
+				grouped[name] = value
+			}
+		}
+		return LabelsFromMap(grouped)
+	}
+
+	for name, value := range series {
+		if value != "" {
+			grouped[name] = value
+		}
+	}
+	for _, name := range agg.Without {
+		delete(grouped, name)
+	}
+	return LabelsFromMap(grouped)
+}
+
+type aggregateGroup struct {
+	labels Labels
+	steps  []aggregateStep
+}
+
+// aggregateStep accumulates the values of a group at one step.
+type aggregateStep struct {
+	count    int
+	sum      float64
+	min, max float64
+	// running mean and sum of squared differences, for stddev
+	mean, m2 float64
+	values   []float64
+}
+
+func (a *aggregateStep) add(v float64, keepValue bool) {
+	a.count++
+
+	if a.count == 1 {
+		a.min, a.max = v, v
+	} else {
+		if v < a.min || math.IsNaN(a.min) {
+			a.min = v
+		}
+		if v > a.max || math.IsNaN(a.max) {
+			a.max = v
+		}
+	}
+
+	a.sum += v
+
+	delta := v - a.mean
+	a.mean += delta / float64(a.count)
+	a.m2 += delta * (v - a.mean)
+
+	if keepValue {
+		a.values = append(a.values, v)
+	}
+}
+
+func (a *aggregateStep) result(agg Aggregation) float64 {
+	switch agg.Op {
+	case AggregateSum:
+		return a.sum
+	case AggregateAvg:
+		return a.mean
+	case AggregateMin:
+		return a.min
+	case AggregateMax:
+		return a.max
+	case AggregateCount:
+		return float64(a.count)
+	case AggregateStddev:
+		return math.Sqrt(a.m2 / float64(a.count))
+	case AggregateQuantile:
+		return Quantile(agg.Quantile, a.values)
+	}
+	return math.NaN()
+}
+
+// StepTimestamps returns the multiples of step within [start, end].
+func StepTimestamps(start, end, step int64) []int64 {
+	first := start - start%step
+	if first < start {
+		first += step
+	}
+
+	timestamps := []int64{}
+	for ts := first; ts <= end; ts += step {
+		timestamps = append(timestamps, ts)
+	}
+	return timestamps
+}
+
+// Quantile estimates the φ-quantile of values the way PromQL does, by linear
+// interpolation between the two closest ranks.
+func Quantile(q float64, values []float64) float64 {
+	if len(values) == 0 || math.IsNaN(q) {
+		return math.NaN()
+	}
+	if q < 0 {
+		return math.Inf(-1)
+	}
+	if q > 1 {
+		return math.Inf(+1)
+	}
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	n := float64(len(sorted))
+	rank := q * (n - 1)
+
+	lowerIndex := math.Max(0, math.Floor(rank))
+	upperIndex := math.Min(n-1, lowerIndex+1)
+
+	weight := rank - math.Floor(rank)
+	return sorted[int(lowerIndex)]*(1-weight) + sorted[int(upperIndex)]*weight
+}
@@ -0,0 +1,127 @@
+package ftsdb
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func aggregateFixture(t *testing.T) DBInterface {
+	tsdb, err := NewFTSDB(zap.NewNop(), t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(tsdb.Close)
+	tsdb.SetFlushLimit(1)
+
+	cpu := tsdb.CreateMetric("cpu")
+	for _, s := range []struct {
+		host, region string
+		scale        float64
+	}{
+		{"web-1", "eu", 1},
+		{"web-2", "eu", 2},
+		{"web-3", "us", 3},
+		{"web-4", "us", 4},
+	} {
+		series := map[string]string{"host": s.host, "region": s.region}
+		// a datapoint every 10ms from 0 to 100, valued scale times timestamp
+		for ts := int64(0); ts <= 100; ts += 10 {
+			require.NoError(t, cpu.Append(series, ts, s.scale*float64(ts)))
+		}
+	}
+
+	// half on disk, half in the head
+	require.NoError(t, tsdb.Commit())
+	for ts := int64(110); ts <= 200; ts += 10 {
+		require.NoError(t, cpu.Append(map[string]string{"host": "web-1", "region": "eu"}, ts, float64(ts)))
+	}
+
+	return tsdb
+}
+
+func aggregate(t *testing.T, tsdb DBInterface, agg Aggregation) []AggregatedSeries {
+	ss, err := tsdb.Find(*(&Query{}).Metric("cpu"))
+	require.NoError(t, err)
+
+	result, err := Aggregate(ss, agg)
+	require.NoError(t, err)
+	return result
+}
+
+func TestAggregateBy(t *testing.T) {
+	tsdb := aggregateFixture(t)
+
+	result := aggregate(t, tsdb, Aggregation{Op: AggregateSum, By: []string{"region"}, Start: 0, End: 100, Step: 50})
+	require.Equal(t, []AggregatedSeries{
+		{Labels: map[string]string{"region": "eu"}, Datapoints: []Datapoint{{0, 0}, {50, 150}, {100, 300}}},
+		{Labels: map[string]string{"region": "us"}, Datapoints: []Datapoint{{0, 0}, {50, 350}, {100, 700}}},
+	}, result)
+
+	result = aggregate(t, tsdb, Aggregation{Op: AggregateAvg, Without: []string{"host"}, Start: 100, End: 100, Step: 50})
+	require.Equal(t, []AggregatedSeries{
+		{Labels: map[string]string{"region": "eu"}, Datapoints: []Datapoint{{100, 150}}},
+		{Labels: map[string]string{"region": "us"}, Datapoints: []Datapoint{{100, 350}}},
+	}, result)
+
+	// by () aggregates everything into one group
+	result = aggregate(t, tsdb, Aggregation{Op: AggregateCount, By: []string{}, Start: 100, End: 100, Step: 50})
+	require.Equal(t, []AggregatedSeries{
+		{Labels: map[string]string{}, Datapoints: []Datapoint{{100, 4}}},
+	}, result)
+
+	// without () keeps every series apart
+	result = aggregate(t, tsdb, Aggregation{Op: AggregateMax, Start: 100, End: 100, Step: 50})
+	require.Len(t, result, 4)
+	require.Equal(t, map[string]string{"host": "web-1", "region": "eu"}, result[0].Labels)
+}
+
+func TestAggregateOps(t *testing.T) {
+	tsdb := aggregateFixture(t)
+
+	at100 := func(op AggregateOp, q float64) float64 {
+		result := aggregate(t, tsdb, Aggregation{Op: op, Quantile: q, By: []string{}, Start: 100, End: 100, Step: 1})
+		require.Len(t, result, 1)
+		require.Len(t, result[0].Datapoints, 1)
+		return result[0].Datapoints[0].Value
+	}
+
+	// the four series are at 100, 200, 300 and 400
+	require.Equal(t, 1000.0, at100(AggregateSum, 0))
+	require.Equal(t, 250.0, at100(AggregateAvg, 0))
+	require.Equal(t, 100.0, at100(AggregateMin, 0))
+	require.Equal(t, 400.0, at100(AggregateMax, 0))
+	require.Equal(t, 4.0, at100(AggregateCount, 0))
+	require.InDelta(t, math.Sqrt(12500), at100(AggregateStddev, 0), 1e-9)
+	require.Equal(t, 250.0, at100(AggregateQuantile, 0.5))
+	require.Equal(t, 175.0, at100(AggregateQuantile, 0.25))
+	require.Equal(t, 400.0, at100(AggregateQuantile, 1))
+	require.True(t, math.IsInf(at100(AggregateQuantile, 2), 1))
+	require.True(t, math.IsInf(at100(AggregateQuantile, -1), -1))
+}
+
+func TestAggregateSteps(t *testing.T) {
+	tsdb := aggregateFixture(t)
+
+	// steps are aligned to multiples of the step, not to Start
+	result := aggregate(t, tsdb, Aggregation{Op: AggregateSum, By: []string{"host"}, Start: 5, End: 40, Step: 15, Lookback: 100})
+	require.Equal(t, []Datapoint{{15, 10}, {30, 30}}, result[0].Datapoints)
+
+	// a step only sees datapoints within the lookback, web-1 goes on to 200
+	// while the other series stop at 100
+	result = aggregate(t, tsdb, Aggregation{Op: AggregateCount, By: []string{}, Start: 100, End: 200, Step: 25, Lookback: 30})
+	require.Equal(t, []AggregatedSeries{
+		{Labels: map[string]string{}, Datapoints: []Datapoint{{100, 4}, {125, 4}, {150, 1}, {175, 1}, {200, 1}}},
+	}, result)
+
+	// like in PromQL a datapoint exactly Lookback before a step is still seen
+	result = aggregate(t, tsdb, Aggregation{Op: AggregateCount, By: []string{}, Start: 130, End: 130, Step: 10, Lookback: 30})
+	require.Equal(t, []AggregatedSeries{
+		{Labels: map[string]string{}, Datapoints: []Datapoint{{130, 4}}},
+	}, result)
+
+	_, err := Aggregate(nil, Aggregation{Step: 0})
+	require.Error(t, err)
+	_, err = Aggregate(nil, Aggregation{Step: 1, By: []string{"host"}, Without: []string{"region"}})
+	require.Error(t, err)
+}
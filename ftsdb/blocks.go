@@ -0,0 +1,240 @@
+package ftsdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// deletedMarker is the file marking a chunk directory whose data was
+// compacted into another one. It is removed on open if it is still around.
+const deletedMarker = "deleted"
+
+// Chunk directories are never changed once written. They are named after the
+// min timestamp of their chunk, with a sequence number added when that name
+// is taken, as in 1000_2. Several directories can hold the same time range,
+// Find merges them.
+type chunkDir struct {
+	name         string
+	minTimestamp int64
+}
+
+func parseChunkDirName(name string) (chunkDir, bool) {
+	prefix, seq, found := strings.Cut(name, "_")
+
+	minTimestamp, err := strconv.ParseInt(prefix, 10, 64)
+	if err != nil {
+		return chunkDir{}, false
+	}
+
+	if found {
+		if n, err := strconv.Atoi(seq); err != nil || n <= 0 {
+			return chunkDir{}, false
+		}
+	}
+
+	return chunkDir{name: name, minTimestamp: minTimestamp}, true
+}
+
+// listChunkDirs lists the chunk directories of dbDir ordered by min
+// timestamp, without the ones replaced by a compaction. The caller holds
+// chunksMtx.
+func (ftsdb *ftsdb) listChunkDirs() ([]chunkDir, error) {
+	files, err := os.ReadDir(ftsdb.dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	dirs := []chunkDir{}
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		dir, ok := parseChunkDirName(file.Name())
+		if !ok || ftsdb.readers.isObsolete(dir.name) {
+			continue
+		}
+		dirs = append(dirs, dir)
+	}
+
+	sort.Slice(dirs, func(i, j int) bool {
+		if dirs[i].minTimestamp != dirs[j].minTimestamp {
+			return dirs[i].minTimestamp < dirs[j].minTimestamp
+		}
+		return dirs[i].name < dirs[j].name
+	})
+
+	return dirs, nil
+}
+
+// freeChunkDirName returns the name for a new chunk directory starting at
+// minTimestamp. The caller holds commitMtx.
+func (ftsdb *ftsdb) freeChunkDirName(minTimestamp int64) (string, error) {
+	name := strconv.FormatInt(minTimestamp, 10)
+
+	for seq := 1; ; seq++ {
+		if !ftsdb.readers.isObsolete(name) {
+			_, err := os.Stat(filepath.Join(ftsdb.dir, name))
+			if os.IsNotExist(err) {
+				return name, nil
+			}
+			if err != nil {
+				return "", err
+			}
+		}
+
+		name = fmt.Sprintf("%d_%d", minTimestamp, seq)
+	}
+}
+
+// swapChunkDirs moves the chunk directory written to tmp into place as name
+// and replaces the directories in replaced with it. Find sees either the
+// replaced directories or the new one, never both or neither. Replaced
+// directories are removed once no query reads them anymore. With an empty
+// tmp the replaced directories are only dropped.
+func (ftsdb *ftsdb) swapChunkDirs(tmp string, name string, replaced []string) error {
+	ftsdb.chunksMtx.Lock()
+	defer ftsdb.chunksMtx.Unlock()
+
+	if tmp != "" {
+		if err := os.Rename(tmp, filepath.Join(ftsdb.dir, name)); err != nil {
+			return err
+		}
+
+		if err := syncDir(ftsdb.dir); err != nil {
+			return err
+		}
+	}
+
+	if len(replaced) == 0 {
+		return nil
+	}
+
+	// the new directory, if any, is in place, so the data of the replaced
+	// ones is not lost if they are removed on the next open
+	for _, old := range replaced {
+		if err := writeFileSync(filepath.Join(ftsdb.dir, old, deletedMarker), nil); err != nil {
+			return err
+		}
+	}
+
+	ftsdb.readers.markObsolete(replaced)
+
+	return nil
+}
+
+// dropChunkDirs removes chunk directories from the database. Like with a
+// swap, queries already reading them finish reading before they are removed.
+func (ftsdb *ftsdb) dropChunkDirs(names []string) error {
+	return ftsdb.swapChunkDirs("", "", names)
+}
+
+// chunkReaders tracks the chunk directories queries are reading, so that
+// directories replaced by a compaction stay around until queries started
+// before it are done with them.
+type chunkReaders struct {
+	dir    string
+	logger *zap.Logger
+
+	mtx      sync.Mutex
+	reading  map[string]int
+	obsolete map[string]bool
+}
+
+func newChunkReaders(dir string, logger *zap.Logger) *chunkReaders {
+	return &chunkReaders{
+		dir:      dir,
+		logger:   logger,
+		reading:  map[string]int{},
+		obsolete: map[string]bool{},
+	}
+}
+
+func (r *chunkReaders) isObsolete(name string) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	return r.obsolete[name]
+}
+
+// markObsolete hides dirs from new queries and removes the ones no query is
+// reading.
+func (r *chunkReaders) markObsolete(dirs []string) {
+	r.mtx.Lock()
+	removable := []string{}
+	for _, name := range dirs {
+		r.obsolete[name] = true
+		if r.reading[name] == 0 {
+			removable = append(removable, name)
+		}
+	}
+	r.mtx.Unlock()
+
+	r.remove(removable)
+}
+
+// acquire keeps dirs from being removed until the returned lease is
+// released. A lease that is never released is released once it is garbage
+// collected.
+func (r *chunkReaders) acquire(dirs []chunkDir) *chunkLease {
+	lease := &chunkLease{readers: r, dirs: make([]string, len(dirs))}
+
+	r.mtx.Lock()
+	for idx, dir := range dirs {
+		lease.dirs[idx] = dir.name
+		r.reading[dir.name]++
+	}
+	r.mtx.Unlock()
+
+	runtime.SetFinalizer(lease, (*chunkLease).release)
+
+	return lease
+}
+
+func (r *chunkReaders) remove(dirs []string) {
+	for _, name := range dirs {
+		if err := os.RemoveAll(filepath.Join(r.dir, name)); err != nil {
+			r.logger.Error("removing compacted chunk", zap.String("chunk", name), zap.Error(err))
+			continue
+		}
+
+		r.mtx.Lock()
+		delete(r.obsolete, name)
+		r.mtx.Unlock()
+	}
+}
+
+type chunkLease struct {
+	readers *chunkReaders
+	dirs    []string
+	once    sync.Once
+}
+
+func (l *chunkLease) release() {
+	l.once.Do(func() {
+		r := l.readers
+
+		r.mtx.Lock()
+		removable := []string{}
+		for _, name := range l.dirs {
+			r.reading[name]--
+			if r.reading[name] > 0 {
+				continue
+			}
+			delete(r.reading, name)
+			if r.obsolete[name] {
+				removable = append(removable, name)
+			}
+		}
+		r.mtx.Unlock()
+
+		r.remove(removable)
+	})
+}
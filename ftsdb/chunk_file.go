@@ -0,0 +1,224 @@
+package ftsdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/Marvin9/ftsdb/gorilla"
+)
+
+// Chunk files are laid out as
+//
+//	header | series block 0 | ... | series block n-1 | index | footer
+//
+// header: magic (4 bytes) | version (1 byte)
+// series block: uvarint datapoints | uvarint timestamps length | timestamps | values
+// index entry, one per series: block offset (8 bytes) | block length (4 bytes) | crc32 of block (4 bytes)
+// footer: index offset (8 bytes) | series count (4 bytes) | magic (4 bytes)
+//
+// Timestamps are Gorilla delta-of-delta and values Gorilla XOR streams. The
+// fixed size footer and index entries let a reader seek straight to a series.
+const (
+	chunkMagic   uint32 = 0x46545344 // "FTSD"
+	chunkVersion byte   = 1
+
+	chunkHeaderSize     = 5
+	chunkIndexEntrySize = 16
+	chunkFooterSize     = 16
+)
+
+var errNotBinaryChunk = errors.New("not a binary chunk file")
+
+type chunkIndexEntry struct {
+	offset uint64
+	length uint32
+	crc    uint32
+}
+
+func encodeSeriesBlock(timestamps *gorilla.TimestampEncoder, values *gorilla.XOREncoder) []byte {
+	encodedTimestamps := timestamps.Bytes()
+	encodedValues := values.Bytes()
+
+	block := make([]byte, 0, 2*binary.MaxVarintLen64+len(encodedTimestamps)+len(encodedValues))
+	block = binary.AppendUvarint(block, uint64(timestamps.Count()))
+	block = binary.AppendUvarint(block, uint64(len(encodedTimestamps)))
+	block = append(block, encodedTimestamps...)
+	block = append(block, encodedValues...)
+
+	return block
+}
+
+func decodeSeriesBlock(block []byte, seriesIndex int) ([]ChunkData, error) {
+	count, n := binary.Uvarint(block)
+	if n <= 0 {
+		return nil, errors.New("invalid series block header")
+	}
+	block = block[n:]
+
+	timestampsLen, n := binary.Uvarint(block)
+	if n <= 0 || timestampsLen > uint64(len(block)-n) {
+		return nil, errors.New("invalid series block header")
+	}
+	block = block[n:]
+
+	timestamps, err := gorilla.DecodeTimestamps(block[:timestampsLen], int(count))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode timestamps: %s", err)
+	}
+
+	values, err := gorilla.DecodeXOR(block[timestampsLen:], int(count))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode values: %s", err)
+	}
+
+	result := make([]ChunkData, count)
+
+	for idx := range result {
+		result[idx] = ChunkData{
+			Series: int64(seriesIndex),
+			Datapoint: Datapoint{
+				Timestamp: timestamps[idx],
+				Value:     values[idx],
+			},
+		}
+	}
+
+	return result, nil
+}
+
+func encodeChunkFile(blocks [][]byte) []byte {
+	buf := bytes.Buffer{}
+
+	var scratch [chunkIndexEntrySize]byte
+
+	binary.BigEndian.PutUint32(scratch[:], chunkMagic)
+	scratch[4] = chunkVersion
+	buf.Write(scratch[:chunkHeaderSize])
+
+	index := make([]chunkIndexEntry, len(blocks))
+	for idx, block := range blocks {
+		index[idx] = chunkIndexEntry{
+			offset: uint64(buf.Len()),
+			length: uint32(len(block)),
+			crc:    crc32.ChecksumIEEE(block),
+		}
+		buf.Write(block)
+	}
+
+	indexOffset := uint64(buf.Len())
+	for _, entry := range index {
+		binary.BigEndian.PutUint64(scratch[0:], entry.offset)
+		binary.BigEndian.PutUint32(scratch[8:], entry.length)
+		binary.BigEndian.PutUint32(scratch[12:], entry.crc)
+		buf.Write(scratch[:chunkIndexEntrySize])
+	}
+
+	binary.BigEndian.PutUint64(scratch[0:], indexOffset)
+	binary.BigEndian.PutUint32(scratch[8:], uint32(len(blocks)))
+	binary.BigEndian.PutUint32(scratch[12:], chunkMagic)
+	buf.Write(scratch[:chunkFooterSize])
+
+	return buf.Bytes()
+}
+
+// readBinarySeries reads a single series block through the footer index.
+// It returns errNotBinaryChunk when file is not in the binary format.
+func readBinarySeries(file *os.File, seriesIndex int) ([]ChunkData, error) {
+	header := make([]byte, chunkHeaderSize)
+	if _, err := io.ReadFull(file, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, errNotBinaryChunk
+		}
+		return nil, err
+	}
+
+	if binary.BigEndian.Uint32(header) != chunkMagic {
+		return nil, errNotBinaryChunk
+	}
+
+	if header[4] != chunkVersion {
+		return nil, fmt.Errorf("unsupported chunk version %d", header[4])
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	size := info.Size()
+	if size < chunkHeaderSize+chunkFooterSize {
+		return nil, errors.New("chunk file too small for footer")
+	}
+
+	footer := make([]byte, chunkFooterSize)
+	if _, err := file.ReadAt(footer, size-chunkFooterSize); err != nil {
+		return nil, err
+	}
+
+	if binary.BigEndian.Uint32(footer[12:]) != chunkMagic {
+		return nil, errors.New("invalid chunk footer")
+	}
+
+	indexOffset := int64(binary.BigEndian.Uint64(footer))
+	seriesCount := int(binary.BigEndian.Uint32(footer[8:]))
+
+	if indexOffset+int64(seriesCount)*chunkIndexEntrySize != size-chunkFooterSize {
+		return nil, errors.New("chunk index does not match footer")
+	}
+
+	if seriesIndex >= seriesCount {
+		return nil, fmt.Errorf("series %d not in chunk of %d series", seriesIndex, seriesCount)
+	}
+
+	rawEntry := make([]byte, chunkIndexEntrySize)
+	if _, err := file.ReadAt(rawEntry, indexOffset+int64(seriesIndex)*chunkIndexEntrySize); err != nil {
+		return nil, err
+	}
+
+	entry := chunkIndexEntry{
+		offset: binary.BigEndian.Uint64(rawEntry),
+		length: binary.BigEndian.Uint32(rawEntry[8:]),
+		crc:    binary.BigEndian.Uint32(rawEntry[12:]),
+	}
+
+	if int64(entry.offset)+int64(entry.length) > indexOffset {
+		return nil, errors.New("series block out of bounds")
+	}
+
+	block := make([]byte, entry.length)
+	if _, err := file.ReadAt(block, int64(entry.offset)); err != nil {
+		return nil, err
+	}
+
+	if crc32.ChecksumIEEE(block) != entry.crc {
+		return nil, errors.New("series block checksum mismatch")
+	}
+
+	return decodeSeriesBlock(block, seriesIndex)
+}
+
+// readSeriesFromFile reads the series at seriesIndex from a chunk file in
+// either the binary or the legacy line based text format.
+func readSeriesFromFile(chunkpath string, seriesIndex int) ([]ChunkData, error) {
+	file, err := os.Open(chunkpath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	chunkData, err := readBinarySeries(file, seriesIndex)
+	if err != errNotBinaryChunk {
+		return chunkData, err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return readLegacySeries(file, seriesIndex)
+}
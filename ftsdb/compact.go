@@ -0,0 +1,205 @@
+package ftsdb
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultCompactionRanges are the time ranges, in milliseconds, chunk
+// directories are compacted into: first 2h blocks, which are then compacted
+// into 12h blocks and those into 7d blocks.
+var DefaultCompactionRanges = []int64{
+	int64(2 * time.Hour / time.Millisecond),
+	int64(12 * time.Hour / time.Millisecond),
+	int64(7 * 24 * time.Hour / time.Millisecond),
+}
+
+type compactor struct {
+	ranges []int64
+	loop   loop
+}
+
+// SetCompactionInterval compacts chunk directories in the background every
+// interval, a zero interval stops it.
+func (ftsdb *ftsdb) SetCompactionInterval(interval time.Duration) {
+	ftsdb.compactor.loop.start(interval, func() {
+		if err := ftsdb.Compact(); err != nil {
+			ftsdb.logger.Error("compaction failed", zap.Error(err))
+		}
+	})
+}
+
+// Compact merges chunk directories into blocks aligned to the compaction
+// ranges, until there is nothing left to merge. A range is only compacted
+// once the newest chunk is past its end, so that the blocks are not
+// rewritten again and again while they are being filled. Directories with
+// tombstones are rewritten without the deleted datapoints. Queries running
+// meanwhile keep reading the directories they started with.
+func (ftsdb *ftsdb) Compact() error {
+	ftsdb.commitMtx.Lock()
+	defer ftsdb.commitMtx.Unlock()
+
+	for {
+		sources, err := ftsdb.planCompaction()
+		if err != nil || len(sources) == 0 {
+			return err
+		}
+
+		if err := ftsdb.compact(sources); err != nil {
+			return err
+		}
+	}
+}
+
+// planCompaction returns the chunk directories to compact next, the ones
+// of the first complete range holding more than one, trying the smallest
+// ranges first. Without any, it returns the first directory with
+// tombstones.
+func (ftsdb *ftsdb) planCompaction() ([]string, error) {
+	ftsdb.chunksMtx.RLock()
+	dirs, err := ftsdb.listChunkDirs()
+	ftsdb.chunksMtx.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	metas := map[string]ChunkMeta{}
+	deleted := []string{}
+	newest := int64(0)
+	for _, dir := range dirs {
+		meta, err := readChunkMeta(ftsdb.dir, dir.name)
+		if err != nil {
+			if isChunkError(err) {
+				ftsdb.logger.Warn("not compacting chunk", zap.Error(err))
+				continue
+			}
+			return nil, err
+		}
+		metas[dir.name] = meta
+
+		if _, err := os.Stat(filepath.Join(ftsdb.dir, dir.name, tombstonesFile)); err == nil {
+			deleted = append(deleted, dir.name)
+		}
+
+		if len(metas) == 1 || meta.MaxTimestamp > newest {
+			newest = meta.MaxTimestamp
+		}
+	}
+
+	for _, r := range ftsdb.compactor.ranges {
+		groups := map[int64][]string{}
+		for _, dir := range dirs {
+			meta, found := metas[dir.name]
+			if !found {
+				continue
+			}
+
+			start := rangeStart(meta.MinTimestamp, r)
+			if meta.MaxTimestamp < start+r {
+				groups[start] = append(groups[start], dir.name)
+			}
+		}
+
+		starts := make([]int64, 0, len(groups))
+		for start := range groups {
+			starts = append(starts, start)
+		}
+		sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+		for _, start := range starts {
+			if len(groups[start]) > 1 && start+r <= newest {
+				return groups[start], nil
+			}
+		}
+	}
+
+	if len(deleted) > 0 {
+		return deleted[:1], nil
+	}
+
+	return nil, nil
+}
+
+// rangeStart returns the start of the range of size r holding ts.
+func rangeStart(ts, r int64) int64 {
+	start := ts - ts%r
+	if ts < 0 && ts%r != 0 {
+		start -= r
+	}
+	return start
+}
+
+// compact merges the chunk directories sources into a new one and swaps it
+// in for them. If all of their datapoints were deleted they are only
+// dropped.
+func (ftsdb *ftsdb) compact(sources []string) error {
+	chunk := NewChunk()
+
+	for _, name := range sources {
+		source, err := readChunk(ftsdb.dir, name)
+		if err != nil {
+			return err
+		}
+		deleted, err := readTombstones(ftsdb.dir, name)
+		if err != nil {
+			return err
+		}
+		chunk.MergeChunk(source.withoutTombstones(deleted))
+	}
+
+	chunk.dedupe()
+
+	if len(chunk.Data) == 0 {
+		if err := ftsdb.dropChunkDirs(sources); err != nil {
+			return err
+		}
+
+		ftsdb.logger.Info("dropped deleted chunks", zap.Strings("sources", sources))
+		return nil
+	}
+
+	name, err := ftsdb.freeChunkDirName(chunk.Meta.MinTimestamp)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(ftsdb.dir, name+".tmp")
+
+	if err := writeChunkDir(tmp, chunk); err != nil {
+		return err
+	}
+
+	if err := ftsdb.swapChunkDirs(tmp, name, sources); err != nil {
+		return err
+	}
+
+	ftsdb.logger.Info("compacted chunks",
+		zap.Strings("sources", sources),
+		zap.String("chunk", name),
+		zap.Int64("minTimestamp", chunk.Meta.MinTimestamp),
+		zap.Int64("maxTimestamp", chunk.Meta.MaxTimestamp),
+	)
+
+	return nil
+}
+
+// dedupe drops datapoints of a series repeating a timestamp, as they appear
+// when the same samples were committed twice.
+func (c *Chunk) dedupe() {
+	last := map[int64]int64{}
+	data := c.Data[:0]
+
+	for _, d := range c.Data {
+		if ts, found := last[d.Series]; found && ts == d.Datapoint.Timestamp {
+			continue
+		}
+		last[d.Series] = d.Datapoint.Timestamp
+		data = append(data, d)
+	}
+
+	c.Data = data
+}
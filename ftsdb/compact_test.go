@@ -0,0 +1,194 @@
+package ftsdb
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const hour = int64(60 * 60 * 1000)
+
+// chunkDirNames lists the chunk directories on disk, including the ones
+// waiting to be removed.
+func chunkDirNames(t *testing.T, dir string) []string {
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+
+	names := []string{}
+	for _, file := range files {
+		if _, ok := parseChunkDirName(file.Name()); ok && file.IsDir() {
+			names = append(names, file.Name())
+		}
+	}
+	return names
+}
+
+// commitEach appends and commits every timestamp on its own, so each one
+// gets a chunk directory.
+func commitEach(t *testing.T, db DBInterface, timestamps ...int64) {
+	cpu := db.CreateMetric("cpu")
+	for _, ts := range timestamps {
+		require.NoError(t, cpu.Append(map[string]string{"host": "mac"}, ts, float64(ts)))
+		require.NoError(t, cpu.Append(map[string]string{"host": "win"}, ts, float64(-ts)))
+		require.NoError(t, db.Commit())
+	}
+}
+
+func TestCompact(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := NewFTSDB(zap.NewNop(), dir)
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetFlushLimit(1)
+
+	commitEach(t, db, 0, hour, 90*60*1000, 2*hour, 3*hour, 13*hour)
+	require.Len(t, chunkDirNames(t, dir), 6)
+
+	before := collectFind(t, db, Query{})
+
+	require.NoError(t, db.Compact())
+
+	// 0h-2h and 2h-4h are compacted into 2h blocks and those into a 12h
+	// block, 12h-14h is not complete yet
+	names := chunkDirNames(t, dir)
+	require.Len(t, names, 2)
+	require.Contains(t, names, "46800000")
+
+	require.Equal(t, before, collectFind(t, db, Query{}))
+	require.Equal(t, map[string][]Datapoint{
+		"cpu/mac": {{3 * hour, float64(3 * hour)}, {13 * hour, float64(13 * hour)}},
+		"cpu/win": {{3 * hour, float64(-3 * hour)}, {13 * hour, float64(-13 * hour)}},
+	}, collectFind(t, db, *(&Query{}).RangeStart(3 * hour)))
+
+	// nothing left to do
+	require.NoError(t, db.Compact())
+	require.ElementsMatch(t, names, chunkDirNames(t, dir))
+
+	// a chunk committed late into a compacted range is merged into it
+	commitEach(t, db, 5*hour)
+	require.Len(t, chunkDirNames(t, dir), 3)
+
+	require.NoError(t, db.Compact())
+	require.Len(t, chunkDirNames(t, dir), 2)
+	require.Len(t, collectFind(t, db, Query{})["cpu/mac"], 7)
+}
+
+func TestCompactKeepsRunningQueries(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := NewFTSDB(zap.NewNop(), dir)
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetFlushLimit(1)
+
+	commitEach(t, db, 0, hour, 3*hour)
+
+	ss, err := db.Find(Query{})
+	require.NoError(t, err)
+
+	require.NoError(t, db.Compact())
+
+	// the compacted directories stay until the query is done with them
+	require.ElementsMatch(t, []string{"0", "3600000", "0_1", "10800000"}, chunkDirNames(t, dir))
+
+	collected := map[string][]Datapoint{}
+	for ss.Next() != nil {
+		key := ss.GetSeries().SeriesValue["host"]
+		for it := ss.DatapointsIterator; it.Next() != nil; {
+			collected[key] = append(collected[key], it.GetDatapoint())
+		}
+	}
+	require.NoError(t, ss.Err())
+	require.Equal(t, []Datapoint{{0, 0}, {hour, float64(hour)}, {3 * hour, float64(3 * hour)}}, collected["mac"])
+
+	// queries started after the compaction only see the new directory
+	require.ElementsMatch(t, []string{"0_1", "10800000"}, chunkDirNames(t, dir))
+	require.Equal(t, collected["mac"], collectFind(t, db, Query{})["cpu/mac"])
+}
+
+func TestCompactAfterQueryClosed(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := NewFTSDB(zap.NewNop(), dir)
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetFlushLimit(1)
+
+	commitEach(t, db, 0, hour, 3*hour)
+
+	// a query stopped halfway keeps its directories until it is closed
+	ss, err := db.Find(Query{})
+	require.NoError(t, err)
+	require.NotNil(t, ss.Next())
+	require.NotNil(t, ss.DatapointsIterator.Next())
+
+	require.NoError(t, db.Compact())
+	require.ElementsMatch(t, []string{"0", "3600000", "0_1", "10800000"}, chunkDirNames(t, dir))
+
+	ss.Close()
+	require.ElementsMatch(t, []string{"0_1", "10800000"}, chunkDirNames(t, dir))
+
+	// closed iterators end, closing again is fine
+	require.Nil(t, ss.DatapointsIterator.Next())
+	require.Nil(t, ss.Next())
+	require.NoError(t, ss.Err())
+	ss.Close()
+}
+
+func TestCompactDedupes(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := NewFTSDB(zap.NewNop(), dir)
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetFlushLimit(1)
+
+	// the same samples committed twice, as after a crash between writing
+	// a chunk and truncating the wal
+	commitEach(t, db, 0, hour)
+	commitEach(t, db, 0, hour, 3*hour)
+	require.Len(t, chunkDirNames(t, dir), 5)
+
+	require.NoError(t, db.Compact())
+
+	require.ElementsMatch(t, []string{"0_2", "10800000"}, chunkDirNames(t, dir))
+
+	chunk, err := readChunk(dir, "0_2")
+	require.NoError(t, err)
+	require.Len(t, chunk.Data, 4)
+}
+
+func TestCompactionInterval(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := NewFTSDB(zap.NewNop(), dir)
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetFlushLimit(1)
+
+	commitEach(t, db, 0, hour, 3*hour)
+
+	db.SetCompactionInterval(10 * time.Millisecond)
+	require.Eventually(t, func() bool {
+		return len(chunkDirNames(t, dir)) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	db.SetCompactionInterval(0)
+}
+
+func TestParseChunkDirName(t *testing.T) {
+	for name, expected := range map[string]int64{"0": 0, "1000": 1000, "-5": -5, "1000_2": 1000} {
+		dir, ok := parseChunkDirName(name)
+		require.True(t, ok, name)
+		require.Equal(t, chunkDir{name: name, minTimestamp: expected}, dir)
+	}
+
+	for _, name := range []string{"wal", "1000.tmp", "1000_0", "1000_x", "_1"} {
+		_, ok := parseChunkDirName(name)
+		require.False(t, ok, name)
+	}
+}
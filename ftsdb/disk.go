@@ -9,120 +9,184 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
-
-	"github.com/Marvin9/ftsdb/shared"
 )
 
-func GetChunkMeta(chunk int) ChunkMeta {
-	metapath := filepath.Join(shared.GetIngestionDir(), strconv.Itoa(chunk), "meta.json")
-
-	chunkMeta := ChunkMeta{}
+// GetChunkMeta reads the meta of the chunk directory named chunk inside the
+// database directory dbDir.
+func GetChunkMeta(dbDir string, chunk int) (ChunkMeta, error) {
+	return readChunkMeta(dbDir, strconv.Itoa(chunk))
+}
 
-	file, err := os.Open(metapath)
-	shared.NoErr(err)
+// readChunkMeta reads the meta of the chunk directory name.
+func readChunkMeta(dbDir string, name string) (ChunkMeta, error) {
+	dir := filepath.Join(dbDir, name)
+	metapath := filepath.Join(dir, "meta.json")
 
-	defer file.Close()
+	chunkMeta := ChunkMeta{}
 
-	// Read the file contents
-	data, err := io.ReadAll(file)
-	shared.NoErr(err)
+	data, err := os.ReadFile(metapath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return chunkMeta, &ErrChunkNotFound{Dir: dir, Err: err}
+		}
+		return chunkMeta, err
+	}
 
-	shared.NoErr(json.Unmarshal(data, &chunkMeta))
+	if err := json.Unmarshal(data, &chunkMeta); err != nil {
+		return chunkMeta, &ErrCorruptChunk{Dir: dir, Err: err}
+	}
 
-	return chunkMeta
+	return chunkMeta, nil
 }
 
-func ReadSeries(chunk int, chunkMeta ChunkMeta, series map[string]string) []ChunkData {
+func ReadSeries(dbDir string, chunk int, chunkMeta ChunkMeta, metric string, series map[string]string) ([]ChunkData, error) {
 	seriesIndexInChunk := -1
 
 	for idx, existingSeries := range chunkMeta.Series {
-		if seriesMatched(series, existingSeries) {
+		if chunkMeta.MetricAt(idx) == metric && seriesMatched(series, existingSeries) {
 			seriesIndexInChunk = idx
 			break
 		}
 	}
 
 	if seriesIndexInChunk == -1 {
-		return []ChunkData{}
+		return []ChunkData{}, nil
 	}
 
-	chunkpath := filepath.Join(shared.GetIngestionDir(), strconv.Itoa(chunk), "chunk")
+	return readChunkSeries(dbDir, strconv.Itoa(chunk), seriesIndexInChunk)
+}
+
+// readChunkSeries reads the series at seriesIndex of the chunk directory
+// name.
+func readChunkSeries(dbDir string, name string, seriesIndex int) ([]ChunkData, error) {
+	dir := filepath.Join(dbDir, name)
 
-	file, err := os.Open(chunkpath)
-	shared.NoErr(err)
-	defer file.Close()
+	chunkData, err := readSeriesFromFile(filepath.Join(dir, "chunk"), seriesIndex)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &ErrChunkNotFound{Dir: dir, Err: err}
+		}
+		if _, ok := err.(*os.PathError); ok {
+			return nil, err
+		}
+		return nil, &ErrCorruptChunk{Dir: dir, Err: err}
+	}
 
-	scanner := bufio.NewScanner(file)
-	scanner.Split(bufio.ScanRunes)
-	chunkData := make([]ChunkData, 0)
+	return chunkData, nil
+}
+
+// readChunkIndex reads the postings index of a chunk. Chunks written before
+// they had one get it built from their meta.
+func readChunkIndex(dbDir string, name string, meta ChunkMeta) (*postingsIndex, error) {
+	dir := filepath.Join(dbDir, name)
 
-	line := 0
-	token := strings.Builder{}
-	newChunkData := ChunkData{
-		Series:    int64(seriesIndexInChunk),
-		Datapoint: Datapoint{},
+	data, err := os.ReadFile(filepath.Join(dir, "index"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return chunkPostingsIndex(meta), nil
+		}
+		return nil, err
 	}
 
-	for scanner.Scan() {
-		curr := scanner.Text()
+	index, err := decodePostingsIndex(data)
+	if err != nil {
+		return nil, &ErrCorruptChunk{Dir: dir, Err: err}
+	}
 
-		if curr == "\n" {
-			line++
+	return index, nil
+}
+
+// readChunk reads every series of a chunk back into memory.
+func readChunk(dbDir string, name string) (*Chunk, error) {
+	meta, err := readChunkMeta(dbDir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Chunk{
+		Meta: meta,
+		Data: []ChunkData{},
+	}
+
+	for idx := range meta.Series {
+		data, err := readChunkSeries(dbDir, name, idx)
+		if err != nil {
+			return nil, err
 		}
+		c.Merge(data)
+	}
 
-		if line == seriesIndexInChunk {
-			switch curr {
-			case "-":
-				timestamp, _ := strconv.ParseInt(token.String(), 10, 64)
-				newChunkData.Datapoint.Timestamp = timestamp
-				token.Reset()
-			case ",":
-				value, _ := strconv.ParseInt(token.String(), 10, 64)
-				newChunkData.Datapoint.Value = value
-				token.Reset()
-				chunkData = append(chunkData, newChunkData)
-			case "\n":
-				token.Reset()
-			default:
-				token.WriteString(curr)
-			}
+	return c, nil
+}
+
+// readLegacySeries scans a text chunk file, one series per line, for the line
+// of seriesIndex.
+func readLegacySeries(file io.Reader, seriesIndex int) ([]ChunkData, error) {
+	reader := bufio.NewReader(file)
+
+	for line := 0; ; line++ {
+		raw, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		if line == seriesIndex {
+			return parseSeriesLine(strings.TrimSuffix(raw, "\n"), seriesIndex)
 		}
 
-		if line > seriesIndexInChunk {
+		if err == io.EOF {
 			break
 		}
 	}
 
-	shared.NoErr(scanner.Err())
+	return []ChunkData{}, nil
+}
 
-	return chunkData
+// parseSeriesLine decodes one series line of a legacy text chunk file.
+func parseSeriesLine(raw string, lineNumber int) ([]ChunkData, error) {
+	if raw == "" {
+		return []ChunkData{}, nil
+	}
+
+	chunkData, err := parseRawString(raw, lineNumber)
+	if err != nil {
+		return nil, err
+	}
+	return DeltaDecodeChunk(chunkData), nil
 }
 
+// parseRawString reads the original "timestamp-value," line format where
+// values were integers stored as deltas. A value that went down has a
+// negative delta, as in "1--2,".
 func parseRawString(raw string, lineNumber int) ([]ChunkData, error) {
 	chunks := strings.Split(strings.TrimSuffix(raw, ","), ",")
 	result := make([]ChunkData, 0, len(chunks))
 
+	var prevValue int64
+
 	for _, chunk := range chunks {
-		datapointParts := strings.Split(chunk, "-")
-		if len(datapointParts) != 2 {
+		rawTimestamp, rawValue, found := strings.Cut(chunk, "-")
+		if !found {
 			return nil, fmt.Errorf("invalid chunk format: %s", chunk)
 		}
 
-		timestamp, err := strconv.ParseInt(datapointParts[0], 10, 64)
+		timestamp, err := strconv.ParseInt(rawTimestamp, 10, 64)
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse timestamp: %s", err)
 		}
 
-		value, err := strconv.ParseInt(datapointParts[1], 10, 64)
+		value, err := strconv.ParseInt(rawValue, 10, 64)
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse value: %s", err)
 		}
 
+		prevValue += value
+
 		result = append(result, ChunkData{
 			Series: int64(lineNumber),
 			Datapoint: Datapoint{
 				Timestamp: timestamp,
-				Value:     value,
+				Value:     float64(prevValue),
 			},
 		})
 	}
@@ -130,6 +194,8 @@ func parseRawString(raw string, lineNumber int) ([]ChunkData, error) {
 	return result, nil
 }
 
+// DeltaEncodeChunk replaces every timestamp with its distance to the previous
+// one. Values are left alone, they are compressed with Gorilla XOR instead.
 func DeltaEncodeChunk(chunks []ChunkData) []ChunkData {
 	compressed := make([]ChunkData, len(chunks))
 
@@ -138,7 +204,6 @@ func DeltaEncodeChunk(chunks []ChunkData) []ChunkData {
 
 		if idx != 0 {
 			compressed[idx].Datapoint.Timestamp = compressed[idx].Datapoint.Timestamp - chunks[idx-1].Datapoint.Timestamp
-			compressed[idx].Datapoint.Value = compressed[idx].Datapoint.Value - chunks[idx-1].Datapoint.Value
 		}
 	}
 
@@ -148,18 +213,118 @@ func DeltaEncodeChunk(chunks []ChunkData) []ChunkData {
 func DeltaDecodeChunk(chunks []ChunkData) []ChunkData {
 	orig := make([]ChunkData, len(chunks))
 
-	prev := 0
-	prevVal := 0
+	var prev int64
 
 	for idx, data := range chunks {
 		orig[idx] = data
 
-		prev += int(data.Datapoint.Timestamp)
-		prevVal += int(data.Datapoint.Value)
+		prev += data.Datapoint.Timestamp
 
-		orig[idx].Datapoint.Timestamp = int64(prev)
-		orig[idx].Datapoint.Value = int64(prevVal)
+		orig[idx].Datapoint.Timestamp = prev
 	}
 
 	return orig
 }
+
+func writeFileSync(filename string, data []byte) error {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return file.Sync()
+}
+
+// writeChunkDir writes the files of chunk into dir and syncs them.
+func writeChunkDir(dir string, chunk *Chunk) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+
+	metabytes, err := json.Marshal(chunk.Meta)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileSync(filepath.Join(dir, "meta.json"), metabytes); err != nil {
+		return err
+	}
+
+	if err := writeFileSync(filepath.Join(dir, "index"), encodePostingsIndex(chunkPostingsIndex(chunk.Meta))); err != nil {
+		return err
+	}
+
+	if err := writeFileSync(filepath.Join(dir, "chunk"), chunk.Encode()); err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// recoverChunkDirs cleans up after a chunk write that was interrupted. A
+// half written .tmp directory is dropped, and a chunk moved aside by a
+// replace of an older version is put back if its replacement never made it.
+// Chunks marked deleted, whose data was compacted into another one, are
+// removed.
+func recoverChunkDirs(dbDir string) error {
+	files, err := os.ReadDir(dbDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		path := filepath.Join(dbDir, name)
+
+		switch {
+		case strings.HasSuffix(name, ".tmp"):
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+		case strings.HasSuffix(name, ".old"):
+			dst := strings.TrimSuffix(path, ".old")
+			if _, err := os.Stat(dst); err == nil {
+				if err := os.RemoveAll(path); err != nil {
+					return err
+				}
+			} else if err := os.Rename(path, dst); err != nil {
+				return err
+			}
+		case file.IsDir():
+			if _, err := os.Stat(filepath.Join(path, deletedMarker)); err == nil {
+				if err := os.RemoveAll(path); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -6,7 +6,6 @@ import (
 	"path/filepath"
 	"testing"
 
-	"github.com/Marvin9/ftsdb/shared"
 	"github.com/stretchr/testify/require"
 )
 
@@ -24,13 +23,14 @@ func TestGetChunkMeta(t *testing.T) {
 		},
 	}
 
-	metapath := filepath.Join(shared.GetIngestionDir(), "0", "meta.json")
+	dir := t.TempDir()
+	metapath := filepath.Join(dir, "0", "meta.json")
 
 	b, err := json.Marshal(chunkMeta)
 
 	require.NoError(t, err)
 
-	err = os.MkdirAll(filepath.Join(shared.GetIngestionDir(), "0"), 0777)
+	err = os.MkdirAll(filepath.Join(dir, "0"), 0777)
 
 	require.NoError(t, err)
 
@@ -38,11 +38,11 @@ func TestGetChunkMeta(t *testing.T) {
 
 	require.NoError(t, err)
 
-	fetchedChunkMeta := GetChunkMeta(0)
+	fetchedChunkMeta, err := GetChunkMeta(dir, 0)
 
-	require.Equal(t, chunkMeta, fetchedChunkMeta)
+	require.NoError(t, err)
 
-	require.NoError(t, os.RemoveAll(metapath))
+	require.Equal(t, chunkMeta, fetchedChunkMeta)
 }
 
 func TestDeltaEncodeChunks(t *testing.T) {
@@ -56,7 +56,7 @@ func TestDeltaEncodeChunks(t *testing.T) {
 		{
 			Datapoint: Datapoint{
 				Timestamp: 200,
-				Value:     10,
+				Value:     10.5,
 			},
 		},
 		{
@@ -83,19 +83,19 @@ func TestDeltaEncodeChunks(t *testing.T) {
 		{
 			Datapoint: Datapoint{
 				Timestamp: 100,
-				Value:     -10,
+				Value:     10.5,
 			},
 		},
 		{
 			Datapoint: Datapoint{
 				Timestamp: 1,
-				Value:     5,
+				Value:     15,
 			},
 		},
 		{
 			Datapoint: Datapoint{
 				Timestamp: 1,
-				Value:     85,
+				Value:     100,
 			},
 		},
 	}, enc)
@@ -112,19 +112,19 @@ func TestDeltaDecodeChunks(t *testing.T) {
 		{
 			Datapoint: Datapoint{
 				Timestamp: 100,
-				Value:     -10,
+				Value:     10.5,
 			},
 		},
 		{
 			Datapoint: Datapoint{
 				Timestamp: 1,
-				Value:     5,
+				Value:     15,
 			},
 		},
 		{
 			Datapoint: Datapoint{
 				Timestamp: 1,
-				Value:     85,
+				Value:     100,
 			},
 		},
 	})
@@ -139,7 +139,7 @@ func TestDeltaDecodeChunks(t *testing.T) {
 		{
 			Datapoint: Datapoint{
 				Timestamp: 200,
-				Value:     10,
+				Value:     10.5,
 			},
 		},
 		{
@@ -156,3 +156,133 @@ func TestDeltaDecodeChunks(t *testing.T) {
 		},
 	}, orig)
 }
+
+func TestParseSeriesLine(t *testing.T) {
+	// the original format held integer deltas for both timestamps and values
+	parsed, err := parseSeriesLine("100-20,100-5,", 1)
+	require.NoError(t, err)
+	require.Equal(t, []ChunkData{
+		{Series: 1, Datapoint: Datapoint{Timestamp: 100, Value: 20}},
+		{Series: 1, Datapoint: Datapoint{Timestamp: 200, Value: 25}},
+	}, parsed)
+}
+
+func TestChunkFileRoundTrip(t *testing.T) {
+	chunk := NewChunk()
+	chunk.Meta.Series = []map[string]string{{"host": "mac"}, {"host": "win"}, {"host": "linux"}}
+
+	expected := make([][]ChunkData, len(chunk.Meta.Series))
+	for idx := range chunk.Meta.Series {
+		data := make([]ChunkData, 0)
+		for i := 0; i < 100*(idx+1); i++ {
+			data = append(data, ChunkData{
+				Series: int64(idx),
+				Datapoint: Datapoint{
+					Timestamp: int64(i * 50),
+					Value:     float64(i) * 0.25,
+				},
+			})
+		}
+		expected[idx] = data
+		chunk.Merge(data)
+	}
+
+	chunkpath := filepath.Join(t.TempDir(), "chunk")
+	require.NoError(t, os.WriteFile(chunkpath, chunk.Encode(), 0666))
+
+	// read back to front, every read seeks to its block through the index
+	for idx := len(expected) - 1; idx >= 0; idx-- {
+		data, err := readSeriesFromFile(chunkpath, idx)
+		require.NoError(t, err)
+		require.Equal(t, expected[idx], data)
+	}
+
+	_, err := readSeriesFromFile(chunkpath, len(expected))
+	require.Error(t, err)
+
+	raw, err := os.ReadFile(chunkpath)
+	require.NoError(t, err)
+	raw[chunkHeaderSize+1] ^= 0xff
+	require.NoError(t, os.WriteFile(chunkpath, raw, 0666))
+
+	_, err = readSeriesFromFile(chunkpath, 0)
+	require.ErrorContains(t, err, "checksum")
+}
+
+func TestLegacyTextChunkFile(t *testing.T) {
+	chunkpath := filepath.Join(t.TempDir(), "chunk")
+	require.NoError(t, os.WriteFile(chunkpath, []byte("1-10,1-1,1-1,\n1-20,1-2,\n1-5,1--2,1--4,1-3,"), 0666))
+
+	data, err := readSeriesFromFile(chunkpath, 1)
+	require.NoError(t, err)
+	require.Equal(t, []ChunkData{
+		{Series: 1, Datapoint: Datapoint{Timestamp: 1, Value: 20}},
+		{Series: 1, Datapoint: Datapoint{Timestamp: 2, Value: 22}},
+	}, data)
+
+	data, err = readSeriesFromFile(chunkpath, 0)
+	require.NoError(t, err)
+	require.Len(t, data, 3)
+
+	// values that went down were written as negative deltas
+	data, err = readSeriesFromFile(chunkpath, 2)
+	require.NoError(t, err)
+	require.Equal(t, []ChunkData{
+		{Series: 2, Datapoint: Datapoint{Timestamp: 1, Value: 5}},
+		{Series: 2, Datapoint: Datapoint{Timestamp: 2, Value: 3}},
+		{Series: 2, Datapoint: Datapoint{Timestamp: 3, Value: -1}},
+		{Series: 2, Datapoint: Datapoint{Timestamp: 4, Value: 2}},
+	}, data)
+}
+
+func TestGetChunkMetaErrors(t *testing.T) {
+	dbDir := t.TempDir()
+	dir := filepath.Join(dbDir, "7")
+	require.NoError(t, os.MkdirAll(dir, 0777))
+
+	_, err := GetChunkMeta(dbDir, 7)
+	var notFound *ErrChunkNotFound
+	require.ErrorAs(t, err, &notFound)
+	require.Equal(t, dir, notFound.Dir)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "meta.json"), []byte("{\"MinTimestamp\":"), 0666))
+
+	_, err = GetChunkMeta(dbDir, 7)
+	var corrupt *ErrCorruptChunk
+	require.ErrorAs(t, err, &corrupt)
+	require.Equal(t, dir, corrupt.Dir)
+}
+
+func TestRecoverChunkDirs(t *testing.T) {
+	dbDir := t.TempDir()
+
+	// a chunk moved aside by a replace that never finished
+	require.NoError(t, os.MkdirAll(filepath.Join(dbDir, "10.old"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(dbDir, "10.old", "meta.json"), []byte(`{"MinTimestamp":10}`), 0666))
+
+	// a replace that finished apart from removing the old chunk
+	require.NoError(t, os.MkdirAll(filepath.Join(dbDir, "20"), 0777))
+	require.NoError(t, os.MkdirAll(filepath.Join(dbDir, "20.old"), 0777))
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dbDir, "30.tmp"), 0777))
+
+	// a chunk compacted into another one, still read by a query when the
+	// process stopped
+	require.NoError(t, os.MkdirAll(filepath.Join(dbDir, "40"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(dbDir, "40", deletedMarker), nil, 0666))
+
+	require.NoError(t, recoverChunkDirs(dbDir))
+
+	files, err := os.ReadDir(dbDir)
+	require.NoError(t, err)
+
+	names := []string{}
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	require.Equal(t, []string{"10", "20"}, names)
+
+	meta, err := GetChunkMeta(dbDir, 10)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), meta.MinTimestamp)
+}
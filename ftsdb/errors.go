@@ -0,0 +1,45 @@
+package ftsdb
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownSeriesRef is returned by AppendRef for a ref that does not belong
+// to a series of the metric.
+var ErrUnknownSeriesRef = errors.New("unknown series ref")
+
+// ErrOutOfOrderSample and ErrDuplicateSample are returned by appends
+// rejected after SetRejectOutOfOrder.
+var (
+	ErrOutOfOrderSample = errors.New("out of order sample")
+	ErrDuplicateSample  = errors.New("duplicate sample for timestamp")
+)
+
+// ErrChunkNotFound is returned when a chunk directory or one of its files is missing.
+type ErrChunkNotFound struct {
+	Dir string
+	Err error
+}
+
+func (e *ErrChunkNotFound) Error() string {
+	return fmt.Sprintf("chunk %s not found: %s", e.Dir, e.Err)
+}
+
+func (e *ErrChunkNotFound) Unwrap() error {
+	return e.Err
+}
+
+// ErrCorruptChunk is returned when the meta or data of a chunk directory can not be decoded.
+type ErrCorruptChunk struct {
+	Dir string
+	Err error
+}
+
+func (e *ErrCorruptChunk) Error() string {
+	return fmt.Sprintf("corrupt chunk %s: %s", e.Dir, e.Err)
+}
+
+func (e *ErrCorruptChunk) Unwrap() error {
+	return e.Err
+}
@@ -1,16 +1,16 @@
 package ftsdb
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
-	"os"
 	"path/filepath"
 	"sort"
-	"strconv"
-	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/Marvin9/ftsdb/shared"
+	"github.com/Marvin9/ftsdb/gorilla"
 	"go.uber.org/zap"
 )
 
@@ -19,6 +19,13 @@ type Query struct {
 	rangeStart *int64
 	rangeEnd   *int64
 	series     map[string]string
+	labels     []labelValues
+	matchers   []*Matcher
+}
+
+type labelValues struct {
+	name   string
+	values []string
 }
 
 func (q *Query) Metric(metric string) *Query {
@@ -41,64 +48,308 @@ func (q *Query) Series(series map[string]string) *Query {
 	return q
 }
 
+// Label restricts the query to series carrying label name with one of
+// values, whatever other labels they have. Every Label call has to match.
+func (q *Query) Label(name string, values ...string) *Query {
+	q.labels = append(q.labels, labelValues{name: name, values: values})
+	return q
+}
+
+// Where restricts the query to series selected by every matcher.
+func (q *Query) Where(matchers ...*Matcher) *Query {
+	q.matchers = append(q.matchers, matchers...)
+	return q
+}
+
+// selectPostings returns the IDs of the series in index the query selects.
+// A query restricted by Series still has to check those for an exact match.
+func (q Query) selectPostings(index *postingsIndex) []uint64 {
+	lists := [][]uint64{}
+
+	if q.metric != nil {
+		lists = append(lists, index.get(MetricLabel, *q.metric))
+	}
+
+	for name, value := range q.series {
+		lists = append(lists, index.get(name, value))
+	}
+
+	for _, label := range q.labels {
+		union := make([][]uint64, len(label.values))
+		for idx, value := range label.values {
+			union[idx] = index.get(label.name, value)
+		}
+		lists = append(lists, unionPostings(union...))
+	}
+
+	for _, m := range q.matchers {
+		lists = append(lists, postingsForMatcher(index, m))
+	}
+
+	if len(lists) == 0 {
+		return index.all()
+	}
+
+	return intersectPostings(lists...)
+}
+
 type Iterator interface {
 	At() interface{}
 	Next() Iterator
 }
 
 type DBInterface interface {
-	Find(query Query) *SeriesIterator
+	Find(query Query) (*SeriesIterator, error)
 	CreateMetric(metric string) *ftsdbMetric
 	DisplayMetrics()
 	Commit() error
 	Close()
 	SetFlushLimit(flush int)
+	SetSkipCorruptChunks(skip bool)
+	SetRejectOutOfOrder(reject bool)
+	SetWALSyncPolicy(policy WALSyncPolicy, interval time.Duration) error
+	Compact() error
+	SetCompactionInterval(interval time.Duration)
+	SetRetention(maxAge time.Duration, maxBytes int64)
+	SetRetentionInterval(interval time.Duration)
+	EnforceRetention() error
+	RetentionMetrics() RetentionMetrics
+	Delete(matchers []*Matcher, mint, maxt int64) error
 }
 
+// ftsdbInMemory is the head, the datapoints appended since the last commit.
+//
+// It is safe for concurrent use. The metric list is guarded by mtx, series of
+// a metric are spread over lock striped hash maps, and each series guards its
+// own datapoints. Locks are always taken in the order head, stripe, series,
+// wal.
 type ftsdbInMemory struct {
-	metric *ftsdbMetric
-	logger *zap.Logger
+	mtx           sync.RWMutex
+	metric        *ftsdbMetric
+	metricsByName map[string]*ftsdbMetric
+	logger        *zap.Logger
+	wal           *wal
+	lastSeriesRef atomic.Uint64
+	refs          [seriesStripes]refStripe
+	// postings of the head by series ref
+	postingsMtx sync.RWMutex
+	postings    *postingsIndex
+	// rejectOutOfOrder makes appends older than the newest datapoint of
+	// their series fail
+	rejectOutOfOrder atomic.Bool
+}
+
+type refStripe struct {
+	mtx    sync.RWMutex
+	series map[SeriesRef]*ftsdbSeries
 }
 
 func newFtsdbInMemory(logger *zap.Logger) *ftsdbInMemory {
-	return &ftsdbInMemory{
-		logger: logger,
+	ftsdbim := &ftsdbInMemory{
+		logger:        logger,
+		metricsByName: map[string]*ftsdbMetric{},
+		postings:      newPostingsIndex(),
 	}
+	for idx := range ftsdbim.refs {
+		ftsdbim.refs[idx].series = map[SeriesRef]*ftsdbSeries{}
+	}
+	return ftsdbim
 }
 
 func (ftsdbim *ftsdbInMemory) createMetric(metric string) *ftsdbMetric {
-	// ftsdbim.logger.Debug("creating metric", zap.String("metric", metric))
+	ftsdbim.mtx.RLock()
+	existing, found := ftsdbim.metricsByName[metric]
+	ftsdbim.mtx.RUnlock()
+
+	if found {
+		return existing
+	}
+
+	ftsdbim.mtx.Lock()
+	defer ftsdbim.mtx.Unlock()
+
+	if existing, found := ftsdbim.metricsByName[metric]; found {
+		return existing
+	}
+
 	newMetric := NewMetric(metric, ftsdbim.logger.Named("metric-"+metric))
+	newMetric.inMemory = ftsdbim
 
+	// the list keeps metrics in creation order, which is the order they
+	// are written to chunks in
 	itr := &ftsdbim.metric
 	for *itr != nil {
-		if (*itr).metric == metric {
-			// ftsdbim.logger.Debug("found existing metric")
-			return *itr
-		}
 		itr = &(*itr).next
 	}
-
 	*itr = newMetric
 
-	// ftsdbim.logger.Debug("created new metric")
-	return *itr
+	ftsdbim.metricsByName[metric] = newMetric
+
+	return newMetric
+}
+
+func (ftsdbim *ftsdbInMemory) registerRef(series *ftsdbSeries) {
+	stripe := &ftsdbim.refs[uint64(series.ref)%seriesStripes]
+
+	stripe.mtx.Lock()
+	stripe.series[series.ref] = series
+	stripe.mtx.Unlock()
+}
+
+func (ftsdbim *ftsdbInMemory) indexSeries(series *ftsdbSeries) {
+	ftsdbim.postingsMtx.Lock()
+	ftsdbim.postings.add(uint64(series.ref), series.metric.metric, series.labels)
+	ftsdbim.postingsMtx.Unlock()
+}
+
+// selectPostings returns the refs of the head series the query selects.
+func (ftsdbim *ftsdbInMemory) selectPostings(query Query) []uint64 {
+	ftsdbim.postingsMtx.RLock()
+	defer ftsdbim.postingsMtx.RUnlock()
+
+	// the lists are modified in place by later inserts
+	return append([]uint64(nil), query.selectPostings(ftsdbim.postings)...)
+}
+
+func (ftsdbim *ftsdbInMemory) seriesByRef(ref SeriesRef) *ftsdbSeries {
+	stripe := &ftsdbim.refs[uint64(ref)%seriesStripes]
+
+	stripe.mtx.RLock()
+	defer stripe.mtx.RUnlock()
+
+	return stripe.series[ref]
+}
+
+// metrics returns the metrics known at the time of the call. Metrics are
+// never removed from the head, so the result stays valid.
+func (ftsdbim *ftsdbInMemory) metrics() []*ftsdbMetric {
+	ftsdbim.mtx.RLock()
+	defer ftsdbim.mtx.RUnlock()
+
+	metrics := []*ftsdbMetric{}
+	for itr := ftsdbim.metric; itr != nil; itr = itr.next {
+		metrics = append(metrics, itr)
+	}
+	return metrics
+}
+
+func (ftsdbim *ftsdbInMemory) size() int64 {
+	var size int64
+	for _, metric := range ftsdbim.metrics() {
+		size += metric.size.Load()
+	}
+	return size
 }
 
 type ftsdb struct {
-	inMemory   *ftsdbInMemory
-	logger     *zap.Logger
-	dir        string
-	flushLimit int
+	// commitMtx serializes commits, appends never wait on it
+	commitMtx sync.Mutex
+	// chunksMtx is held by Find while it lists chunk directories and by
+	// writers adding or replacing them
+	chunksMtx         sync.RWMutex
+	readers           *chunkReaders
+	inMemory          *ftsdbInMemory
+	logger            *zap.Logger
+	dir               string
+	flushLimit        int
+	skipCorruptChunks bool
+	compactor         compactor
+	retention         retention
 }
 
-func NewFTSDB(logger *zap.Logger, dir string) DBInterface {
-	return &ftsdb{
+// NewFTSDB opens the database stored in dir. Appends that were not committed
+// before the previous process stopped are recovered from the write-ahead log.
+func NewFTSDB(logger *zap.Logger, dir string) (DBInterface, error) {
+	ftsdb := &ftsdb{
 		logger:     logger,
+		readers:    newChunkReaders(dir, logger.Named("readers")),
 		inMemory:   newFtsdbInMemory(logger.Named("inMemory")),
 		dir:        dir,
 		flushLimit: 1000,
+		compactor:  compactor{ranges: DefaultCompactionRanges},
+	}
+
+	if err := recoverChunkDirs(dir); err != nil {
+		return nil, err
+	}
+
+	walDir := filepath.Join(dir, walDirName)
+
+	if err := ftsdb.replayWAL(walDir); err != nil {
+		return nil, err
+	}
+
+	wal, err := openWAL(walDir, logger.Named("wal"))
+	if err != nil {
+		return nil, err
+	}
+
+	ftsdb.inMemory.wal = wal
+
+	return ftsdb, nil
+}
+
+func (ftsdb *ftsdb) replayWAL(dir string) error {
+	seriesByRef := map[uint64]*ftsdbSeries{}
+	metricByRef := map[uint64]*ftsdbMetric{}
+
+	// A commit re-logs the series it keeps after cutting the log, so a
+	// sample appended meanwhile can come before the record of its series.
+	pending := map[uint64][]*ftsdbDataPoint{}
+
+	replayed := 0
+
+	insert := func(ref uint64, dp *ftsdbDataPoint) {
+		seriesByRef[ref].dataPoints.Insert(dp)
+		seriesByRef[ref].observe(dp)
+		metricByRef[ref].size.Add(1)
+		replayed++
+	}
+
+	err := readWAL(dir, ftsdb.logger.Named("wal"), func(rec walRecord) error {
+		switch rec.typ {
+		case walRecordSeries:
+			if _, found := seriesByRef[rec.ref]; found {
+				return nil
+			}
+
+			metric := ftsdb.inMemory.createMetric(rec.metric)
+			series := metric.createSeries(LabelsFromMap(rec.series), SeriesRef(rec.ref))
+			series.logged = true
+
+			seriesByRef[rec.ref] = series
+			metricByRef[rec.ref] = metric
+
+			for _, dp := range pending[rec.ref] {
+				insert(rec.ref, dp)
+			}
+			delete(pending, rec.ref)
+		case walRecordSample:
+			dp := newDataPoint(rec.timestamp, rec.value)
+
+			if _, found := seriesByRef[rec.ref]; !found {
+				pending[rec.ref] = append(pending[rec.ref], dp)
+				return nil
+			}
+
+			insert(rec.ref, dp)
+		}
+		return nil
+	})
+
+	for ref := range pending {
+		ftsdb.logger.Warn("wal sample for unknown series", zap.Uint64("ref", ref))
 	}
+
+	if replayed > 0 {
+		ftsdb.logger.Info("replayed wal", zap.Int("samples", replayed))
+	}
+
+	return err
+}
+
+func (ftsdb *ftsdb) SetWALSyncPolicy(policy WALSyncPolicy, interval time.Duration) error {
+	return ftsdb.inMemory.wal.setSyncPolicy(policy, interval)
 }
 
 func (ftsdb *ftsdb) SetFlushLimit(limit int) {
@@ -107,29 +358,38 @@ func (ftsdb *ftsdb) SetFlushLimit(limit int) {
 	}
 }
 
+// SetSkipCorruptChunks makes Find log and leave out chunk directories that
+// are missing files or can not be decoded, instead of failing the query.
+func (ftsdb *ftsdb) SetSkipCorruptChunks(skip bool) {
+	ftsdb.skipCorruptChunks = skip
+}
+
+// SetRejectOutOfOrder makes appends fail with ErrOutOfOrderSample when they
+// are older than the newest datapoint of their series, and with
+// ErrDuplicateSample when they have its timestamp but another value. An
+// append repeating the newest datapoint is ignored. Only datapoints appended
+// or replayed since the database was opened are considered.
+func (ftsdb *ftsdb) SetRejectOutOfOrder(reject bool) {
+	ftsdb.inMemory.rejectOutOfOrder.Store(reject)
+}
+
 func (ftsdb *ftsdb) DisplayMetrics() {
 	ftsdb.logger.Info("display-metrics")
-	itr := ftsdb.inMemory.metric
 
-	for itr != nil {
+	for _, metric := range ftsdb.inMemory.metrics() {
 		ftsdb.logger.Info("--")
-		ftsdb.logger.Info("metric", zap.String("name", itr.metric), zap.Bool("has-next", itr.next != nil))
-
-		seriesItr := itr.series
+		ftsdb.logger.Info("metric", zap.String("name", metric.metric))
 
-		for seriesItr != nil {
-			dataPointsItr := 0
+		for _, series := range metric.allSeries() {
+			ftsdb.logger.Info("series", zap.Any("series", series.series))
 
-			ftsdb.logger.Info("series", zap.Any("series", seriesItr.series))
-			for dataPointsItr < seriesItr.dataPoints.size {
-				val := seriesItr.dataPoints.At(dataPointsItr).(*ftsdbDataPoint)
-				ftsdb.logger.Info("data-point", zap.Int64("timestamp", val.timestamp), zap.Float64("value", val.value))
-				dataPointsItr++
+			for _, run := range series.snapshot(nil) {
+				for _, val := range run {
+					dp := val.(*ftsdbDataPoint)
+					ftsdb.logger.Info("data-point", zap.Int64("timestamp", dp.timestamp), zap.Float64("value", dp.value))
+				}
 			}
-			seriesItr = seriesItr.next
 		}
-
-		itr = itr.next
 	}
 }
 
@@ -152,23 +412,50 @@ type DataPointsIterator interface {
 }
 
 type Series struct {
+	Metric      string
 	SeriesValue map[string]string
 }
 
 type Datapoint struct {
 	Timestamp int64
-	Value     int64
+	Value     float64
 }
 
 type DatapointsIterator struct {
 	Next         func() *DatapointsIterator
 	GetDatapoint func() Datapoint
+	err          error
+}
+
+// Err returns the error that stopped the iteration early, if any.
+func (it *DatapointsIterator) Err() error {
+	return it.err
 }
 
 type SeriesIterator struct {
 	Next               func() *SeriesIterator
 	GetSeries          func() Series
 	DatapointsIterator *DatapointsIterator
+	err                error
+	close              func()
+	closed             bool
+}
+
+// Err returns the error that stopped the iteration early, if any. Errors hit
+// while reading datapoints of a series are reported here as well.
+func (it *SeriesIterator) Err() error {
+	return it.err
+}
+
+// Close releases the chunks read by the iteration, so that compaction and
+// retention can remove them, and ends it. Iterating to the end or to an error
+// closes the iterator as well. One that is dropped without either is closed
+// once it is garbage collected, which may take long.
+func (it *SeriesIterator) Close() {
+	it.closed = true
+	if it.close != nil {
+		it.close()
+	}
 }
 
 type ChunkData struct {
@@ -180,6 +467,16 @@ type ChunkMeta struct {
 	MinTimestamp int64
 	MaxTimestamp int64
 	Series       []map[string]string
+	// Metrics[i] is the metric of Series[i]. Chunks written before metrics
+	// were recorded leave it empty.
+	Metrics []string `json:",omitempty"`
+}
+
+func (cm ChunkMeta) MetricAt(idx int) string {
+	if idx < len(cm.Metrics) {
+		return cm.Metrics[idx]
+	}
+	return ""
 }
 
 type Chunk struct {
@@ -187,24 +484,29 @@ type Chunk struct {
 	Data []ChunkData
 }
 
+// Encode serializes the chunk in the binary chunk file format, one block of
+// Gorilla compressed timestamps and values per series.
 func (c *Chunk) Encode() []byte {
-	encodedData := strings.Builder{}
-
 	totalDistinctSeriesInChunk := len(c.Meta.Series)
-	lines := make([]strings.Builder, totalDistinctSeriesInChunk)
+	timestamps := make([]*gorilla.TimestampEncoder, totalDistinctSeriesInChunk)
+	values := make([]*gorilla.XOREncoder, totalDistinctSeriesInChunk)
+
+	for idx := range values {
+		timestamps[idx] = gorilla.NewTimestampEncoder()
+		values[idx] = gorilla.NewXOREncoder()
+	}
 
 	for _, data := range c.Data {
-		lines[int(data.Series)].WriteString(fmt.Sprintf("%s-%s,", strconv.Itoa(int(data.Datapoint.Timestamp)), strconv.Itoa(int(data.Datapoint.Value))))
+		timestamps[int(data.Series)].Encode(data.Datapoint.Timestamp)
+		values[int(data.Series)].Encode(data.Datapoint.Value)
 	}
 
-	for idx, line := range lines {
-		encodedData.WriteString(line.String())
-		if idx < len(lines)-1 {
-			encodedData.WriteString("\n")
-		}
+	blocks := make([][]byte, totalDistinctSeriesInChunk)
+	for idx := range blocks {
+		blocks[idx] = encodeSeriesBlock(timestamps[idx], values[idx])
 	}
 
-	return []byte(encodedData.String())
+	return encodeChunkFile(blocks)
 }
 
 func (c *Chunk) Merge(data []ChunkData) {
@@ -223,6 +525,11 @@ func (c *Chunk) Merge(data []ChunkData) {
 			j--
 		}
 	}
+	for i >= 0 {
+		mergedData[index] = c.Data[i]
+		index--
+		i--
+	}
 	for j >= 0 {
 		mergedData[index] = data[j]
 		index--
@@ -232,6 +539,46 @@ func (c *Chunk) Merge(data []ChunkData) {
 	c.Data = mergedData
 }
 
+// MergeChunk adds the series and datapoints of other to the chunk.
+func (c *Chunk) MergeChunk(other *Chunk) {
+	remap := make([]int64, len(other.Meta.Series))
+
+	for idx, series := range other.Meta.Series {
+		metric := other.Meta.MetricAt(idx)
+
+		remap[idx] = -1
+		for existingIdx, existing := range c.Meta.Series {
+			if c.Meta.MetricAt(existingIdx) == metric && seriesMatched(existing, series) {
+				remap[idx] = int64(existingIdx)
+				break
+			}
+		}
+
+		if remap[idx] == -1 {
+			c.Meta.Series = append(c.Meta.Series, series)
+			c.Meta.Metrics = append(c.Meta.Metrics, metric)
+			remap[idx] = int64(len(c.Meta.Series) - 1)
+		}
+	}
+
+	data := make([]ChunkData, len(other.Data))
+	for idx, d := range other.Data {
+		data[idx] = ChunkData{
+			Series:    remap[d.Series],
+			Datapoint: d.Datapoint,
+		}
+	}
+
+	c.Merge(data)
+
+	if other.Meta.MinTimestamp < c.Meta.MinTimestamp {
+		c.Meta.MinTimestamp = other.Meta.MinTimestamp
+	}
+	if other.Meta.MaxTimestamp > c.Meta.MaxTimestamp {
+		c.Meta.MaxTimestamp = other.Meta.MaxTimestamp
+	}
+}
+
 func NewChunk() *Chunk {
 	return &Chunk{
 		Meta: ChunkMeta{
@@ -243,104 +590,170 @@ func NewChunk() *Chunk {
 	}
 }
 
+// Commit writes the head to a new chunk once it holds at least the flush
+// limit of datapoints. The head is cut under short per series locks, so
+// appends carry on while the chunk is encoded and written. Until the chunk
+// is in place the cut datapoints stay visible to Find.
 func (ftsdb *ftsdb) Commit() error {
-	if ftsdb.inMemory.metric.size < int64(ftsdb.flushLimit) {
+	ftsdb.commitMtx.Lock()
+	defer ftsdb.commitMtx.Unlock()
+
+	return ftsdb.commit(ftsdb.flushLimit)
+}
+
+// commit writes the head to a new chunk if it holds at least flushLimit
+// datapoints. The caller holds commitMtx.
+func (ftsdb *ftsdb) commit(flushLimit int) error {
+	size := ftsdb.inMemory.size()
+	if size == 0 || size < int64(flushLimit) {
 		return nil
 	}
 
-	// ftsdb.logger.Debug("commit request")
-	chunk := NewChunk()
+	// every sample logged before the cut is part of the chunk, so the
+	// segments before it can go once the chunk is written
+	cut, err := ftsdb.inMemory.wal.cut()
+	if err != nil {
+		return err
+	}
 
-	itr := ftsdb.inMemory.metric.series
+	chunk := NewChunk()
+	committed := []*ftsdbSeries{}
 
 	seriedIdxInMeta := -1
-	for itr != nil {
-		chunk.Meta.Series = append(chunk.Meta.Series, itr.series)
-		seriedIdxInMeta++
-
-		chunkData := make([]ChunkData, len(itr.dataPoints.arr))
-		for idx, val := range itr.dataPoints.arr {
-			dp := val.(*ftsdbDataPoint)
-			chunkData[idx] = ChunkData{
-				Series: int64(seriedIdxInMeta),
-				Datapoint: Datapoint{
-					Timestamp: dp.timestamp,
-					Value:     int64(dp.value),
-				},
+	for _, metric := range ftsdb.inMemory.metrics() {
+		for _, series := range metric.allSeries() {
+			dataPoints, err := series.cut(ftsdb.inMemory.wal)
+			if err != nil {
+				ftsdb.restore(committed)
+				return err
 			}
 
-			if dp.timestamp < chunk.Meta.MinTimestamp {
-				chunk.Meta.MinTimestamp = dp.timestamp
-			} else if dp.timestamp > chunk.Meta.MaxTimestamp {
-				chunk.Meta.MaxTimestamp = dp.timestamp
-			}
-		}
+			metric.size.Add(-int64(len(dataPoints)))
+			committed = append(committed, series)
 
-		compressed := DeltaEncodeChunk(chunkData)
+			appendSeriesToChunk(chunk, metric.metric, series.series, dataPoints, &seriedIdxInMeta)
+		}
+	}
 
-		chunk.Merge(compressed)
+	if err := ftsdb.writeChunk(chunk); err != nil {
+		ftsdb.restore(committed)
+		return err
+	}
 
-		itr = itr.next
+	for _, series := range committed {
+		series.mtx.Lock()
+		series.committing = nil
+		series.mtx.Unlock()
 	}
 
-	// ftsdb.logger.Debug("chunk generated")
+	return ftsdb.inMemory.wal.truncateBefore(cut)
+}
 
-	dir := filepath.Join(ftsdb.dir, fmt.Sprintf("%d", chunk.Meta.MinTimestamp))
+// restore puts datapoints of a failed commit back into the head, merged by
+// timestamp with the ones appended while it ran, which may be older.
+func (ftsdb *ftsdb) restore(committed []*ftsdbSeries) {
+	for _, series := range committed {
+		series.mtx.Lock()
 
-	if err := os.MkdirAll(dir, 0777); err != nil {
-		if !os.IsExist(err) {
-			return err
-		}
-	}
+		restored := NewFastArray()
+		restored.arr = mergeRuns(series.committing, series.dataPoints.arr[:series.dataPoints.size])
+		restored.size = len(restored.arr)
 
-	metafilename := filepath.Join(dir, "meta.json")
+		series.metric.size.Add(int64(len(series.committing)))
 
-	metabytes, err := json.Marshal(chunk.Meta)
+		series.dataPoints = restored
+		series.committing = nil
 
-	if err != nil {
-		return err
+		series.mtx.Unlock()
 	}
+}
 
-	// ftsdb.logger.Debug("writing meta", zap.String("filename", metafilename))
+// mergeRuns merges two runs of datapoints sorted by timestamp into a new
+// one. Of datapoints with the same timestamp, those of a come first.
+func mergeRuns(a, b []interface{}) []interface{} {
+	merged := make([]interface{}, 0, len(a)+len(b))
 
-	if err = os.WriteFile(metafilename, metabytes, 0666); err != nil {
-		return err
+	for len(a) > 0 && len(b) > 0 {
+		if b[0].(*ftsdbDataPoint).timestamp < a[0].(*ftsdbDataPoint).timestamp {
+			merged = append(merged, b[0])
+			b = b[1:]
+			continue
+		}
+		merged = append(merged, a[0])
+		a = a[1:]
 	}
 
-	chunkfilename := filepath.Join(dir, "chunk")
+	merged = append(merged, a...)
+	return append(merged, b...)
+}
 
-	chunkbytes := chunk.Encode()
+func appendSeriesToChunk(chunk *Chunk, metric string, series map[string]string, dataPoints []interface{}, seriedIdxInMeta *int) {
+	if len(dataPoints) == 0 {
+		return
+	}
 
-	// ftsdb.logger.Debug("writing chunk", zap.String("chunk", chunkfilename))
+	chunk.Meta.Series = append(chunk.Meta.Series, series)
+	chunk.Meta.Metrics = append(chunk.Meta.Metrics, metric)
+	*seriedIdxInMeta++
+
+	chunkData := make([]ChunkData, len(dataPoints))
+	for idx, val := range dataPoints {
+		dp := val.(*ftsdbDataPoint)
+		chunkData[idx] = ChunkData{
+			Series: int64(*seriedIdxInMeta),
+			Datapoint: Datapoint{
+				Timestamp: dp.timestamp,
+				Value:     dp.value,
+			},
+		}
 
-	if err = os.WriteFile(chunkfilename, chunkbytes, 0666); err != nil {
+		if dp.timestamp < chunk.Meta.MinTimestamp {
+			chunk.Meta.MinTimestamp = dp.timestamp
+		}
+		if dp.timestamp > chunk.Meta.MaxTimestamp {
+			chunk.Meta.MaxTimestamp = dp.timestamp
+		}
+	}
+
+	chunk.Merge(chunkData)
+}
+
+// writeChunk writes the chunk to a new directory, through a temporary one
+// renamed into place, so a concurrent Find sees either all of it or none of
+// it.
+func (ftsdb *ftsdb) writeChunk(chunk *Chunk) error {
+	name, err := ftsdb.freeChunkDirName(chunk.Meta.MinTimestamp)
+	if err != nil {
 		return err
 	}
 
-	ftsdb.inMemory = newFtsdbInMemory(ftsdb.logger)
+	tmp := filepath.Join(ftsdb.dir, name+".tmp")
 
-	return nil
+	if err := writeChunkDir(tmp, chunk); err != nil {
+		return err
+	}
+
+	return ftsdb.swapChunkDirs(tmp, name, nil)
 }
 
-func (ftsdb *ftsdb) Find(query Query) *SeriesIterator {
-	currentDirectory := shared.GetIngestionDir()
+func (ftsdb *ftsdb) Find(query Query) (*SeriesIterator, error) {
+	// The head is captured before chunks are listed. A commit finishing in
+	// between then shows up in both, which the merge deduplicates, rather
+	// than in neither.
+	head := ftsdb.snapshotHead(query)
 
-	files, _ := os.ReadDir(currentDirectory)
+	ftsdb.chunksMtx.RLock()
+	defer ftsdb.chunksMtx.RUnlock()
 
-	minTimestamps := []int{}
-	for _, file := range files {
-		if file.IsDir() {
-			minTimestamp, _ := strconv.Atoi(file.Name())
-			minTimestamps = append(minTimestamps, minTimestamp)
-		}
+	dirs, err := ftsdb.listChunkDirs()
+	if err != nil {
+		return nil, err
 	}
 
-	sort.Ints(minTimestamps)
-
 	lowerBound := 0
 	if query.rangeStart != nil {
-		for idx, value := range minTimestamps {
-			if value > int(*query.rangeStart) {
+		for idx, dir := range dirs {
+			if dir.minTimestamp > *query.rangeStart {
 				lowerBound = idx - 1
 
 				if lowerBound == -1 {
@@ -349,100 +762,151 @@ func (ftsdb *ftsdb) Find(query Query) *SeriesIterator {
 				break
 			}
 		}
+
+		// directories starting together may all hold the range start
+		for lowerBound > 0 && dirs[lowerBound-1].minTimestamp == dirs[lowerBound].minTimestamp {
+			lowerBound--
+		}
 	}
 
-	minTimestamps = minTimestamps[lowerBound:]
+	dirs = dirs[lowerBound:]
 
-	upperBound := len(minTimestamps)
+	upperBound := len(dirs)
 	if query.rangeEnd != nil {
-		for idx, value := range minTimestamps {
-			if value > int(*query.rangeEnd) {
+		for idx, dir := range dirs {
+			if dir.minTimestamp > *query.rangeEnd {
 				upperBound = idx
 				break
 			}
 		}
 	}
 
-	minTimestamps = minTimestamps[:upperBound]
+	dirs = dirs[:upperBound]
 
-	metaCache := map[int]ChunkMeta{}
+	// the chunks are read lazily, after the lock is released, so they are
+	// kept from being removed by a compaction until the iterator is closed
+	lease := ftsdb.readers.acquire(dirs)
 
-	for _, minTimestamp := range minTimestamps {
-		metaCache[minTimestamp] = GetChunkMeta(minTimestamp)
+	metaCache := map[string]ChunkMeta{}
+	indexCache := map[string]*postingsIndex{}
+	tombstonesCache := map[string]tombstones{}
+
+	readableChunks := make([]chunkDir, 0, len(dirs))
+	for _, dir := range dirs {
+		meta, err := readChunkMeta(ftsdb.dir, dir.name)
+		if err == nil {
+			indexCache[dir.name], err = readChunkIndex(ftsdb.dir, dir.name, meta)
+		}
+		if err == nil {
+			tombstonesCache[dir.name], err = readTombstones(ftsdb.dir, dir.name)
+		}
+		if err != nil {
+			if ftsdb.skipCorruptChunks && isChunkError(err) {
+				ftsdb.logger.Warn("skipping chunk", zap.Error(err))
+				continue
+			}
+			lease.release()
+			return nil, err
+		}
+		metaCache[dir.name] = meta
+		readableChunks = append(readableChunks, dir)
 	}
 
-	seriesToIterate := make([]map[string]string, 0)
+	dirs = readableChunks
 
-	getSeries := func(series map[string]string) int {
-		for idx, existingSeries := range seriesToIterate {
-			if seriesMatched(existingSeries, series) {
-				return idx
-			}
+	seriesToIterate := make([]Series, 0)
+	seriesIndex := map[string]int{}
+
+	getSeries := func(metric string, series map[string]string) int {
+		key := seriesKey(metric, LabelsFromMap(series))
+
+		idx, found := seriesIndex[key]
+		if !found {
+			seriesToIterate = append(seriesToIterate, Series{
+				Metric:      metric,
+				SeriesValue: series,
+			})
+			idx = len(seriesToIterate) - 1
+			seriesIndex[key] = idx
 		}
-		return -1
+		return idx
 	}
 
-	for _, minTimestamp := range minTimestamps {
-		meta := metaCache[minTimestamp]
+	// position of every series to iterate inside of the chunks holding it
+	chunkSeries := map[string]map[int]int{}
 
-		for _, series := range meta.Series {
-			if getSeries(series) == -1 {
-				// only required series
-				if query.series == nil || seriesMatched(query.series, series) {
-					seriesToIterate = append(seriesToIterate, series)
-				}
+	for _, dir := range dirs {
+		meta := metaCache[dir.name]
+		inChunk := map[int]int{}
+
+		for _, id := range query.selectPostings(indexCache[dir.name]) {
+			if id >= uint64(len(meta.Series)) {
+				continue
+			}
+
+			// a series deleted from all of the chunk is not in it anymore
+			if tombstonesCache[dir.name].covers(int(id), meta.MinTimestamp, meta.MaxTimestamp) {
+				continue
 			}
+
+			series := meta.Series[id]
+			if query.series != nil && !seriesMatched(query.series, series) {
+				continue
+			}
+
+			inChunk[getSeries(meta.MetricAt(int(id)), series)] = int(id)
 		}
+
+		chunkSeries[dir.name] = inChunk
+	}
+
+	headDatapoints := map[int][][]interface{}{}
+
+	for _, series := range head {
+		headDatapoints[getSeries(series.Metric, series.SeriesValue)] = series.runs
 	}
 
-	ss := &SeriesIterator{}
+	ss := &SeriesIterator{close: lease.release}
 
 	seriesIterator := -1
 	Next := func() *SeriesIterator {
+		if ss.err != nil || ss.closed {
+			return nil
+		}
+
 		seriesIterator++
 
 		if seriesIterator >= len(seriesToIterate) {
+			lease.release()
 			return nil
 		}
 
-		var datapoints []ChunkData
+		merged := newMergedDatapoints(ftsdb.datapointSources(query, dirs, metaCache, tombstonesCache, chunkSeries, seriesIterator, headDatapoints[seriesIterator]))
 
 		dd := &DatapointsIterator{}
 
-		dataPointsIterator := -1
-		chunkIterator := 0
 		Next := func() *DatapointsIterator {
-			dataPointsIterator++
-			if dataPointsIterator == 0 {
-				chunkIndex := minTimestamps[chunkIterator]
-				datapoints = DeltaDecodeChunk(ReadSeries(chunkIndex, metaCache[chunkIndex], seriesToIterate[seriesIterator]))
-			}
-
-			if dataPointsIterator >= len(datapoints) {
-				chunkIterator++
-				dataPointsIterator = 0
-			}
-
-			if chunkIterator >= len(minTimestamps) {
+			if dd.err != nil || ss.closed {
 				return nil
 			}
 
-			if query.rangeStart != nil && dd.GetDatapoint().Timestamp < *query.rangeStart {
-				for dataPointsIterator < len(datapoints) && datapoints[dataPointsIterator].Datapoint.Timestamp < *query.rangeStart {
-					dataPointsIterator++
+			if !merged.Next() {
+				if err := merged.Err(); err != nil {
+					dd.err = err
+					ss.err = err
+					lease.release()
 				}
+				return nil
 			}
 
-			if query.rangeEnd != nil && dd.GetDatapoint().Timestamp > *query.rangeEnd {
+			if query.rangeEnd != nil && merged.At().Timestamp > *query.rangeEnd {
 				return nil
 			}
 
 			return dd
 		}
 		dd.Next = Next
-		dd.GetDatapoint = func() Datapoint {
-			return datapoints[dataPointsIterator].Datapoint
-		}
+		dd.GetDatapoint = merged.At
 		ss.DatapointsIterator = dd
 
 		return ss
@@ -450,96 +914,422 @@ func (ftsdb *ftsdb) Find(query Query) *SeriesIterator {
 
 	ss.Next = Next
 	ss.GetSeries = func() Series {
-		return Series{
-			SeriesValue: seriesToIterate[seriesIterator],
+		return seriesToIterate[seriesIterator]
+	}
+	return ss, nil
+}
+
+type headSeries struct {
+	Series
+	runs [][]interface{}
+}
+
+// snapshotHead captures the datapoints of matching series not committed yet,
+// so that appends and commits running after Find do not change what the
+// query returns.
+func (ftsdb *ftsdb) snapshotHead(query Query) []headSeries {
+	head := []headSeries{}
+
+	for _, ref := range ftsdb.inMemory.selectPostings(query) {
+		series := ftsdb.inMemory.seriesByRef(SeriesRef(ref))
+		if series == nil {
+			continue
+		}
+
+		if query.series != nil && !seriesMatched(query.series, series.series) {
+			continue
+		}
+
+		runs := series.snapshot(query.rangeStart)
+		if len(runs) == 0 {
+			continue
+		}
+
+		head = append(head, headSeries{
+			Series: Series{
+				Metric:      series.metric.metric,
+				SeriesValue: series.series,
+			},
+			runs: runs,
+		})
+	}
+
+	return head
+}
+
+// datapointSources lists, in order of their first timestamp, the chunks and
+// head datapoints a series has to be merged from.
+func (ftsdb *ftsdb) datapointSources(query Query, dirs []chunkDir, metaCache map[string]ChunkMeta, tombstonesCache map[string]tombstones, chunkSeries map[string]map[int]int, series int, head [][]interface{}) []datapointSource {
+	sources := make([]datapointSource, 0, len(dirs)+len(head))
+
+	for _, dir := range dirs {
+		name := dir.name
+		meta := metaCache[name]
+		deleted := tombstonesCache[name]
+
+		seriesIndexInChunk, found := chunkSeries[name][series]
+		if !found {
+			continue
+		}
+
+		sources = append(sources, datapointSource{
+			minTimestamp: meta.MinTimestamp,
+			load: func() ([]Datapoint, error) {
+				chunkData, err := readChunkSeries(ftsdb.dir, name, seriesIndexInChunk)
+				if err != nil {
+					if ftsdb.skipCorruptChunks && isChunkError(err) {
+						ftsdb.logger.Warn("skipping chunk", zap.Error(err))
+						return nil, nil
+					}
+					return nil, err
+				}
+
+				datapoints := make([]Datapoint, 0, len(chunkData))
+				for _, data := range chunkData {
+					if query.rangeStart != nil && data.Datapoint.Timestamp < *query.rangeStart {
+						continue
+					}
+					if deleted.deleted(seriesIndexInChunk, data.Datapoint.Timestamp) {
+						continue
+					}
+					datapoints = append(datapoints, data.Datapoint)
+				}
+				return datapoints, nil
+			},
+		})
+	}
+
+	for _, run := range head {
+		headSource := datapointSource{
+			minTimestamp: run[0].(*ftsdbDataPoint).timestamp,
+			load: func() ([]Datapoint, error) {
+				datapoints := make([]Datapoint, len(run))
+				for idx, val := range run {
+					dp := val.(*ftsdbDataPoint)
+					datapoints[idx] = Datapoint{
+						Timestamp: dp.timestamp,
+						Value:     dp.value,
+					}
+				}
+				return datapoints, nil
+			},
+		}
+
+		position := len(sources)
+		for idx, source := range sources {
+			if headSource.minTimestamp < source.minTimestamp {
+				position = idx
+				break
+			}
 		}
+
+		sources = append(sources, datapointSource{})
+		copy(sources[position+1:], sources[position:])
+		sources[position] = headSource
 	}
-	return ss
+
+	return sources
+}
+
+func isChunkError(err error) bool {
+	var notFound *ErrChunkNotFound
+	var corrupt *ErrCorruptChunk
+	return errors.As(err, &notFound) || errors.As(err, &corrupt)
 }
 
 func (ftsdb *ftsdb) Close() {
+	ftsdb.compactor.loop.stop()
+	ftsdb.retention.loop.stop()
+
+	if err := ftsdb.inMemory.wal.close(); err != nil {
+		ftsdb.logger.Error("closing wal", zap.Error(err))
+	}
+
 	ftsdb.logger = nil
 	ftsdb.inMemory = nil
 }
 
 type MetricInterface interface {
-	Append(series map[string]interface{}, timestamp int64, value float64)
+	Append(series map[string]string, timestamp int64, value float64) error
+	Ref(series map[string]string) SeriesRef
+	AppendRef(ref SeriesRef, timestamp int64, value float64) error
 	Find(metrc string)
 }
 
+// seriesStripes is the number of independently locked series lists of a
+// metric. Appends to series in different stripes never contend.
+const seriesStripes = 16
+
+type seriesStripe struct {
+	mtx sync.RWMutex
+	// series by the hash of their labels, more than one on a collision
+	series map[uint64][]*ftsdbSeries
+}
+
 type ftsdbMetric struct {
-	metric string
-	series *ftsdbSeries
-	next   *ftsdbMetric
-	logger *zap.Logger
-	size   int64
+	metric   string
+	stripes  [seriesStripes]seriesStripe
+	next     *ftsdbMetric
+	logger   *zap.Logger
+	size     atomic.Int64
+	inMemory *ftsdbInMemory
 }
 
 func NewMetric(metric string, logger *zap.Logger) *ftsdbMetric {
-	return &ftsdbMetric{
+	fm := &ftsdbMetric{
 		metric: metric,
 		logger: logger,
-		series: nil,
-		size:   0,
 	}
+	for idx := range fm.stripes {
+		fm.stripes[idx].series = map[uint64][]*ftsdbSeries{}
+	}
+	return fm
 }
 
-func (fm *ftsdbMetric) Append(series map[string]string, timestamp int64, value float64) {
-	// fm.logger.Debug("appending series", zap.Any("series", series), zap.Int64("timestamp", timestamp), zap.Float64("value", value))
+// Append is safe to call from several goroutines, also for the same series.
+// Datapoints of a series are kept in timestamp order. Queries return one
+// datapoint per timestamp: of those appended for the same timestamp before a
+// commit only the first one, the others are stored but never returned. Which
+// one is returned for a timestamp appended again after its commit is not
+// defined. After SetRejectOutOfOrder such appends fail instead, unless they
+// repeat the value.
+func (fm *ftsdbMetric) Append(series map[string]string, timestamp int64, value float64) error {
+	return fm.append(fm.createSeries(LabelsFromMap(series), 0), timestamp, value)
+}
 
-	seriesItr := fm.createSeries(series)
+// Ref returns the ref of the series, creating it if it does not exist yet.
+// The ref can be passed to AppendRef for as long as the database is open.
+func (fm *ftsdbMetric) Ref(series map[string]string) SeriesRef {
+	return fm.createSeries(LabelsFromMap(series), 0).ref
+}
+
+// AppendRef appends to a series returned by Ref without looking up its
+// labels again.
+func (fm *ftsdbMetric) AppendRef(ref SeriesRef, timestamp int64, value float64) error {
+	var series *ftsdbSeries
+	if fm.inMemory != nil {
+		series = fm.inMemory.seriesByRef(ref)
+	}
 
-	(*seriesItr).dataPoints.Insert(newDataPoint(timestamp, value))
+	if series == nil || series.metric != fm {
+		return fmt.Errorf("%w: %d for metric %s", ErrUnknownSeriesRef, ref, fm.metric)
+	}
 
-	fm.size++
+	return fm.append(series, timestamp, value)
 }
 
-func (fm *ftsdbMetric) createSeries(series map[string]string) *ftsdbSeries {
-	seriesItr := &fm.series
+func (fm *ftsdbMetric) append(series *ftsdbSeries, timestamp int64, value float64) error {
+	series.mtx.Lock()
+	defer series.mtx.Unlock()
 
-	for *seriesItr != nil {
-		matched := true
-		totKeys := len((*seriesItr).series)
-		if totKeys != len(series) {
-			matched = false
-			seriesItr = &(*seriesItr).next
-			continue
+	if fm.inMemory != nil && fm.inMemory.rejectOutOfOrder.Load() && series.newest != nil {
+		newest := series.newest
+		switch {
+		case timestamp < newest.timestamp:
+			return fmt.Errorf("%w: %d is older than %d of %s %v", ErrOutOfOrderSample, timestamp, newest.timestamp, fm.metric, series.series)
+		case timestamp == newest.timestamp && value == newest.value:
+			return nil
+		case timestamp == newest.timestamp:
+			return fmt.Errorf("%w: %d of %s %v", ErrDuplicateSample, timestamp, fm.metric, series.series)
 		}
-		for k, v := range series {
-			vv, found := (*seriesItr).series[k]
+	}
 
-			if !found || vv != v {
-				matched = false
-				break
+	if fm.inMemory != nil && fm.inMemory.wal != nil {
+		if !series.logged {
+			if err := fm.inMemory.wal.logSeries(uint64(series.ref), fm.metric, series.series); err != nil {
+				return err
 			}
+			series.logged = true
 		}
 
-		if matched {
-			return (*seriesItr)
+		if err := fm.inMemory.wal.logSample(uint64(series.ref), timestamp, value); err != nil {
+			return err
 		}
+	}
+
+	dp := newDataPoint(timestamp, value)
+	series.insert(dp)
+	series.observe(dp)
+
+	fm.size.Add(1)
+
+	return nil
+}
+
+// createSeries returns the series with labels ls, creating it with the given
+// ref if it does not exist. A zero ref allocates the next free one.
+func (fm *ftsdbMetric) createSeries(ls Labels, ref SeriesRef) *ftsdbSeries {
+	hash := ls.Hash()
+	stripe := &fm.stripes[hash%seriesStripes]
+
+	stripe.mtx.RLock()
+	existing := stripe.find(hash, ls)
+	stripe.mtx.RUnlock()
+
+	if existing != nil {
+		return existing
+	}
+
+	stripe.mtx.Lock()
+	defer stripe.mtx.Unlock()
+
+	if existing := stripe.find(hash, ls); existing != nil {
+		return existing
+	}
+
+	series := newSeries(ls)
+	series.hash = hash
+	series.metric = fm
 
-		seriesItr = &(*seriesItr).next
+	if fm.inMemory != nil {
+		if ref == 0 {
+			ref = SeriesRef(fm.inMemory.lastSeriesRef.Add(1))
+		} else {
+			for last := fm.inMemory.lastSeriesRef.Load(); uint64(ref) > last; last = fm.inMemory.lastSeriesRef.Load() {
+				if fm.inMemory.lastSeriesRef.CompareAndSwap(last, uint64(ref)) {
+					break
+				}
+			}
+		}
+		series.ref = ref
+		fm.inMemory.registerRef(series)
+		fm.inMemory.indexSeries(series)
 	}
 
-	*seriesItr = newSeries(series)
+	stripe.series[hash] = append(stripe.series[hash], series)
+
+	return series
+}
 
-	return *seriesItr
+func (stripe *seriesStripe) find(hash uint64, ls Labels) *ftsdbSeries {
+	for _, series := range stripe.series[hash] {
+		if series.labels.Equal(ls) {
+			return series
+		}
+	}
+	return nil
 }
 
+// allSeries returns the series of the metric known at the time of the call,
+// in the order they were created.
+func (fm *ftsdbMetric) allSeries() []*ftsdbSeries {
+	all := []*ftsdbSeries{}
+
+	for idx := range fm.stripes {
+		stripe := &fm.stripes[idx]
+
+		stripe.mtx.RLock()
+		for _, collisions := range stripe.series {
+			all = append(all, collisions...)
+		}
+		stripe.mtx.RUnlock()
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].ref < all[j].ref
+	})
+
+	return all
+}
+
+// ftsdbSeries holds the datapoints of a series not yet written to a chunk.
+// mtx guards dataPoints, committing, logged and newest, the other fields
+// never change.
 type ftsdbSeries struct {
+	mtx        sync.RWMutex
 	series     map[string]string
+	labels     Labels
+	hash       uint64
 	dataPoints *FastArray
-	next       *ftsdbSeries
+	// committing holds the datapoints cut by a running commit, until its
+	// chunk is in place
+	committing []interface{}
+	metric     *ftsdbMetric
+	ref        SeriesRef
+	logged     bool
+	// newest is the datapoint with the highest timestamp appended since the
+	// database was opened, it outlives commits
+	newest *ftsdbDataPoint
 }
 
-func newSeries(series map[string]string) *ftsdbSeries {
+func newSeries(ls Labels) *ftsdbSeries {
 	return &ftsdbSeries{
-		series:     series,
+		series:     ls.Map(),
+		labels:     ls,
 		dataPoints: NewFastArray(),
-		next:       nil,
 	}
 }
 
+// insert adds dp in timestamp order. Datapoints arriving in order are
+// appended, older ones are inserted into a copy of the array, so snapshots
+// sharing the current one are left as they are.
+func (s *ftsdbSeries) insert(dp *ftsdbDataPoint) {
+	arr := s.dataPoints.arr[:s.dataPoints.size]
+
+	idx := sort.Search(len(arr), func(idx int) bool {
+		return arr[idx].(*ftsdbDataPoint).timestamp > dp.timestamp
+	})
+	if idx == len(arr) {
+		s.dataPoints.Insert(dp)
+		return
+	}
+
+	inserted := make([]interface{}, 0, len(arr)+1)
+	inserted = append(inserted, arr[:idx]...)
+	inserted = append(inserted, dp)
+	inserted = append(inserted, arr[idx:]...)
+
+	s.dataPoints.arr = inserted
+	s.dataPoints.size = len(inserted)
+}
+
+func (s *ftsdbSeries) observe(dp *ftsdbDataPoint) {
+	if s.newest == nil || dp.timestamp > s.newest.timestamp {
+		s.newest = dp
+	}
+}
+
+// snapshot returns the datapoints of the series from start on, or all of
+// them for a nil start, as sorted runs. The runs share memory with the series
+// but are safe to read without locks, appends never touch datapoints that
+// are already in place.
+func (s *ftsdbSeries) snapshot(start *int64) [][]interface{} {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	runs := make([][]interface{}, 0, 2)
+
+	for _, run := range [][]interface{}{s.committing, s.dataPoints.arr[:s.dataPoints.size]} {
+		if start != nil {
+			run = run[lowerBoundTimestamp(run, *start):]
+		}
+		if len(run) > 0 {
+			runs = append(runs, run)
+		}
+	}
+
+	return runs
+}
+
+// cut moves the datapoints of the series to committing and starts a new
+// array for appends. The series record is logged again, as the WAL segments
+// holding it are removed once the commit is done.
+func (s *ftsdbSeries) cut(wal *wal) ([]interface{}, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.logged && wal != nil {
+		if err := wal.logSeries(uint64(s.ref), s.metric.metric, s.series); err != nil {
+			return nil, err
+		}
+	}
+
+	s.committing = s.dataPoints.arr[:s.dataPoints.size]
+	s.dataPoints = NewFastArray()
+
+	return s.committing, nil
+}
+
 func seriesMatched(series1 map[string]string, series2 map[string]string) bool {
 	if len(series1) != len(series2) {
 		return false
@@ -556,26 +1346,15 @@ func seriesMatched(series1 map[string]string, series2 map[string]string) bool {
 }
 
 func (s *ftsdbSeries) LowerBoundTimestamp(timestamp int64) int {
-	var mid int
-
-	low := 0
-	high := s.dataPoints.size
-
-	for low < high {
-		mid = low + (high-low)/2
-
-		if timestamp <= s.dataPoints.At(mid).(*ftsdbDataPoint).timestamp {
-			high = mid
-		} else {
-			low = mid + 1
-		}
-	}
-
-	if low < s.dataPoints.size && s.dataPoints.At(low).(*ftsdbDataPoint).timestamp < timestamp {
-		low++
-	}
+	return lowerBoundTimestamp(s.dataPoints.arr[:s.dataPoints.size], timestamp)
+}
 
-	return low
+// lowerBoundTimestamp returns the index of the first datapoint at or after
+// timestamp in sorted dataPoints.
+func lowerBoundTimestamp(dataPoints []interface{}, timestamp int64) int {
+	return sort.Search(len(dataPoints), func(idx int) bool {
+		return dataPoints[idx].(*ftsdbDataPoint).timestamp >= timestamp
+	})
 }
 
 type ftsdbDataPoint struct {
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -25,7 +27,8 @@ func TestEnsureEverything(t *testing.T) {
 	}
 
 	dir, _ := os.Getwd()
-	tsdb := NewFTSDB(logger, filepath.Join(dir, "ingestion"))
+	tsdb, err := NewFTSDB(logger, filepath.Join(dir, "ingestion"))
+	require.NoError(t, err)
 	tsdb.SetFlushLimit(1)
 
 	metric := tsdb.CreateMetric("cpu")
@@ -42,7 +45,8 @@ func TestEnsureEverything(t *testing.T) {
 
 	query := Query{}
 
-	ss := tsdb.Find(query)
+	ss, err := tsdb.Find(query)
+	require.NoError(t, err)
 
 	tot := 0
 	for ss.Next() != nil {
@@ -63,7 +67,8 @@ func TestEnsureEverything(t *testing.T) {
 
 	query.RangeStart(int64(num / 2))
 
-	ss = tsdb.Find(query)
+	ss, err = tsdb.Find(query)
+	require.NoError(t, err)
 
 	tot = 0
 	for ss.Next() != nil {
@@ -82,7 +87,8 @@ func TestEnsureEverything(t *testing.T) {
 	query = *query.RangeStart(0)
 	query = *query.RangeEnd(int64((num / 2) + 1))
 
-	ss = tsdb.Find(query)
+	ss, err = tsdb.Find(query)
+	require.NoError(t, err)
 
 	tot = 0
 	tot = 0
@@ -101,7 +107,8 @@ func TestEnsureEverything(t *testing.T) {
 	query = Query{}
 	query.Series(seriesMac)
 
-	ss = tsdb.Find(query)
+	ss, err = tsdb.Find(query)
+	require.NoError(t, err)
 	tot = 0
 	tot = 0
 	for ss.Next() != nil {
@@ -121,3 +128,695 @@ func TestEnsureEverything(t *testing.T) {
 		t.Errorf("expected %d, got %d", exp, tot)
 	}
 }
+
+func TestCommitMultipleMetrics(t *testing.T) {
+	logger, _ := zap.NewProduction()
+
+	dir := t.TempDir()
+
+	tsdb, err := NewFTSDB(logger, dir)
+	require.NoError(t, err)
+	defer tsdb.Close()
+	tsdb.SetFlushLimit(1)
+
+	series := map[string]string{
+		"host": "macbook",
+	}
+
+	cpu := tsdb.CreateMetric("cpu")
+	ram := tsdb.CreateMetric("ram")
+
+	num := 10
+	for i := 1; i <= num; i++ {
+		require.NoError(t, cpu.Append(series, int64(i), float64(i)))
+		require.NoError(t, ram.Append(series, int64(i), float64(i*100)))
+	}
+
+	require.NoError(t, tsdb.Commit())
+
+	// metric handles stay valid after a commit
+	for i := num + 1; i <= num*2; i++ {
+		require.NoError(t, cpu.Append(series, int64(i), float64(i)))
+		require.NoError(t, ram.Append(series, int64(i), float64(i*100)))
+	}
+
+	require.NoError(t, tsdb.Commit())
+
+	for metric, multiplier := range map[string]float64{"cpu": 1, "ram": 100} {
+		query := Query{}
+		query.Metric(metric).Series(series)
+
+		ss, err := tsdb.Find(query)
+		require.NoError(t, err)
+
+		tot := 0
+		for ss.Next() != nil {
+			require.Equal(t, metric, ss.GetSeries().Metric)
+
+			it := ss.DatapointsIterator
+			for it.Next() != nil {
+				tot++
+				dp := it.GetDatapoint()
+				require.Equal(t, int64(tot), dp.Timestamp)
+				require.Equal(t, float64(tot)*multiplier, dp.Value)
+			}
+		}
+
+		require.Equal(t, num*2, tot)
+	}
+
+	ss, err := tsdb.Find(Query{})
+	require.NoError(t, err)
+	tot := 0
+	for ss.Next() != nil {
+		tot++
+	}
+	require.Equal(t, 2, tot)
+}
+
+func TestFloatValuesRoundTrip(t *testing.T) {
+	logger, _ := zap.NewProduction()
+
+	dir := t.TempDir()
+
+	tsdb, err := NewFTSDB(logger, dir)
+	require.NoError(t, err)
+	defer tsdb.Close()
+	tsdb.SetFlushLimit(1)
+
+	values := []float64{37.25, 37.25, 0.1, -12.5, 1e-9, 99.999}
+
+	metric := tsdb.CreateMetric("cpu")
+	for idx, value := range values {
+		require.NoError(t, metric.Append(map[string]string{"host": "macbook"}, int64(idx*50), value))
+	}
+
+	require.NoError(t, tsdb.Commit())
+
+	ss, err := tsdb.Find(Query{})
+	require.NoError(t, err)
+	require.NotNil(t, ss.Next())
+
+	got := []float64{}
+	for it := ss.DatapointsIterator; it.Next() != nil; {
+		got = append(got, it.GetDatapoint().Value)
+	}
+
+	require.Equal(t, values, got)
+}
+
+func TestFindCorruptChunks(t *testing.T) {
+	logger, _ := zap.NewProduction()
+
+	dir := t.TempDir()
+
+	tsdb, err := NewFTSDB(logger, dir)
+	require.NoError(t, err)
+	defer tsdb.Close()
+	tsdb.SetFlushLimit(1)
+
+	metric := tsdb.CreateMetric("cpu")
+	for chunk := 0; chunk < 3; chunk++ {
+		for i := 0; i < 10; i++ {
+			require.NoError(t, metric.Append(map[string]string{"host": "mac"}, int64(chunk*100+i), float64(i)))
+		}
+		require.NoError(t, tsdb.Commit())
+	}
+
+	count := func() (int, error) {
+		ss, err := tsdb.Find(Query{})
+		if err != nil {
+			return 0, err
+		}
+		tot := 0
+		for ss.Next() != nil {
+			for it := ss.DatapointsIterator; it.Next() != nil; {
+				tot++
+			}
+		}
+		return tot, ss.Err()
+	}
+
+	tot, err := count()
+	require.NoError(t, err)
+	require.Equal(t, 30, tot)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "100", "chunk"), []byte("garbage"), 0666))
+
+	_, err = count()
+	var corrupt *ErrCorruptChunk
+	require.ErrorAs(t, err, &corrupt)
+	require.Equal(t, filepath.Join(dir, "100"), corrupt.Dir)
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "200", "meta.json")))
+
+	_, err = count()
+	var notFound *ErrChunkNotFound
+	require.ErrorAs(t, err, &notFound)
+
+	tsdb.SetSkipCorruptChunks(true)
+
+	tot, err = count()
+	require.NoError(t, err)
+	require.Equal(t, 10, tot)
+}
+
+func TestIsolatedDirectories(t *testing.T) {
+	logger, _ := zap.NewProduction()
+
+	first, err := NewFTSDB(logger, t.TempDir())
+	require.NoError(t, err)
+	defer first.Close()
+	first.SetFlushLimit(1)
+
+	second, err := NewFTSDB(logger, t.TempDir())
+	require.NoError(t, err)
+	defer second.Close()
+	second.SetFlushLimit(1)
+
+	series := map[string]string{"host": "macbook"}
+
+	require.NoError(t, first.CreateMetric("cpu").Append(series, 1, 1))
+	require.NoError(t, first.Commit())
+
+	require.NoError(t, second.CreateMetric("cpu").Append(series, 1, 2))
+	require.NoError(t, second.CreateMetric("cpu").Append(series, 2, 3))
+	require.NoError(t, second.Commit())
+
+	for db, expected := range map[DBInterface][]float64{first: {1}, second: {2, 3}} {
+		ss, err := db.Find(Query{})
+		require.NoError(t, err)
+
+		values := []float64{}
+		for ss.Next() != nil {
+			for it := ss.DatapointsIterator; it.Next() != nil; {
+				values = append(values, it.GetDatapoint().Value)
+			}
+		}
+		require.NoError(t, ss.Err())
+		require.Equal(t, expected, values)
+	}
+}
+
+func collectFind(t *testing.T, db DBInterface, query Query) map[string][]Datapoint {
+	ss, err := db.Find(query)
+	require.NoError(t, err)
+
+	collected := map[string][]Datapoint{}
+	for ss.Next() != nil {
+		key := ss.GetSeries().Metric + "/" + ss.GetSeries().SeriesValue["host"]
+		for it := ss.DatapointsIterator; it.Next() != nil; {
+			collected[key] = append(collected[key], it.GetDatapoint())
+		}
+	}
+	require.NoError(t, ss.Err())
+
+	return collected
+}
+
+func TestFindIncludesHead(t *testing.T) {
+	logger, _ := zap.NewProduction()
+	dir := t.TempDir()
+
+	tsdb, err := NewFTSDB(logger, dir)
+	require.NoError(t, err)
+	tsdb.SetFlushLimit(10)
+
+	cpu := tsdb.CreateMetric("cpu")
+	for i := 1; i <= 10; i++ {
+		require.NoError(t, cpu.Append(map[string]string{"host": "mac"}, int64(i), float64(i)))
+	}
+	require.NoError(t, tsdb.Commit())
+
+	// below the flush limit, these stay in memory
+	for i := 11; i <= 15; i++ {
+		require.NoError(t, cpu.Append(map[string]string{"host": "mac"}, int64(i), float64(i)))
+	}
+	require.NoError(t, cpu.Append(map[string]string{"host": "win"}, 3, 3))
+	require.NoError(t, tsdb.Commit())
+
+	collected := collectFind(t, tsdb, Query{})
+	require.Len(t, collected["cpu/mac"], 15)
+	for idx, dp := range collected["cpu/mac"] {
+		require.Equal(t, int64(idx+1), dp.Timestamp)
+	}
+	require.Equal(t, []Datapoint{{Timestamp: 3, Value: 3}}, collected["cpu/win"])
+
+	query := Query{}
+	query.RangeStart(9).RangeEnd(12)
+	collected = collectFind(t, tsdb, query)
+	require.Equal(t, []Datapoint{{9, 9}, {10, 10}, {11, 11}, {12, 12}}, collected["cpu/mac"])
+	require.NotContains(t, collected, "cpu/win")
+
+	query = Query{}
+	query.RangeStart(13)
+	collected = collectFind(t, tsdb, query)
+	require.Equal(t, []Datapoint{{13, 13}, {14, 14}, {15, 15}}, collected["cpu/mac"])
+
+	query = Query{}
+	query.Metric("ram")
+	require.Empty(t, collectFind(t, tsdb, query))
+
+	tsdb.Close()
+
+	// the head comes back from the wal and is merged with the chunk again
+	reopened, err := NewFTSDB(logger, dir)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	collected = collectFind(t, reopened, Query{})
+	require.Len(t, collected["cpu/mac"], 15)
+}
+
+func TestFindOutOfOrderAppends(t *testing.T) {
+	dir := t.TempDir()
+
+	tsdb, err := NewFTSDB(zap.NewNop(), dir)
+	require.NoError(t, err)
+	defer tsdb.Close()
+	tsdb.SetFlushLimit(1)
+
+	cpu := tsdb.CreateMetric("cpu")
+	for _, ts := range []int64{10, 30, 20, 5, 40} {
+		require.NoError(t, cpu.Append(map[string]string{"host": "mac"}, ts, float64(ts)))
+	}
+
+	ss, err := tsdb.Find(Query{})
+	require.NoError(t, err)
+
+	// an older datapoint appended after Find is not part of its snapshot
+	require.NoError(t, cpu.Append(map[string]string{"host": "mac"}, 25, 25))
+
+	require.NotNil(t, ss.Next())
+	datapoints := []Datapoint{}
+	for it := ss.DatapointsIterator; it.Next() != nil; {
+		datapoints = append(datapoints, it.GetDatapoint())
+	}
+	require.Equal(t, []Datapoint{{5, 5}, {10, 10}, {20, 20}, {30, 30}, {40, 40}}, datapoints)
+
+	all := []Datapoint{{5, 5}, {10, 10}, {20, 20}, {25, 25}, {30, 30}, {40, 40}}
+	fromStart := []Datapoint{{20, 20}, {25, 25}, {30, 30}, {40, 40}}
+
+	require.Equal(t, all, collectFind(t, tsdb, Query{})["cpu/mac"])
+	require.Equal(t, fromStart, collectFind(t, tsdb, *(&Query{}).RangeStart(15))["cpu/mac"])
+
+	require.NoError(t, tsdb.Commit())
+
+	chunk, err := readChunk(dir, "5")
+	require.NoError(t, err)
+	timestamps := []int64{}
+	for _, data := range chunk.Data {
+		timestamps = append(timestamps, data.Datapoint.Timestamp)
+	}
+	require.Equal(t, []int64{5, 10, 20, 25, 30, 40}, timestamps)
+
+	require.Equal(t, all, collectFind(t, tsdb, Query{})["cpu/mac"])
+	require.Equal(t, fromStart, collectFind(t, tsdb, *(&Query{}).RangeStart(15))["cpu/mac"])
+}
+
+func TestFindDuplicateTimestamps(t *testing.T) {
+	tsdb, err := NewFTSDB(zap.NewNop(), t.TempDir())
+	require.NoError(t, err)
+	defer tsdb.Close()
+	tsdb.SetFlushLimit(1)
+
+	cpu := tsdb.CreateMetric("cpu")
+	for _, dp := range []Datapoint{{20, 1}, {10, 2}, {20, 3}, {10, 4}} {
+		require.NoError(t, cpu.Append(map[string]string{"host": "mac"}, dp.Timestamp, dp.Value))
+	}
+
+	// the first datapoint appended for a timestamp is the one returned
+	first := []Datapoint{{10, 2}, {20, 1}}
+	require.Equal(t, first, collectFind(t, tsdb, Query{})["cpu/mac"])
+
+	require.NoError(t, tsdb.Commit())
+	require.Equal(t, first, collectFind(t, tsdb, Query{})["cpu/mac"])
+}
+
+func TestFailedCommitKeepsHeadSorted(t *testing.T) {
+	dir := t.TempDir()
+
+	tsdb, err := NewFTSDB(zap.NewNop(), dir)
+	require.NoError(t, err)
+	defer tsdb.Close()
+	tsdb.SetFlushLimit(1)
+
+	cpu := tsdb.CreateMetric("cpu")
+	for _, ts := range []int64{10, 20} {
+		require.NoError(t, cpu.Append(map[string]string{"host": "mac"}, ts, float64(ts)))
+	}
+
+	// a commit cuts the head like Commit does, older datapoints are appended
+	// while it runs, and then it fails
+	db := tsdb.(*ftsdb)
+	committed := []*ftsdbSeries{}
+	for _, metric := range db.inMemory.metrics() {
+		for _, series := range metric.allSeries() {
+			dataPoints, err := series.cut(nil)
+			require.NoError(t, err)
+			metric.size.Add(-int64(len(dataPoints)))
+			committed = append(committed, series)
+		}
+	}
+
+	for _, ts := range []int64{5, 15, 30} {
+		require.NoError(t, cpu.Append(map[string]string{"host": "mac"}, ts, float64(ts)))
+	}
+
+	db.restore(committed)
+
+	all := []Datapoint{{5, 5}, {10, 10}, {15, 15}, {20, 20}, {30, 30}}
+	require.Equal(t, all, collectFind(t, tsdb, Query{})["cpu/mac"])
+
+	require.NoError(t, tsdb.Commit())
+
+	chunk, err := readChunk(dir, "5")
+	require.NoError(t, err)
+	timestamps := []int64{}
+	for _, data := range chunk.Data {
+		timestamps = append(timestamps, data.Datapoint.Timestamp)
+	}
+	require.Equal(t, []int64{5, 10, 15, 20, 30}, timestamps)
+	require.Equal(t, all, collectFind(t, tsdb, Query{})["cpu/mac"])
+}
+
+func TestFindDeduplicatesReplayedHead(t *testing.T) {
+	logger, _ := zap.NewProduction()
+	dir := t.TempDir()
+
+	tsdb, err := NewFTSDB(logger, dir)
+	require.NoError(t, err)
+	tsdb.SetFlushLimit(1)
+
+	cpu := tsdb.CreateMetric("cpu")
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, cpu.Append(map[string]string{"host": "mac"}, int64(i), float64(i)))
+	}
+
+	// write the chunk but keep the wal, as if the process died before truncating it
+	chunk := NewChunk()
+	seriesIdx := -1
+	for _, series := range cpu.allSeries() {
+		appendSeriesToChunk(chunk, cpu.metric, series.series, series.dataPoints.arr, &seriesIdx)
+	}
+	require.NoError(t, tsdb.(*ftsdb).writeChunk(chunk))
+	tsdb.Close()
+
+	reopened, err := NewFTSDB(logger, dir)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	collected := collectFind(t, reopened, Query{})
+	require.Equal(t, []Datapoint{{1, 1}, {2, 2}, {3, 3}, {4, 4}, {5, 5}}, collected["cpu/mac"])
+}
+
+func TestConcurrentAppendCommitFind(t *testing.T) {
+	logger := zap.NewNop()
+	dir := t.TempDir()
+
+	tsdb, err := NewFTSDB(logger, dir)
+	require.NoError(t, err)
+	tsdb.SetFlushLimit(100)
+
+	const writers = 8
+	const points = 500
+
+	var writersWg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		writersWg.Add(1)
+		go func(w int) {
+			defer writersWg.Done()
+
+			metric := tsdb.CreateMetric([]string{"cpu", "ram"}[w%2])
+			series := map[string]string{"host": fmt.Sprintf("host-%d", w)}
+
+			for i := 1; i <= points; i++ {
+				require.NoError(t, metric.Append(series, int64(i), float64(i)))
+			}
+		}(w)
+	}
+
+	done := make(chan struct{})
+	var readersWg sync.WaitGroup
+
+	readersWg.Add(1)
+	go func() {
+		defer readersWg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				require.NoError(t, tsdb.Commit())
+			}
+		}
+	}()
+
+	for r := 0; r < 2; r++ {
+		readersWg.Add(1)
+		go func() {
+			defer readersWg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				// appends of a series are in order, so whatever a query sees
+				// of it has to be a gapless prefix, wherever the commits are
+				for key, datapoints := range collectFind(t, tsdb, Query{}) {
+					for idx, dp := range datapoints {
+						require.Equal(t, Datapoint{int64(idx + 1), float64(idx + 1)}, dp, key)
+					}
+				}
+			}
+		}()
+	}
+
+	writersWg.Wait()
+	close(done)
+	readersWg.Wait()
+
+	tsdb.SetFlushLimit(1)
+	require.NoError(t, tsdb.Commit())
+
+	collected := collectFind(t, tsdb, Query{})
+	require.Len(t, collected, writers)
+	for key, datapoints := range collected {
+		require.Len(t, datapoints, points, key)
+	}
+
+	tsdb.Close()
+
+	reopened, err := NewFTSDB(logger, dir)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	require.Equal(t, collected, collectFind(t, reopened, Query{}))
+}
+
+func TestConcurrentAppendSameSeries(t *testing.T) {
+	logger := zap.NewNop()
+
+	tsdb, err := NewFTSDB(logger, t.TempDir())
+	require.NoError(t, err)
+	defer tsdb.Close()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			metric := tsdb.CreateMetric("cpu")
+			for i := 0; i < 100; i++ {
+				require.NoError(t, metric.Append(map[string]string{"host": "mac"}, int64(w*100+i), 1))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	metrics := tsdb.(*ftsdb).inMemory.metrics()
+	require.Len(t, metrics, 1)
+	require.Len(t, metrics[0].allSeries(), 1)
+	require.Equal(t, int64(400), metrics[0].size.Load())
+}
+
+func TestCommitMergesExistingChunk(t *testing.T) {
+	logger := zap.NewNop()
+
+	tsdb, err := NewFTSDB(logger, t.TempDir())
+	require.NoError(t, err)
+	defer tsdb.Close()
+	tsdb.SetFlushLimit(1)
+
+	cpu := tsdb.CreateMetric("cpu")
+	for i := 5; i <= 7; i++ {
+		require.NoError(t, cpu.Append(map[string]string{"host": "mac"}, int64(i), float64(i)))
+	}
+	require.NoError(t, tsdb.Commit())
+
+	// starts at the same timestamp, so it lands in a directory next to the
+	// first one and Find merges both
+	require.NoError(t, cpu.Append(map[string]string{"host": "win"}, 5, 50))
+	require.NoError(t, cpu.Append(map[string]string{"host": "mac"}, 8, 8))
+	require.NoError(t, tsdb.Commit())
+
+	collected := collectFind(t, tsdb, Query{})
+	require.Equal(t, []Datapoint{{5, 5}, {6, 6}, {7, 7}}, collected["cpu/mac"][:3])
+	require.Len(t, collected["cpu/mac"], 4)
+	require.Equal(t, []Datapoint{{5, 50}}, collected["cpu/win"])
+}
+
+func TestAppendRef(t *testing.T) {
+	logger := zap.NewNop()
+	dir := t.TempDir()
+
+	tsdb, err := NewFTSDB(logger, dir)
+	require.NoError(t, err)
+
+	cpu := tsdb.CreateMetric("cpu")
+	ram := tsdb.CreateMetric("ram")
+
+	ref := cpu.Ref(map[string]string{"host": "mac"})
+	require.Equal(t, ref, cpu.Ref(map[string]string{"host": "mac"}))
+	require.NotEqual(t, ref, cpu.Ref(map[string]string{"host": "win"}))
+
+	require.NoError(t, cpu.AppendRef(ref, 1, 1))
+	require.NoError(t, cpu.Append(map[string]string{"host": "mac"}, 2, 2))
+
+	require.ErrorIs(t, cpu.AppendRef(12345, 3, 3), ErrUnknownSeriesRef)
+	require.ErrorIs(t, ram.AppendRef(ref, 3, 3), ErrUnknownSeriesRef)
+
+	tsdb.Close()
+
+	// refs come back with the series from the wal
+	reopened, err := NewFTSDB(logger, dir)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	require.NoError(t, reopened.CreateMetric("cpu").AppendRef(ref, 3, 3))
+	require.Equal(t, []Datapoint{{1, 1}, {2, 2}, {3, 3}}, collectFind(t, reopened, Query{})["cpu/mac"])
+}
+
+func TestRejectOutOfOrder(t *testing.T) {
+	logger := zap.NewNop()
+	dir := t.TempDir()
+
+	tsdb, err := NewFTSDB(logger, dir)
+	require.NoError(t, err)
+	tsdb.SetRejectOutOfOrder(true)
+	tsdb.SetFlushLimit(1)
+
+	cpu := tsdb.CreateMetric("cpu")
+	mac := map[string]string{"host": "mac"}
+
+	require.NoError(t, cpu.Append(mac, 10, 1))
+	require.NoError(t, cpu.Append(mac, 20, 2))
+
+	require.ErrorIs(t, cpu.Append(mac, 15, 3), ErrOutOfOrderSample)
+	require.ErrorIs(t, cpu.Append(mac, 20, 3), ErrDuplicateSample)
+	require.NoError(t, cpu.Append(mac, 20, 2))
+
+	// other series are not affected
+	require.NoError(t, cpu.Append(map[string]string{"host": "win"}, 5, 5))
+
+	// the newest datapoint outlives the commit of the head
+	require.NoError(t, tsdb.Commit())
+	require.ErrorIs(t, cpu.Append(mac, 15, 3), ErrOutOfOrderSample)
+	require.NoError(t, cpu.Append(mac, 30, 3))
+
+	tsdb.Close()
+
+	// and replaying the wal
+	reopened, err := NewFTSDB(logger, dir)
+	require.NoError(t, err)
+	defer reopened.Close()
+	reopened.SetRejectOutOfOrder(true)
+
+	cpu = reopened.CreateMetric("cpu")
+	require.ErrorIs(t, cpu.Append(mac, 25, 3), ErrOutOfOrderSample)
+
+	require.Equal(t, []Datapoint{{10, 1}, {20, 2}, {30, 3}}, collectFind(t, reopened, Query{})["cpu/mac"])
+
+	// appends in any order are accepted by default
+	reopened.SetRejectOutOfOrder(false)
+	require.NoError(t, cpu.Append(mac, 25, 3))
+}
+
+func TestCreateSeriesHashCollision(t *testing.T) {
+	tsdb, err := NewFTSDB(zap.NewNop(), t.TempDir())
+	require.NoError(t, err)
+	defer tsdb.Close()
+
+	cpu := tsdb.CreateMetric("cpu")
+
+	mac := LabelsFromMap(map[string]string{"host": "mac"})
+	win := LabelsFromMap(map[string]string{"host": "win"})
+
+	// file mac under the hash of win, as if the two collided
+	macSeries := cpu.createSeries(mac, 0)
+	stripe := &cpu.stripes[macSeries.hash%seriesStripes]
+	delete(stripe.series, macSeries.hash)
+	winStripe := &cpu.stripes[win.Hash()%seriesStripes]
+	winStripe.series[win.Hash()] = append(winStripe.series[win.Hash()], macSeries)
+	macSeries.hash = win.Hash()
+
+	winSeries := cpu.createSeries(win, 0)
+	require.NotSame(t, macSeries, winSeries)
+	require.Equal(t, win, winSeries.labels)
+	require.Len(t, winStripe.series[win.Hash()], 2)
+	require.Same(t, winSeries, cpu.createSeries(win, 0))
+}
+
+func TestFindByLabel(t *testing.T) {
+	logger := zap.NewNop()
+	dir := t.TempDir()
+
+	tsdb, err := NewFTSDB(logger, dir)
+	require.NoError(t, err)
+	defer tsdb.Close()
+	tsdb.SetFlushLimit(1)
+
+	cpu := tsdb.CreateMetric("cpu")
+	require.NoError(t, cpu.Append(map[string]string{"host": "web-1", "region": "eu"}, 1, 1))
+	require.NoError(t, cpu.Append(map[string]string{"host": "web-2", "region": "eu"}, 1, 2))
+	require.NoError(t, cpu.Append(map[string]string{"host": "web-1", "region": "us"}, 1, 3))
+	require.NoError(t, tsdb.CreateMetric("ram").Append(map[string]string{"host": "web-1"}, 1, 4))
+	require.NoError(t, tsdb.Commit())
+
+	// the same labels again, now only in the head
+	require.NoError(t, cpu.Append(map[string]string{"host": "web-1", "region": "eu"}, 2, 5))
+	require.NoError(t, cpu.Append(map[string]string{"host": "web-3", "region": "us"}, 2, 6))
+
+	_, err = os.Stat(filepath.Join(dir, "1", "index"))
+	require.NoError(t, err)
+
+	values := func(query *Query) []float64 {
+		found := []float64{}
+		ss, err := tsdb.Find(*query)
+		require.NoError(t, err)
+		for s := ss.Next(); s != nil; s = s.Next() {
+			for dd := s.DatapointsIterator.Next(); dd != nil; dd = dd.Next() {
+				found = append(found, dd.GetDatapoint().Value)
+			}
+		}
+		require.NoError(t, ss.Err())
+		sort.Float64s(found)
+		return found
+	}
+
+	require.Equal(t, []float64{1, 3, 4, 5}, values((&Query{}).Label("host", "web-1")))
+	require.Equal(t, []float64{1, 3, 5}, values((&Query{}).Metric("cpu").Label("host", "web-1")))
+	require.Equal(t, []float64{1, 5}, values((&Query{}).Label("host", "web-1").Label("region", "eu")))
+	require.Equal(t, []float64{1, 2, 3, 4, 5, 6}, values((&Query{}).Label("host", "web-1", "web-2", "web-3")))
+	require.Equal(t, []float64{2, 6}, values((&Query{}).Label("host", "web-2", "web-3")))
+	require.Empty(t, values((&Query{}).Label("host", "web-4")))
+
+	// Series still asks for the exact label set
+	require.Equal(t, []float64{4}, values((&Query{}).Series(map[string]string{"host": "web-1"})))
+
+	// chunks written before index files existed are indexed from their meta
+	require.NoError(t, os.Remove(filepath.Join(dir, "1", "index")))
+	require.Equal(t, []float64{1, 3, 4, 5}, values((&Query{}).Label("host", "web-1")))
+}
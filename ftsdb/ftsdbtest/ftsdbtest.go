@@ -0,0 +1,45 @@
+// Package ftsdbtest holds helpers for the tests of packages storing into
+// ftsdb.
+package ftsdbtest
+
+import (
+	"testing"
+
+	"github.com/Marvin9/ftsdb/ftsdb"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// NewDB opens a database in a temporary directory, closed when the test is
+// done.
+func NewDB(t testing.TB) ftsdb.DBInterface {
+	t.Helper()
+
+	db, err := ftsdb.NewFTSDB(zap.NewNop(), t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(db.Close)
+
+	return db
+}
+
+// Collect returns the datapoints of metric by the labels of their series,
+// formatted like {host="web-1"}.
+func Collect(t testing.TB, db ftsdb.DBInterface, metric string) map[string][]ftsdb.Datapoint {
+	t.Helper()
+
+	ss, err := db.Find(*(&ftsdb.Query{}).Metric(metric))
+	require.NoError(t, err)
+	defer ss.Close()
+
+	collected := map[string][]ftsdb.Datapoint{}
+	for ss.Next() != nil {
+		key := labels.FromMap(ss.GetSeries().SeriesValue).String()
+		for it := ss.DatapointsIterator; it.Next() != nil; {
+			collected[key] = append(collected[key], it.GetDatapoint())
+		}
+	}
+	require.NoError(t, ss.Err())
+
+	return collected
+}
@@ -0,0 +1,81 @@
+package ftsdb
+
+import (
+	"sort"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Labels is the canonical form of a series, its labels sorted by name. Two
+// series maps with the same content always give equal Labels and the same
+// Hash.
+type Labels []Label
+
+func LabelsFromMap(series map[string]string) Labels {
+	ls := make(Labels, 0, len(series))
+	for name, value := range series {
+		ls = append(ls, Label{Name: name, Value: value})
+	}
+	sort.Slice(ls, func(i, j int) bool {
+		return ls[i].Name < ls[j].Name
+	})
+	return ls
+}
+
+// labelSep can not appear in valid UTF-8, so name and value boundaries can
+// not be shifted to produce the same bytes from different labels.
+const labelSep = '\xff'
+
+// Hash returns a hash of the labels that is stable across processes and
+// platforms. Different labels can still collide, equality has to be checked
+// with Equal.
+func (ls Labels) Hash() uint64 {
+	return xxhash.Sum64(ls.appendBytes(make([]byte, 0, 256)))
+}
+
+func (ls Labels) appendBytes(b []byte) []byte {
+	for _, l := range ls {
+		b = append(b, l.Name...)
+		b = append(b, labelSep)
+		b = append(b, l.Value...)
+		b = append(b, labelSep)
+	}
+	return b
+}
+
+func (ls Labels) Equal(other Labels) bool {
+	if len(ls) != len(other) {
+		return false
+	}
+	for idx := range ls {
+		if ls[idx] != other[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+func (ls Labels) Map() map[string]string {
+	m := make(map[string]string, len(ls))
+	for _, l := range ls {
+		m[l.Name] = l.Value
+	}
+	return m
+}
+
+// seriesKey identifies a series of metric, for use as a map key.
+func seriesKey(metric string, ls Labels) string {
+	b := make([]byte, 0, 256)
+	b = append(b, metric...)
+	b = append(b, labelSep)
+	return string(ls.appendBytes(b))
+}
+
+// SeriesRef identifies a series of the head for as long as the database is
+// open. Appending through a ref skips hashing and looking up the labels.
+type SeriesRef uint64
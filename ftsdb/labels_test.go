@@ -0,0 +1,31 @@
+package ftsdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabelsFromMap(t *testing.T) {
+	ls := LabelsFromMap(map[string]string{"region": "eu", "host": "mac", "az": "1"})
+
+	require.Equal(t, Labels{{"az", "1"}, {"host", "mac"}, {"region", "eu"}}, ls)
+	require.Equal(t, map[string]string{"region": "eu", "host": "mac", "az": "1"}, ls.Map())
+}
+
+func TestLabelsHash(t *testing.T) {
+	a := LabelsFromMap(map[string]string{"host": "mac", "region": "eu"})
+	b := LabelsFromMap(map[string]string{"region": "eu", "host": "mac"})
+
+	require.True(t, a.Equal(b))
+	require.Equal(t, a.Hash(), b.Hash())
+
+	// pinned, the hash has to stay the same across processes
+	require.Equal(t, uint64(0xc4f058038e2a4fa5), a.Hash())
+
+	// moving characters between name and value must change the hash
+	c := Labels{{"ab", "c"}}
+	d := Labels{{"a", "bc"}}
+	require.False(t, c.Equal(d))
+	require.NotEqual(t, c.Hash(), d.Hash())
+}
@@ -0,0 +1,60 @@
+package ftsdb
+
+import (
+	"sync"
+	"time"
+)
+
+// loop runs a function in the background every interval, like compaction
+// and retention.
+type loop struct {
+	mtx   sync.Mutex
+	stopc chan struct{}
+	donec chan struct{}
+}
+
+// start stops a running loop and starts running fn every interval, unless
+// interval is zero.
+func (l *loop) start(interval time.Duration, fn func()) {
+	l.stop()
+
+	if interval <= 0 {
+		return
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.stopc = make(chan struct{})
+	l.donec = make(chan struct{})
+
+	go func(stopc, donec chan struct{}) {
+		defer close(donec)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stopc:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	}(l.stopc, l.donec)
+}
+
+func (l *loop) stop() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.stopc == nil {
+		return
+	}
+
+	close(l.stopc)
+	<-l.donec
+	l.stopc = nil
+	l.donec = nil
+}
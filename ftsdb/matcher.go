@@ -0,0 +1,115 @@
+package ftsdb
+
+import (
+	"fmt"
+	"regexp"
+)
+
+type MatchType int
+
+const (
+	MatchEqual MatchType = iota
+	MatchNotEqual
+	MatchRegexp
+	MatchNotRegexp
+)
+
+func (m MatchType) String() string {
+	switch m {
+	case MatchEqual:
+		return "="
+	case MatchNotEqual:
+		return "!="
+	case MatchRegexp:
+		return "=~"
+	case MatchNotRegexp:
+		return "!~"
+	}
+	return fmt.Sprintf("MatchType(%d)", int(m))
+}
+
+// Matcher selects series by the value of one label, with the semantics of
+// Prometheus label matchers: a series without the label is treated as having
+// it set to the empty string, and regular expressions have to match the whole
+// value.
+type Matcher struct {
+	Type  MatchType
+	Name  string
+	Value string
+
+	re *regexp.Regexp
+}
+
+func NewMatcher(t MatchType, name, value string) (*Matcher, error) {
+	m := &Matcher{
+		Type:  t,
+		Name:  name,
+		Value: value,
+	}
+
+	switch t {
+	case MatchEqual, MatchNotEqual:
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, err
+		}
+		m.re = re
+	default:
+		return nil, fmt.Errorf("unknown match type %d", int(t))
+	}
+
+	return m, nil
+}
+
+// MustNewMatcher is like NewMatcher but panics on an invalid matcher.
+func MustNewMatcher(t MatchType, name, value string) *Matcher {
+	m, err := NewMatcher(t, name, value)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func (m *Matcher) Matches(value string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(value)
+	case MatchNotRegexp:
+		return !m.re.MatchString(value)
+	}
+	return false
+}
+
+func (m *Matcher) String() string {
+	return fmt.Sprintf("%s%s%q", m.Name, m.Type, m.Value)
+}
+
+// postingsForMatcher returns the IDs of the series in index the matcher
+// selects.
+func postingsForMatcher(index *postingsIndex, m *Matcher) []uint64 {
+	if m.Type == MatchEqual && m.Value != "" {
+		return index.get(m.Name, m.Value)
+	}
+
+	// A matcher that accepts the empty value also selects every series
+	// without the label, so it is the complement of the values it rejects.
+	matchesEmpty := m.Matches("")
+
+	lists := [][]uint64{}
+	for _, value := range index.values(m.Name) {
+		if m.Matches(value) != matchesEmpty {
+			lists = append(lists, index.get(m.Name, value))
+		}
+	}
+
+	if matchesEmpty {
+		return subtractPostings(index.all(), unionPostings(lists...))
+	}
+
+	return unionPostings(lists...)
+}
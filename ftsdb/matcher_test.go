@@ -0,0 +1,61 @@
+package ftsdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatcherMatches(t *testing.T) {
+	for _, tc := range []struct {
+		matcher *Matcher
+		value   string
+		matches bool
+	}{
+		{MustNewMatcher(MatchEqual, "host", "web-1"), "web-1", true},
+		{MustNewMatcher(MatchEqual, "host", "web-1"), "web-10", false},
+		{MustNewMatcher(MatchNotEqual, "host", "web-1"), "web-2", true},
+		{MustNewMatcher(MatchNotEqual, "host", "web-1"), "", true},
+		{MustNewMatcher(MatchRegexp, "host", "web-.*"), "web-1", true},
+		// regular expressions are anchored at both ends
+		{MustNewMatcher(MatchRegexp, "host", "web"), "web-1", false},
+		{MustNewMatcher(MatchRegexp, "host", "eb-1"), "web-1", false},
+		{MustNewMatcher(MatchRegexp, "host", "web-1|db-1"), "db-1", true},
+		{MustNewMatcher(MatchRegexp, "host", ".*"), "", true},
+		{MustNewMatcher(MatchNotRegexp, "host", "web-.*"), "db-1", true},
+		{MustNewMatcher(MatchNotRegexp, "host", "web-.*"), "web-canary", false},
+	} {
+		require.Equal(t, tc.matches, tc.matcher.Matches(tc.value), "%s on %q", tc.matcher, tc.value)
+	}
+
+	_, err := NewMatcher(MatchRegexp, "host", "web-(")
+	require.Error(t, err)
+
+	require.Equal(t, `host!~"web-.*"`, MustNewMatcher(MatchNotRegexp, "host", "web-.*").String())
+}
+
+func TestPostingsForMatcher(t *testing.T) {
+	p := newPostingsIndex()
+	p.add(0, "cpu", LabelsFromMap(map[string]string{"host": "web-1"}))
+	p.add(1, "cpu", LabelsFromMap(map[string]string{"host": "web-2"}))
+	p.add(2, "cpu", LabelsFromMap(map[string]string{"host": "web-canary"}))
+	p.add(3, "cpu", LabelsFromMap(map[string]string{"host": "db-1"}))
+	p.add(4, "cpu", LabelsFromMap(map[string]string{"region": "eu"}))
+
+	for _, tc := range []struct {
+		matcher  *Matcher
+		expected []uint64
+	}{
+		{MustNewMatcher(MatchEqual, "host", "web-1"), []uint64{0}},
+		{MustNewMatcher(MatchEqual, "host", ""), []uint64{4}},
+		{MustNewMatcher(MatchNotEqual, "host", "web-1"), []uint64{1, 2, 3, 4}},
+		{MustNewMatcher(MatchNotEqual, "host", ""), []uint64{0, 1, 2, 3}},
+		{MustNewMatcher(MatchRegexp, "host", "web-.*"), []uint64{0, 1, 2}},
+		{MustNewMatcher(MatchRegexp, "host", "web-.*|"), []uint64{0, 1, 2, 4}},
+		{MustNewMatcher(MatchNotRegexp, "host", "web-.*"), []uint64{3, 4}},
+		{MustNewMatcher(MatchRegexp, "missing", ".*"), []uint64{0, 1, 2, 3, 4}},
+		{MustNewMatcher(MatchRegexp, "missing", ".+"), []uint64{}},
+	} {
+		require.Equal(t, tc.expected, postingsForMatcher(p, tc.matcher), tc.matcher.String())
+	}
+}
@@ -0,0 +1,81 @@
+package ftsdb
+
+// datapointSource is one sorted run of datapoints of a series, either a chunk
+// on disk or the in-memory head. Loading is deferred until the merge reaches
+// minTimestamp, so chunks that a query never gets to are never read.
+type datapointSource struct {
+	minTimestamp int64
+	load         func() ([]Datapoint, error)
+}
+
+// mergedDatapoints merges sources, sorted by minTimestamp, into a single
+// stream ordered by timestamp. When several sources hold the same timestamp,
+// as happens when the head is replayed from a WAL whose chunk was already
+// written, only the first one is kept.
+type mergedDatapoints struct {
+	sources    []datapointSource
+	nextSource int
+	runs       [][]Datapoint
+	current    Datapoint
+	started    bool
+	err        error
+}
+
+func newMergedDatapoints(sources []datapointSource) *mergedDatapoints {
+	return &mergedDatapoints{
+		sources: sources,
+	}
+}
+
+func (m *mergedDatapoints) Next() bool {
+	if m.err != nil {
+		return false
+	}
+
+	for {
+		best := -1
+		for idx, run := range m.runs {
+			if len(run) > 0 && (best == -1 || run[0].Timestamp < m.runs[best][0].Timestamp) {
+				best = idx
+			}
+		}
+
+		if m.nextSource < len(m.sources) && (best == -1 || m.sources[m.nextSource].minTimestamp <= m.runs[best][0].Timestamp) {
+			run, err := m.sources[m.nextSource].load()
+			if err != nil {
+				m.err = err
+				return false
+			}
+			m.nextSource++
+
+			if len(run) > 0 {
+				m.runs = append(m.runs, run)
+			}
+			continue
+		}
+
+		if best == -1 {
+			return false
+		}
+
+		dp := m.runs[best][0]
+		m.runs[best] = m.runs[best][1:]
+
+		if m.started && dp.Timestamp == m.current.Timestamp {
+			continue
+		}
+
+		m.current = dp
+		m.started = true
+
+		return true
+	}
+}
+
+func (m *mergedDatapoints) At() Datapoint {
+	return m.current
+}
+
+func (m *mergedDatapoints) Err() error {
+	return m.err
+}
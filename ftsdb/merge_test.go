@@ -0,0 +1,61 @@
+package ftsdb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func staticSource(timestamps ...int64) datapointSource {
+	datapoints := make([]Datapoint, len(timestamps))
+	for idx, ts := range timestamps {
+		datapoints[idx] = Datapoint{Timestamp: ts, Value: float64(ts)}
+	}
+
+	return datapointSource{
+		minTimestamp: timestamps[0],
+		load: func() ([]Datapoint, error) {
+			return datapoints, nil
+		},
+	}
+}
+
+func TestMergedDatapoints(t *testing.T) {
+	merged := newMergedDatapoints([]datapointSource{
+		staticSource(1, 4, 7),
+		staticSource(2, 4, 5),
+		staticSource(10, 11),
+		staticSource(10, 12),
+	})
+
+	timestamps := []int64{}
+	for merged.Next() {
+		timestamps = append(timestamps, merged.At().Timestamp)
+	}
+
+	require.NoError(t, merged.Err())
+	require.Equal(t, []int64{1, 2, 4, 5, 7, 10, 11, 12}, timestamps)
+}
+
+func TestMergedDatapointsLazyLoad(t *testing.T) {
+	loadErr := errors.New("broken chunk")
+
+	merged := newMergedDatapoints([]datapointSource{
+		staticSource(1, 2),
+		{
+			minTimestamp: 3,
+			load: func() ([]Datapoint, error) {
+				return nil, loadErr
+			},
+		},
+	})
+
+	require.True(t, merged.Next())
+	require.True(t, merged.Next())
+	require.Equal(t, int64(2), merged.At().Timestamp)
+
+	// the broken source is only touched once the merge reaches it
+	require.False(t, merged.Next())
+	require.ErrorIs(t, merged.Err(), loadErr)
+}
@@ -0,0 +1,268 @@
+package ftsdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"sort"
+)
+
+// MetricLabel is the label name the metric of a series is indexed under.
+const MetricLabel = "__name__"
+
+// allPostingsKey is the name and value of the postings list holding every
+// series of an index.
+const allPostingsKey = ""
+
+// postingsIndex maps label name and value to the sorted IDs of the series
+// carrying that label. In a chunk the IDs are positions in ChunkMeta.Series,
+// in the head they are series refs.
+type postingsIndex struct {
+	postings map[string]map[string][]uint64
+}
+
+func newPostingsIndex() *postingsIndex {
+	return &postingsIndex{
+		postings: map[string]map[string][]uint64{},
+	}
+}
+
+func (p *postingsIndex) add(id uint64, metric string, ls Labels) {
+	p.addPosting(allPostingsKey, allPostingsKey, id)
+	p.addPosting(MetricLabel, metric, id)
+	for _, l := range ls {
+		p.addPosting(l.Name, l.Value, id)
+	}
+}
+
+func (p *postingsIndex) addPosting(name, value string, id uint64) {
+	values, found := p.postings[name]
+	if !found {
+		values = map[string][]uint64{}
+		p.postings[name] = values
+	}
+
+	list := values[value]
+
+	// IDs mostly come in increasing order, so this rarely has to shift
+	idx := len(list)
+	for idx > 0 && list[idx-1] > id {
+		idx--
+	}
+	if idx > 0 && list[idx-1] == id {
+		return
+	}
+
+	list = append(list, 0)
+	copy(list[idx+1:], list[idx:])
+	list[idx] = id
+
+	values[value] = list
+}
+
+// get returns the postings list of a label. The list must not be modified.
+func (p *postingsIndex) get(name, value string) []uint64 {
+	return p.postings[name][value]
+}
+
+func (p *postingsIndex) all() []uint64 {
+	return p.get(allPostingsKey, allPostingsKey)
+}
+
+// values returns the sorted values of label name.
+func (p *postingsIndex) values(name string) []string {
+	values := make([]string, 0, len(p.postings[name]))
+	for value := range p.postings[name] {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values
+}
+
+func chunkPostingsIndex(meta ChunkMeta) *postingsIndex {
+	p := newPostingsIndex()
+	for idx, series := range meta.Series {
+		p.add(uint64(idx), meta.MetricAt(idx), LabelsFromMap(series))
+	}
+	return p
+}
+
+// intersectPostings returns the IDs present in every list.
+func intersectPostings(lists ...[]uint64) []uint64 {
+	if len(lists) == 0 {
+		return nil
+	}
+
+	result := lists[0]
+	for _, list := range lists[1:] {
+		merged := make([]uint64, 0, min(len(result), len(list)))
+
+		i, j := 0, 0
+		for i < len(result) && j < len(list) {
+			switch {
+			case result[i] < list[j]:
+				i++
+			case result[i] > list[j]:
+				j++
+			default:
+				merged = append(merged, result[i])
+				i++
+				j++
+			}
+		}
+
+		result = merged
+	}
+
+	return result
+}
+
+// unionPostings returns the IDs present in any of the lists.
+func unionPostings(lists ...[]uint64) []uint64 {
+	result := []uint64{}
+
+	for _, list := range lists {
+		merged := make([]uint64, 0, len(result)+len(list))
+
+		i, j := 0, 0
+		for i < len(result) || j < len(list) {
+			switch {
+			case j == len(list) || (i < len(result) && result[i] < list[j]):
+				merged = append(merged, result[i])
+				i++
+			case i == len(result) || list[j] < result[i]:
+				merged = append(merged, list[j])
+				j++
+			default:
+				merged = append(merged, result[i])
+				i++
+				j++
+			}
+		}
+
+		result = merged
+	}
+
+	return result
+}
+
+// subtractPostings returns the IDs of list that are not in remove.
+func subtractPostings(list, remove []uint64) []uint64 {
+	result := make([]uint64, 0, len(list))
+
+	j := 0
+	for _, id := range list {
+		for j < len(remove) && remove[j] < id {
+			j++
+		}
+		if j < len(remove) && remove[j] == id {
+			continue
+		}
+		result = append(result, id)
+	}
+
+	return result
+}
+
+// Index files of a chunk are laid out as
+//
+//	magic (4 bytes) | version (1 byte) | names | crc32 of everything before (4 bytes)
+//
+// names: uvarint count, then for each name in sorted order
+//
+//	uvarint length | name | uvarint values | values
+//
+// values: for each value in sorted order
+//
+//	uvarint length | value | uvarint IDs | IDs as uvarint deltas
+const (
+	indexMagic   uint32 = 0x46545349 // "FTSI"
+	indexVersion byte   = 1
+)
+
+func encodePostingsIndex(p *postingsIndex) []byte {
+	b := make([]byte, 5, 1024)
+	binary.BigEndian.PutUint32(b, indexMagic)
+	b[4] = indexVersion
+
+	names := make([]string, 0, len(p.postings))
+	for name := range p.postings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	b = binary.AppendUvarint(b, uint64(len(names)))
+	for _, name := range names {
+		b = appendWALString(b, name)
+
+		values := p.values(name)
+		b = binary.AppendUvarint(b, uint64(len(values)))
+		for _, value := range values {
+			b = appendWALString(b, value)
+
+			list := p.postings[name][value]
+			b = binary.AppendUvarint(b, uint64(len(list)))
+
+			prev := uint64(0)
+			for _, id := range list {
+				b = binary.AppendUvarint(b, id-prev)
+				prev = id
+			}
+		}
+	}
+
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+}
+
+var errInvalidIndex = errors.New("invalid index file")
+
+func decodePostingsIndex(b []byte) (*postingsIndex, error) {
+	if len(b) < 9 || binary.BigEndian.Uint32(b) != indexMagic {
+		return nil, errInvalidIndex
+	}
+	if b[4] != indexVersion {
+		return nil, errors.New("unsupported index version")
+	}
+
+	body := b[:len(b)-4]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(b[len(b)-4:]) {
+		return nil, errors.New("index checksum mismatch")
+	}
+
+	// strings and varints are encoded the same way as in WAL records
+	d := walDecoder{b: body[5:]}
+	p := newPostingsIndex()
+
+	names := d.uvarint()
+	for n := uint64(0); n < names && d.err == nil; n++ {
+		name := d.string()
+		values := map[string][]uint64{}
+
+		count := d.uvarint()
+		for v := uint64(0); v < count && d.err == nil; v++ {
+			value := d.string()
+
+			ids := d.uvarint()
+			if ids > uint64(len(d.b)) {
+				return nil, errInvalidIndex
+			}
+
+			list := make([]uint64, ids)
+			prev := uint64(0)
+			for idx := range list {
+				prev += d.uvarint()
+				list[idx] = prev
+			}
+
+			values[value] = list
+		}
+
+		p.postings[name] = values
+	}
+
+	if d.err != nil || len(d.b) != 0 {
+		return nil, errInvalidIndex
+	}
+
+	return p, nil
+}
@@ -0,0 +1,58 @@
+package ftsdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntersectPostings(t *testing.T) {
+	require.Equal(t, []uint64{3, 7}, intersectPostings([]uint64{1, 3, 5, 7}, []uint64{2, 3, 7, 9}, []uint64{3, 4, 7}))
+	require.Empty(t, intersectPostings([]uint64{1, 2}, []uint64{3, 4}))
+	require.Empty(t, intersectPostings([]uint64{1, 2}, nil))
+	require.Nil(t, intersectPostings())
+}
+
+func TestUnionPostings(t *testing.T) {
+	require.Equal(t, []uint64{1, 2, 3, 5, 7, 9}, unionPostings([]uint64{1, 3, 5, 7}, []uint64{2, 3, 7, 9}, nil))
+	require.Empty(t, unionPostings())
+}
+
+func TestPostingsIndex(t *testing.T) {
+	p := newPostingsIndex()
+	p.add(5, "cpu", LabelsFromMap(map[string]string{"host": "web-1", "region": "eu"}))
+	p.add(2, "cpu", LabelsFromMap(map[string]string{"host": "web-2", "region": "eu"}))
+	p.add(9, "ram", LabelsFromMap(map[string]string{"host": "web-1"}))
+	p.add(5, "cpu", LabelsFromMap(map[string]string{"host": "web-1", "region": "eu"}))
+
+	require.Equal(t, []uint64{2, 5, 9}, p.all())
+	require.Equal(t, []uint64{2, 5}, p.get(MetricLabel, "cpu"))
+	require.Equal(t, []uint64{5, 9}, p.get("host", "web-1"))
+	require.Equal(t, []uint64{2, 5}, p.get("region", "eu"))
+	require.Nil(t, p.get("region", "us"))
+	require.Equal(t, []string{"web-1", "web-2"}, p.values("host"))
+
+	decoded, err := decodePostingsIndex(encodePostingsIndex(p))
+	require.NoError(t, err)
+	require.Equal(t, p.postings, decoded.postings)
+}
+
+func TestDecodePostingsIndexErrors(t *testing.T) {
+	p := newPostingsIndex()
+	p.add(0, "cpu", LabelsFromMap(map[string]string{"host": "mac"}))
+	encoded := encodePostingsIndex(p)
+
+	_, err := decodePostingsIndex(encoded[:3])
+	require.Error(t, err)
+
+	corrupted := append([]byte(nil), encoded...)
+	corrupted[len(corrupted)/2] ^= 0xff
+	_, err = decodePostingsIndex(corrupted)
+	require.Error(t, err)
+}
+
+func TestSubtractPostings(t *testing.T) {
+	require.Equal(t, []uint64{1, 5}, subtractPostings([]uint64{1, 3, 5, 7}, []uint64{2, 3, 7, 9}))
+	require.Equal(t, []uint64{1, 3}, subtractPostings([]uint64{1, 3}, nil))
+	require.Empty(t, subtractPostings(nil, []uint64{1}))
+}
@@ -0,0 +1,181 @@
+package ftsdb
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// RetentionMetrics reports what retention dropped since the database was
+// opened.
+type RetentionMetrics struct {
+	// Runs counts the times retention was enforced
+	Runs int64
+	// DroppedChunks and DroppedBytes count the chunk directories dropped
+	// and their size on disk
+	DroppedChunks int64
+	DroppedBytes  int64
+	// DroppedBeforeTimestamp is the max timestamp of the newest chunk
+	// dropped so far
+	DroppedBeforeTimestamp int64
+	LastRun                time.Time
+}
+
+type retention struct {
+	loop loop
+
+	mtx      sync.Mutex
+	maxAge   time.Duration
+	maxBytes int64
+	metrics  RetentionMetrics
+}
+
+// SetRetention drops chunk directories whose datapoints are all more than
+// maxAge older than the newest datapoint committed, and the oldest chunk
+// directories while all of them take more than maxBytes on disk. Zero
+// disables either limit. Retention is only enforced by EnforceRetention and
+// in the background after SetRetentionInterval.
+func (ftsdb *ftsdb) SetRetention(maxAge time.Duration, maxBytes int64) {
+	ftsdb.retention.mtx.Lock()
+	defer ftsdb.retention.mtx.Unlock()
+
+	ftsdb.retention.maxAge = maxAge
+	ftsdb.retention.maxBytes = maxBytes
+}
+
+// SetRetentionInterval enforces retention in the background every interval,
+// a zero interval stops it.
+func (ftsdb *ftsdb) SetRetentionInterval(interval time.Duration) {
+	ftsdb.retention.loop.start(interval, func() {
+		if err := ftsdb.EnforceRetention(); err != nil {
+			ftsdb.logger.Error("enforcing retention failed", zap.Error(err))
+		}
+	})
+}
+
+func (ftsdb *ftsdb) RetentionMetrics() RetentionMetrics {
+	ftsdb.retention.mtx.Lock()
+	defer ftsdb.retention.mtx.Unlock()
+
+	return ftsdb.retention.metrics
+}
+
+// EnforceRetention drops the chunk directories beyond the limits of
+// SetRetention. Whole directories are dropped, so a directory holding
+// datapoints both older and newer than the max age is kept. Queries already
+// reading a dropped directory finish reading it before it is removed.
+func (ftsdb *ftsdb) EnforceRetention() error {
+	ftsdb.retention.mtx.Lock()
+	maxAge, maxBytes := ftsdb.retention.maxAge, ftsdb.retention.maxBytes
+	ftsdb.retention.mtx.Unlock()
+
+	ftsdb.commitMtx.Lock()
+	defer ftsdb.commitMtx.Unlock()
+
+	ftsdb.chunksMtx.RLock()
+	dirs, err := ftsdb.listChunkDirs()
+	ftsdb.chunksMtx.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	readable := []chunkDir{}
+	metas := []ChunkMeta{}
+	sizes := []int64{}
+	var total int64
+	var newest int64
+
+	for _, dir := range dirs {
+		meta, err := readChunkMeta(ftsdb.dir, dir.name)
+		if err != nil {
+			if isChunkError(err) {
+				ftsdb.logger.Warn("not enforcing retention on chunk", zap.Error(err))
+				continue
+			}
+			return err
+		}
+
+		size, err := dirSize(filepath.Join(ftsdb.dir, dir.name))
+		if err != nil {
+			return err
+		}
+
+		if len(metas) == 0 || meta.MaxTimestamp > newest {
+			newest = meta.MaxTimestamp
+		}
+
+		readable = append(readable, dir)
+		metas = append(metas, meta)
+		sizes = append(sizes, size)
+		total += size
+	}
+
+	dropped := []string{}
+	var droppedBytes int64
+	droppedBefore := int64(0)
+
+	// dirs are ordered by min timestamp, so the oldest go first
+	for idx, dir := range readable {
+		expired := maxAge > 0 && metas[idx].MaxTimestamp < newest-maxAge.Milliseconds()
+		oversized := maxBytes > 0 && total-droppedBytes > maxBytes
+		if !expired && !oversized {
+			continue
+		}
+
+		dropped = append(dropped, dir.name)
+		droppedBytes += sizes[idx]
+		if len(dropped) == 1 || metas[idx].MaxTimestamp > droppedBefore {
+			droppedBefore = metas[idx].MaxTimestamp
+		}
+	}
+
+	if len(dropped) > 0 {
+		if err := ftsdb.dropChunkDirs(dropped); err != nil {
+			return err
+		}
+
+		ftsdb.logger.Info("dropped chunks by retention",
+			zap.Strings("chunks", dropped),
+			zap.Int64("bytes", droppedBytes),
+			zap.Int64("maxTimestamp", droppedBefore),
+		)
+	}
+
+	ftsdb.retention.mtx.Lock()
+	defer ftsdb.retention.mtx.Unlock()
+
+	metrics := &ftsdb.retention.metrics
+	metrics.Runs++
+	metrics.LastRun = time.Now()
+	if len(dropped) > 0 {
+		metrics.DroppedChunks += int64(len(dropped))
+		metrics.DroppedBytes += droppedBytes
+		if droppedBefore > metrics.DroppedBeforeTimestamp {
+			metrics.DroppedBeforeTimestamp = droppedBefore
+		}
+	}
+
+	return nil
+}
+
+// dirSize sums the sizes of the files in dir.
+func dirSize(dir string) (int64, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, file := range files {
+		info, err := file.Info()
+		if err != nil {
+			return 0, err
+		}
+		size += info.Size()
+	}
+
+	return size, nil
+}
@@ -0,0 +1,98 @@
+package ftsdb
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRetentionMaxAge(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := NewFTSDB(zap.NewNop(), dir)
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetFlushLimit(1)
+
+	commitEach(t, db, 0, hour, 3*hour, 5*hour)
+
+	// without limits nothing is dropped
+	require.NoError(t, db.EnforceRetention())
+	require.Len(t, chunkDirNames(t, dir), 4)
+
+	db.SetRetention(2*time.Hour, 0)
+
+	ss, err := db.Find(Query{})
+	require.NoError(t, err)
+
+	require.NoError(t, db.EnforceRetention())
+
+	require.Equal(t, map[string][]Datapoint{
+		"cpu/mac": {{3 * hour, float64(3 * hour)}, {5 * hour, float64(5 * hour)}},
+		"cpu/win": {{3 * hour, float64(-3 * hour)}, {5 * hour, float64(-5 * hour)}},
+	}, collectFind(t, db, Query{}))
+
+	// a query started before still reads the dropped chunks
+	datapoints := 0
+	for ss.Next() != nil {
+		for it := ss.DatapointsIterator; it.Next() != nil; {
+			datapoints++
+		}
+	}
+	require.NoError(t, ss.Err())
+	require.Equal(t, 8, datapoints)
+	require.ElementsMatch(t, []string{"10800000", "18000000"}, chunkDirNames(t, dir))
+
+	metrics := db.RetentionMetrics()
+	require.Equal(t, int64(2), metrics.Runs)
+	require.Equal(t, int64(2), metrics.DroppedChunks)
+	require.Greater(t, metrics.DroppedBytes, int64(0))
+	require.Equal(t, hour, metrics.DroppedBeforeTimestamp)
+	require.False(t, metrics.LastRun.IsZero())
+}
+
+func TestRetentionMaxBytes(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := NewFTSDB(zap.NewNop(), dir)
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetFlushLimit(1)
+
+	commitEach(t, db, 0, hour, 3*hour, 5*hour)
+
+	var newest int64
+	for _, name := range []string{"10800000", "18000000"} {
+		size, err := dirSize(filepath.Join(dir, name))
+		require.NoError(t, err)
+		newest += size
+	}
+
+	db.SetRetention(0, newest)
+	require.NoError(t, db.EnforceRetention())
+
+	require.ElementsMatch(t, []string{"10800000", "18000000"}, chunkDirNames(t, dir))
+	require.Equal(t, int64(2), db.RetentionMetrics().DroppedChunks)
+}
+
+func TestRetentionInterval(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := NewFTSDB(zap.NewNop(), dir)
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetFlushLimit(1)
+
+	commitEach(t, db, 0, hour, 3*hour)
+
+	db.SetRetention(time.Hour, 0)
+	db.SetRetentionInterval(10 * time.Millisecond)
+	require.Eventually(t, func() bool {
+		return len(chunkDirNames(t, dir)) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	db.SetRetentionInterval(0)
+}
@@ -0,0 +1,258 @@
+package ftsdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+// tombstonesFile lists the deleted ranges of the series of a chunk
+// directory. Unlike the chunk files it is written after the directory is in
+// place, always through a rename. Find leaves the ranges out and compaction
+// rewrites the chunk without them.
+const tombstonesFile = "tombstones"
+
+// tombstone deletes the datapoints of the series at index Series of a chunk
+// from MinTimestamp to MaxTimestamp, both included.
+type tombstone struct {
+	Series       int
+	MinTimestamp int64
+	MaxTimestamp int64
+}
+
+// tombstones are the deleted ranges of a chunk by series, sorted and not
+// overlapping.
+type tombstones map[int][]tombstone
+
+// add deletes the range mint to maxt of series, merging it with the ranges
+// it overlaps or touches.
+func (t tombstones) add(series int, mint, maxt int64) {
+	ranges := append(t[series], tombstone{Series: series, MinTimestamp: mint, MaxTimestamp: maxt})
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].MinTimestamp < ranges[j].MinTimestamp })
+
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r.MinTimestamp-1 <= last.MaxTimestamp {
+			last.MaxTimestamp = max(last.MaxTimestamp, r.MaxTimestamp)
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	t[series] = merged
+}
+
+func (t tombstones) deleted(series int, ts int64) bool {
+	for _, r := range t[series] {
+		if ts >= r.MinTimestamp && ts <= r.MaxTimestamp {
+			return true
+		}
+	}
+	return false
+}
+
+// covers tells if the whole range mint to maxt of series is deleted.
+func (t tombstones) covers(series int, mint, maxt int64) bool {
+	for _, r := range t[series] {
+		if r.MinTimestamp <= mint && r.MaxTimestamp >= maxt {
+			return true
+		}
+	}
+	return false
+}
+
+// deletesAll tells if every datapoint of data, the datapoints of series, is
+// deleted.
+func (t tombstones) deletesAll(series int, data []ChunkData) bool {
+	for _, d := range data {
+		if !t.deleted(series, d.Datapoint.Timestamp) {
+			return false
+		}
+	}
+	return true
+}
+
+// readTombstones reads the tombstones of the chunk directory name. A chunk
+// without any has none.
+func readTombstones(dbDir string, name string) (tombstones, error) {
+	dir := filepath.Join(dbDir, name)
+
+	data, err := os.ReadFile(filepath.Join(dir, tombstonesFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return tombstones{}, nil
+		}
+		return nil, err
+	}
+
+	list := []tombstone{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, &ErrCorruptChunk{Dir: dir, Err: err}
+	}
+
+	t := tombstones{}
+	for _, r := range list {
+		t.add(r.Series, r.MinTimestamp, r.MaxTimestamp)
+	}
+	return t, nil
+}
+
+// writeTombstones replaces the tombstones of the chunk directory name.
+func writeTombstones(dbDir string, name string, t tombstones) error {
+	list := []tombstone{}
+	for _, ranges := range t {
+		list = append(list, ranges...)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Series != list[j].Series {
+			return list[i].Series < list[j].Series
+		}
+		return list[i].MinTimestamp < list[j].MinTimestamp
+	})
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(dbDir, name)
+	tmp := filepath.Join(dir, tombstonesFile+".tmp")
+
+	if err := writeFileSync(tmp, data); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, filepath.Join(dir, tombstonesFile)); err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// Delete deletes the datapoints from mint to maxt, both included, of the
+// series selected by every matcher. The head is committed first, so that
+// all of them are in chunks, where a tombstone is recorded for every
+// series. Find leaves them out right away and Compact drops them from disk.
+// Datapoints appended while Delete runs are not deleted.
+func (ftsdb *ftsdb) Delete(matchers []*Matcher, mint, maxt int64) error {
+	if len(matchers) == 0 {
+		return errors.New("delete without matchers")
+	}
+	if mint > maxt {
+		return fmt.Errorf("invalid delete range %d to %d", mint, maxt)
+	}
+
+	ftsdb.commitMtx.Lock()
+	defer ftsdb.commitMtx.Unlock()
+
+	if err := ftsdb.commit(1); err != nil {
+		return err
+	}
+
+	query := (&Query{}).Where(matchers...)
+
+	// Find reads the tombstones of the chunks it lists under the read lock,
+	// so a query sees all of a delete or none of it
+	ftsdb.chunksMtx.Lock()
+	defer ftsdb.chunksMtx.Unlock()
+
+	dirs, err := ftsdb.listChunkDirs()
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		meta, err := readChunkMeta(ftsdb.dir, dir.name)
+		if err == nil && (meta.MaxTimestamp < mint || meta.MinTimestamp > maxt) {
+			continue
+		}
+
+		var index *postingsIndex
+		var deleted tombstones
+		if err == nil {
+			index, err = readChunkIndex(ftsdb.dir, dir.name, meta)
+		}
+		if err == nil {
+			deleted, err = readTombstones(ftsdb.dir, dir.name)
+		}
+		if err != nil {
+			if isChunkError(err) {
+				ftsdb.logger.Warn("not deleting from chunk", zap.Error(err))
+				continue
+			}
+			return err
+		}
+
+		// ranges are kept within the chunk, so covers can tell when a
+		// series is deleted from all of it
+		from, to := max(mint, meta.MinTimestamp), min(maxt, meta.MaxTimestamp)
+
+		changed := false
+		for _, id := range query.selectPostings(index) {
+			series := int(id)
+			if id >= uint64(len(meta.Series)) || deleted.covers(series, from, to) {
+				continue
+			}
+			deleted.add(series, from, to)
+			changed = true
+
+			// a series with nothing left is deleted from all of the chunk,
+			// as Find skips those without reading their datapoints
+			data, err := readChunkSeries(ftsdb.dir, dir.name, series)
+			if err != nil && !isChunkError(err) {
+				return err
+			}
+			if err == nil && deleted.deletesAll(series, data) {
+				deleted[series] = []tombstone{{Series: series, MinTimestamp: meta.MinTimestamp, MaxTimestamp: meta.MaxTimestamp}}
+			}
+		}
+
+		if !changed {
+			continue
+		}
+
+		if err := writeTombstones(ftsdb.dir, dir.name, deleted); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// withoutTombstones returns the chunk without the deleted datapoints and
+// without the series that have none left.
+func (c *Chunk) withoutTombstones(deleted tombstones) *Chunk {
+	if len(deleted) == 0 {
+		return c
+	}
+
+	chunk := NewChunk()
+	remap := map[int64]int64{}
+
+	for _, d := range c.Data {
+		if deleted.deleted(int(d.Series), d.Datapoint.Timestamp) {
+			continue
+		}
+
+		series, found := remap[d.Series]
+		if !found {
+			chunk.Meta.Series = append(chunk.Meta.Series, c.Meta.Series[d.Series])
+			chunk.Meta.Metrics = append(chunk.Meta.Metrics, c.Meta.MetricAt(int(d.Series)))
+			series = int64(len(chunk.Meta.Series) - 1)
+			remap[d.Series] = series
+		}
+
+		chunk.Data = append(chunk.Data, ChunkData{Series: series, Datapoint: d.Datapoint})
+
+		chunk.Meta.MinTimestamp = min(chunk.Meta.MinTimestamp, d.Datapoint.Timestamp)
+		chunk.Meta.MaxTimestamp = max(chunk.Meta.MaxTimestamp, d.Datapoint.Timestamp)
+	}
+
+	return chunk
+}
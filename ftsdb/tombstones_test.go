@@ -0,0 +1,181 @@
+package ftsdb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// findSeries lists the hosts of the series Find returns, with or without
+// datapoints.
+func findSeries(t *testing.T, db DBInterface, query Query) []string {
+	ss, err := db.Find(query)
+	require.NoError(t, err)
+
+	hosts := []string{}
+	for ss.Next() != nil {
+		hosts = append(hosts, ss.GetSeries().SeriesValue["host"])
+	}
+	require.NoError(t, ss.Err())
+
+	return hosts
+}
+
+func TestDelete(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := NewFTSDB(zap.NewNop(), dir)
+	require.NoError(t, err)
+	db.SetFlushLimit(1)
+
+	commitEach(t, db, 0, hour, 2*hour, 3*hour)
+
+	// still in the head, Delete commits it first
+	require.NoError(t, db.CreateMetric("cpu").Append(map[string]string{"host": "win"}, 4*hour, float64(-4*hour)))
+
+	require.NoError(t, db.Delete([]*Matcher{MustNewMatcher(MatchEqual, "host", "win")}, 0, 4*hour))
+	require.Equal(t, []string{"mac"}, findSeries(t, db, Query{}))
+
+	require.NoError(t, db.Delete([]*Matcher{
+		MustNewMatcher(MatchEqual, MetricLabel, "cpu"),
+		MustNewMatcher(MatchRegexp, "host", "m.*"),
+	}, hour, 2*hour))
+
+	expected := map[string][]Datapoint{
+		"cpu/mac": {{0, 0}, {3 * hour, float64(3 * hour)}},
+	}
+	require.Equal(t, expected, collectFind(t, db, Query{}))
+
+	// appends after a delete are kept
+	require.NoError(t, db.CreateMetric("cpu").Append(map[string]string{"host": "win"}, 5*hour, float64(-5*hour)))
+	require.NoError(t, db.Commit())
+	expected["cpu/win"] = []Datapoint{{5 * hour, float64(-5 * hour)}}
+	require.Equal(t, expected, collectFind(t, db, Query{}))
+
+	// tombstones are kept on disk
+	db.Close()
+	db, err = NewFTSDB(zap.NewNop(), dir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.Equal(t, expected, collectFind(t, db, Query{}))
+}
+
+func TestDeleteSeriesOfSharedChunk(t *testing.T) {
+	db, err := NewFTSDB(zap.NewNop(), t.TempDir())
+	require.NoError(t, err)
+	defer db.Close()
+
+	// one chunk from 0 to 10, the other series only hold a part of it
+	cpu := db.CreateMetric("cpu")
+	require.NoError(t, cpu.Append(map[string]string{"host": "mac"}, 0, 0))
+	require.NoError(t, cpu.Append(map[string]string{"host": "mac"}, 10, 10))
+	require.NoError(t, cpu.Append(map[string]string{"host": "win"}, 5, 5))
+	require.NoError(t, cpu.Append(map[string]string{"host": "lin"}, 3, 3))
+	require.NoError(t, cpu.Append(map[string]string{"host": "lin"}, 7, 7))
+	db.SetFlushLimit(1)
+	require.NoError(t, db.Commit())
+
+	require.NoError(t, db.Delete([]*Matcher{MustNewMatcher(MatchEqual, "host", "win")}, 4, 6))
+	require.ElementsMatch(t, []string{"mac", "lin"}, findSeries(t, db, Query{}))
+
+	// deleted in parts
+	require.NoError(t, db.Delete([]*Matcher{MustNewMatcher(MatchEqual, "host", "lin")}, 0, 3))
+	require.ElementsMatch(t, []string{"mac", "lin"}, findSeries(t, db, Query{}))
+	require.NoError(t, db.Delete([]*Matcher{MustNewMatcher(MatchEqual, "host", "lin")}, 6, 8))
+	require.Equal(t, []string{"mac"}, findSeries(t, db, Query{}))
+}
+
+func TestDeleteKeepsRunningQueries(t *testing.T) {
+	db, err := NewFTSDB(zap.NewNop(), t.TempDir())
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetFlushLimit(1)
+
+	commitEach(t, db, 0, hour)
+
+	ss, err := db.Find(*(&Query{}).Series(map[string]string{"host": "mac"}))
+	require.NoError(t, err)
+
+	require.NoError(t, db.Delete([]*Matcher{MustNewMatcher(MatchEqual, "host", "mac")}, 0, hour))
+
+	// the tombstones were read when the query started
+	require.NotNil(t, ss.Next())
+	datapoints := []Datapoint{}
+	for it := ss.DatapointsIterator; it.Next() != nil; {
+		datapoints = append(datapoints, it.GetDatapoint())
+	}
+	require.NoError(t, ss.Err())
+	require.Equal(t, []Datapoint{{0, 0}, {hour, float64(hour)}}, datapoints)
+
+	require.Empty(t, collectFind(t, db, *(&Query{}).Series(map[string]string{"host": "mac"})))
+}
+
+func TestDeleteCompacts(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := NewFTSDB(zap.NewNop(), dir)
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetFlushLimit(1)
+
+	commitEach(t, db, 0, 3*hour, 5*hour)
+
+	require.NoError(t, db.Delete([]*Matcher{MustNewMatcher(MatchEqual, "host", "win")}, 3*hour, 5*hour))
+	require.NoError(t, db.Delete([]*Matcher{MustNewMatcher(MatchNotEqual, "host", "")}, 5*hour, 5*hour))
+
+	before := collectFind(t, db, Query{})
+
+	require.NoError(t, db.Compact())
+
+	// the last chunk had nothing left and is dropped, the middle one is
+	// rewritten without win
+	require.ElementsMatch(t, []string{"0", "10800000_1"}, chunkDirNames(t, dir))
+
+	for _, name := range chunkDirNames(t, dir) {
+		_, err := os.Stat(filepath.Join(dir, name, tombstonesFile))
+		require.True(t, os.IsNotExist(err))
+	}
+
+	chunk, err := readChunk(dir, "10800000_1")
+	require.NoError(t, err)
+	require.Equal(t, []map[string]string{{"host": "mac"}}, chunk.Meta.Series)
+	require.Len(t, chunk.Data, 1)
+
+	require.Equal(t, before, collectFind(t, db, Query{}))
+	require.Equal(t, []string{"mac", "win"}, findSeries(t, db, Query{}))
+
+	// nothing left to do
+	require.NoError(t, db.Compact())
+	require.ElementsMatch(t, []string{"0", "10800000_1"}, chunkDirNames(t, dir))
+}
+
+func TestDeleteInvalid(t *testing.T) {
+	db, err := NewFTSDB(zap.NewNop(), t.TempDir())
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.Error(t, db.Delete(nil, 0, hour))
+	require.Error(t, db.Delete([]*Matcher{MustNewMatcher(MatchEqual, "host", "mac")}, hour, 0))
+}
+
+func TestTombstonesAdd(t *testing.T) {
+	deleted := tombstones{}
+	deleted.add(0, 10, 20)
+	deleted.add(0, 30, 40)
+	deleted.add(1, 0, 5)
+
+	require.True(t, deleted.deleted(0, 15))
+	require.False(t, deleted.deleted(0, 25))
+	require.False(t, deleted.deleted(2, 15))
+	require.False(t, deleted.covers(0, 10, 40))
+
+	// touching and overlapping ranges are merged
+	deleted.add(0, 21, 29)
+	deleted.add(0, 35, 50)
+	require.Equal(t, []tombstone{{Series: 0, MinTimestamp: 10, MaxTimestamp: 50}}, deleted[0])
+	require.True(t, deleted.covers(0, 10, 40))
+}
@@ -0,0 +1,544 @@
+package ftsdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	walDirName = "wal"
+
+	// walSegmentMaxSize is the size after which appends are routed to a new segment.
+	walSegmentMaxSize = 32 * 1024 * 1024
+
+	// record header: type (1 byte) | payload length (4 bytes) | crc32 of payload (4 bytes)
+	walRecordHeaderSize = 9
+
+	walDefaultSyncInterval = time.Second
+)
+
+var walCastagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+type walRecordType byte
+
+const (
+	walRecordSeries walRecordType = iota + 1
+	walRecordSample
+)
+
+// WALSyncPolicy decides when appended WAL records are fsynced to disk.
+type WALSyncPolicy int
+
+const (
+	// WALSyncAlways fsyncs after every append. Nothing acknowledged is lost on crash.
+	WALSyncAlways WALSyncPolicy = iota
+	// WALSyncInterval fsyncs in the background at a fixed interval, so a crash
+	// loses at most one interval worth of appends.
+	WALSyncInterval
+	// WALSyncNone never fsyncs explicitly and leaves it to the operating system.
+	WALSyncNone
+)
+
+type walRecord struct {
+	typ       walRecordType
+	ref       uint64
+	metric    string
+	series    map[string]string
+	timestamp int64
+	value     float64
+}
+
+func (r *walRecord) encode(b []byte) []byte {
+	b = b[:0]
+	b = binary.AppendUvarint(b, r.ref)
+
+	switch r.typ {
+	case walRecordSeries:
+		b = appendWALString(b, r.metric)
+
+		keys := make([]string, 0, len(r.series))
+		for k := range r.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		b = binary.AppendUvarint(b, uint64(len(keys)))
+		for _, k := range keys {
+			b = appendWALString(b, k)
+			b = appendWALString(b, r.series[k])
+		}
+	case walRecordSample:
+		b = binary.AppendVarint(b, r.timestamp)
+		b = binary.BigEndian.AppendUint64(b, math.Float64bits(r.value))
+	}
+
+	return b
+}
+
+func decodeWALRecord(typ walRecordType, b []byte) (walRecord, error) {
+	rec := walRecord{typ: typ}
+	d := walDecoder{b: b}
+
+	rec.ref = d.uvarint()
+
+	switch typ {
+	case walRecordSeries:
+		rec.metric = d.string()
+		n := d.uvarint()
+		if d.err == nil {
+			rec.series = make(map[string]string, n)
+		}
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			k := d.string()
+			rec.series[k] = d.string()
+		}
+	case walRecordSample:
+		rec.timestamp = d.varint()
+		rec.value = math.Float64frombits(d.uint64())
+	default:
+		return rec, fmt.Errorf("unknown wal record type %d", typ)
+	}
+
+	if d.err == nil && len(d.b) != 0 {
+		d.err = fmt.Errorf("%d unexpected trailing bytes", len(d.b))
+	}
+
+	return rec, d.err
+}
+
+func appendWALString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+var errWALShortRecord = errors.New("short wal record")
+
+type walDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *walDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.err = errWALShortRecord
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *walDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.err = errWALShortRecord
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *walDecoder) uint64() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.b) < 8 {
+		d.err = errWALShortRecord
+		return 0
+	}
+	v := binary.BigEndian.Uint64(d.b)
+	d.b = d.b[8:]
+	return v
+}
+
+func (d *walDecoder) string() string {
+	n := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if uint64(len(d.b)) < n {
+		d.err = errWALShortRecord
+		return ""
+	}
+	s := string(d.b[:n])
+	d.b = d.b[n:]
+	return s
+}
+
+func walSegmentName(dir string, idx int) string {
+	return filepath.Join(dir, fmt.Sprintf("%08d", idx))
+}
+
+// walSegments returns the indexes of all segments in dir in ascending order.
+func walSegments(dir string) ([]int, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	segments := []int{}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		idx, err := strconv.Atoi(file.Name())
+		if err != nil {
+			continue
+		}
+		segments = append(segments, idx)
+	}
+
+	sort.Ints(segments)
+
+	return segments, nil
+}
+
+// readWAL calls fn for every record in dir, oldest first. A torn or corrupt
+// record ends the log: the segment is truncated right before it and every
+// later segment is removed, so the next open starts from a clean tail.
+func readWAL(dir string, logger *zap.Logger, fn func(rec walRecord) error) error {
+	segments, err := walSegments(dir)
+	if err != nil {
+		return err
+	}
+
+	for i, idx := range segments {
+		filename := walSegmentName(dir, idx)
+
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+
+		offset := 0
+		var corruption error
+
+		for offset < len(data) {
+			if len(data)-offset < walRecordHeaderSize {
+				corruption = errWALShortRecord
+				break
+			}
+
+			typ := walRecordType(data[offset])
+			length := int(binary.BigEndian.Uint32(data[offset+1:]))
+			checksum := binary.BigEndian.Uint32(data[offset+5:])
+
+			start := offset + walRecordHeaderSize
+			if length > len(data)-start {
+				corruption = errWALShortRecord
+				break
+			}
+
+			payload := data[start : start+length]
+			if crc32.Checksum(payload, walCastagnoli) != checksum {
+				corruption = errors.New("wal record checksum mismatch")
+				break
+			}
+
+			rec, err := decodeWALRecord(typ, payload)
+			if err != nil {
+				corruption = err
+				break
+			}
+
+			if err := fn(rec); err != nil {
+				return err
+			}
+
+			offset = start + length
+		}
+
+		if corruption == nil {
+			continue
+		}
+
+		logger.Warn(
+			"wal corruption, dropping the rest of the log",
+			zap.String("segment", filename),
+			zap.Int("offset", offset),
+			zap.Error(corruption),
+		)
+
+		if err := os.Truncate(filename, int64(offset)); err != nil {
+			return err
+		}
+
+		for _, later := range segments[i+1:] {
+			if err := os.Remove(walSegmentName(dir, later)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return nil
+}
+
+type wal struct {
+	mtx    sync.Mutex
+	dir    string
+	logger *zap.Logger
+
+	segment     *os.File
+	segmentIdx  int
+	segmentSize int64
+	buf         *bufio.Writer
+	scratch     []byte
+
+	policy   WALSyncPolicy
+	interval time.Duration
+	dirty    bool
+	stopc    chan struct{}
+	donec    chan struct{}
+}
+
+// openWAL opens a fresh segment in dir, after any existing ones. Existing
+// segments are expected to have been replayed with readWAL beforehand.
+func openWAL(dir string, logger *zap.Logger) (*wal, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+
+	segments, err := walSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &wal{
+		dir:      dir,
+		logger:   logger,
+		policy:   WALSyncInterval,
+		interval: walDefaultSyncInterval,
+	}
+
+	next := 0
+	if len(segments) > 0 {
+		next = segments[len(segments)-1] + 1
+	}
+
+	if err := w.openSegment(next); err != nil {
+		return nil, err
+	}
+
+	w.startSyncLoop()
+
+	return w, nil
+}
+
+func (w *wal) openSegment(idx int) error {
+	f, err := os.OpenFile(walSegmentName(w.dir, idx), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+
+	if err := syncDir(w.dir); err != nil {
+		f.Close()
+		return err
+	}
+
+	w.segment = f
+	w.segmentIdx = idx
+	w.segmentSize = 0
+	w.buf = bufio.NewWriterSize(f, 32*1024)
+
+	return nil
+}
+
+func (w *wal) closeSegment() error {
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	if err := w.segment.Sync(); err != nil {
+		return err
+	}
+	w.dirty = false
+	return w.segment.Close()
+}
+
+func (w *wal) logSeries(ref uint64, metric string, series map[string]string) error {
+	return w.log(&walRecord{typ: walRecordSeries, ref: ref, metric: metric, series: series})
+}
+
+func (w *wal) logSample(ref uint64, timestamp int64, value float64) error {
+	return w.log(&walRecord{typ: walRecordSample, ref: ref, timestamp: timestamp, value: value})
+}
+
+func (w *wal) log(rec *walRecord) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	w.scratch = rec.encode(w.scratch)
+	size := int64(walRecordHeaderSize + len(w.scratch))
+
+	if w.segmentSize > 0 && w.segmentSize+size > walSegmentMaxSize {
+		if err := w.closeSegment(); err != nil {
+			return err
+		}
+		if err := w.openSegment(w.segmentIdx + 1); err != nil {
+			return err
+		}
+	}
+
+	var header [walRecordHeaderSize]byte
+	header[0] = byte(rec.typ)
+	binary.BigEndian.PutUint32(header[1:], uint32(len(w.scratch)))
+	binary.BigEndian.PutUint32(header[5:], crc32.Checksum(w.scratch, walCastagnoli))
+
+	if _, err := w.buf.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := w.buf.Write(w.scratch); err != nil {
+		return err
+	}
+	w.segmentSize += size
+
+	if w.policy == WALSyncAlways {
+		return w.syncLocked()
+	}
+
+	w.dirty = true
+	return nil
+}
+
+func (w *wal) syncLocked() error {
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	if err := w.segment.Sync(); err != nil {
+		return err
+	}
+	w.dirty = false
+	return nil
+}
+
+func (w *wal) sync() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	return w.syncLocked()
+}
+
+func (w *wal) startSyncLoop() {
+	if w.policy != WALSyncInterval {
+		return
+	}
+
+	w.stopc = make(chan struct{})
+	w.donec = make(chan struct{})
+
+	go func(stopc, donec chan struct{}, interval time.Duration) {
+		defer close(donec)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stopc:
+				return
+			case <-ticker.C:
+				w.mtx.Lock()
+				if w.dirty {
+					if err := w.syncLocked(); err != nil {
+						w.logger.Error("wal sync failed", zap.Error(err))
+					}
+				}
+				w.mtx.Unlock()
+			}
+		}
+	}(w.stopc, w.donec, w.interval)
+}
+
+func (w *wal) stopSyncLoop() {
+	if w.stopc == nil {
+		return
+	}
+	close(w.stopc)
+	<-w.donec
+	w.stopc = nil
+	w.donec = nil
+}
+
+func (w *wal) setSyncPolicy(policy WALSyncPolicy, interval time.Duration) error {
+	w.stopSyncLoop()
+
+	w.mtx.Lock()
+	w.policy = policy
+	if interval > 0 {
+		w.interval = interval
+	}
+	err := w.syncLocked()
+	w.mtx.Unlock()
+
+	w.startSyncLoop()
+
+	return err
+}
+
+// cut starts a new segment and returns its index. Records logged before the
+// call are all in earlier segments.
+func (w *wal) cut() (int, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if err := w.closeSegment(); err != nil {
+		return 0, err
+	}
+
+	if err := w.openSegment(w.segmentIdx + 1); err != nil {
+		return 0, err
+	}
+
+	return w.segmentIdx, nil
+}
+
+// truncateBefore removes the segments before segment. It must only be called
+// once everything they cover has been durably written elsewhere.
+func (w *wal) truncateBefore(segment int) error {
+	segments, err := walSegments(w.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range segments {
+		if idx >= segment {
+			break
+		}
+		if err := os.Remove(walSegmentName(w.dir, idx)); err != nil {
+			return err
+		}
+	}
+
+	return syncDir(w.dir)
+}
+
+func (w *wal) close() error {
+	w.stopSyncLoop()
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	return w.closeSegment()
+}
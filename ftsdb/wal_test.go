@@ -0,0 +1,204 @@
+package ftsdb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func collectInMemory(db DBInterface) map[string][]ftsdbDataPoint {
+	collected := map[string][]ftsdbDataPoint{}
+
+	for _, metric := range db.(*ftsdb).inMemory.metrics() {
+		for _, series := range metric.allSeries() {
+			key := metric.metric + "/" + series.series["host"]
+			for _, dp := range series.dataPoints.arr {
+				collected[key] = append(collected[key], *dp.(*ftsdbDataPoint))
+			}
+		}
+	}
+
+	return collected
+}
+
+func TestWALReplay(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	dir := t.TempDir()
+
+	db, err := NewFTSDB(logger, dir)
+	require.NoError(t, err)
+
+	cpu := db.CreateMetric("cpu")
+	ram := db.CreateMetric("ram")
+	for i := 1; i <= 10; i++ {
+		require.NoError(t, cpu.Append(map[string]string{"host": "mac"}, int64(i), float64(i)+0.5))
+		require.NoError(t, cpu.Append(map[string]string{"host": "win"}, int64(i), float64(i)))
+		require.NoError(t, ram.Append(map[string]string{"host": "mac"}, int64(i), float64(i*10)))
+	}
+
+	expected := collectInMemory(db)
+	db.Close()
+
+	reopened, err := NewFTSDB(logger, dir)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	require.Equal(t, expected, collectInMemory(reopened))
+
+	// series created after a restart must not reuse replayed refs
+	require.NoError(t, reopened.CreateMetric("cpu").Append(map[string]string{"host": "linux"}, 11, 11))
+	refs := map[SeriesRef]bool{}
+	for _, metric := range reopened.(*ftsdb).inMemory.metrics() {
+		for _, series := range metric.allSeries() {
+			require.False(t, refs[series.ref])
+			refs[series.ref] = true
+		}
+	}
+}
+
+func TestWALTruncatedAfterCommit(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	dir := t.TempDir()
+
+	db, err := NewFTSDB(logger, dir)
+	require.NoError(t, err)
+	db.SetFlushLimit(1)
+
+	metric := db.CreateMetric("cpu")
+	for i := 1; i <= 10; i++ {
+		require.NoError(t, metric.Append(map[string]string{"host": "mac"}, int64(i), float64(i)))
+	}
+
+	require.NoError(t, db.Commit())
+	db.Close()
+
+	segments, err := walSegments(filepath.Join(dir, walDirName))
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+
+	// only the series record, re-logged for series that outlive the commit
+	records := []walRecord{}
+	require.NoError(t, readWAL(filepath.Join(dir, walDirName), logger, func(rec walRecord) error {
+		records = append(records, rec)
+		return nil
+	}))
+	require.Len(t, records, 1)
+	require.Equal(t, walRecordSeries, records[0].typ)
+
+	reopened, err := NewFTSDB(logger, dir)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	require.Empty(t, collectInMemory(reopened))
+}
+
+func TestWALTornTail(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	dir := t.TempDir()
+
+	w, err := openWAL(dir, logger)
+	require.NoError(t, err)
+
+	require.NoError(t, w.logSeries(1, "cpu", map[string]string{"host": "mac"}))
+	for i := 0; i < 5; i++ {
+		require.NoError(t, w.logSample(1, int64(i), float64(i)))
+	}
+	require.NoError(t, w.close())
+
+	filename := walSegmentName(dir, 0)
+	info, err := os.Stat(filename)
+	require.NoError(t, err)
+
+	// simulate a crash in the middle of writing the last record
+	require.NoError(t, os.Truncate(filename, info.Size()-3))
+
+	records := []walRecord{}
+	require.NoError(t, readWAL(dir, logger, func(rec walRecord) error {
+		records = append(records, rec)
+		return nil
+	}))
+
+	require.Len(t, records, 5)
+	require.Equal(t, walRecordSeries, records[0].typ)
+	require.Equal(t, "cpu", records[0].metric)
+	require.Equal(t, map[string]string{"host": "mac"}, records[0].series)
+	require.Equal(t, int64(3), records[4].timestamp)
+
+	repaired, err := os.Stat(filename)
+	require.NoError(t, err)
+	require.Less(t, repaired.Size(), info.Size()-3)
+}
+
+func TestWALChecksumMismatch(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	dir := t.TempDir()
+
+	w, err := openWAL(dir, logger)
+	require.NoError(t, err)
+	require.NoError(t, w.logSeries(1, "cpu", map[string]string{"host": "mac"}))
+	require.NoError(t, w.logSample(1, 1, 1))
+	require.NoError(t, w.close())
+
+	filename := walSegmentName(dir, 0)
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(filename, data, 0666))
+
+	records := 0
+	require.NoError(t, readWAL(dir, logger, func(rec walRecord) error {
+		records++
+		return nil
+	}))
+	require.Equal(t, 1, records)
+}
+
+func TestWALSyncPolicy(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	dir := t.TempDir()
+
+	w, err := openWAL(dir, logger)
+	require.NoError(t, err)
+	defer w.close()
+
+	require.NoError(t, w.setSyncPolicy(WALSyncAlways, 0))
+	require.NoError(t, w.logSample(1, 1, 1))
+
+	info, err := os.Stat(walSegmentName(dir, 0))
+	require.NoError(t, err)
+	require.NotZero(t, info.Size())
+
+	require.NoError(t, w.setSyncPolicy(WALSyncInterval, 10*time.Millisecond))
+	require.NoError(t, w.logSample(1, 2, 2))
+
+	require.Eventually(t, func() bool {
+		synced, err := os.Stat(walSegmentName(dir, 0))
+		return err == nil && synced.Size() > info.Size()
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestWALReplaySampleBeforeSeries(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	dir := t.TempDir()
+
+	// what a commit leaves behind when an append slips in between cutting
+	// the log and logging the series again
+	w, err := openWAL(filepath.Join(dir, walDirName), logger)
+	require.NoError(t, err)
+	require.NoError(t, w.logSample(1, 1, 1))
+	require.NoError(t, w.logSeries(1, "cpu", map[string]string{"host": "mac"}))
+	require.NoError(t, w.logSample(1, 2, 2))
+	require.NoError(t, w.close())
+
+	db, err := NewFTSDB(logger, dir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.Equal(t, map[string][]ftsdbDataPoint{
+		"cpu/mac": {{1, 1}, {2, 2}},
+	}, collectInMemory(db))
+}
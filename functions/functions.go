@@ -0,0 +1,88 @@
+// Package functions evaluates range functions, like PromQL's rate or
+// avg_over_time, over the datapoints of ftsdb series.
+package functions
+
+import (
+	"errors"
+
+	"github.com/Marvin9/ftsdb/ftsdb"
+)
+
+// Window holds the datapoints of a series with timestamps in [Start, End],
+// in timestamp order. Timestamps are in milliseconds.
+type Window struct {
+	Start      int64
+	End        int64
+	Datapoints []ftsdb.Datapoint
+}
+
+// RangeFunc computes the value of a window. It returns false when the
+// window does not hold enough datapoints for a value.
+type RangeFunc func(w Window) (float64, bool)
+
+// Evaluate applies fn to the window of length window ending at every
+// multiple of step within [start, end]. datapoints must be sorted by
+// timestamp. Steps fn has no value for are left out.
+func Evaluate(datapoints []ftsdb.Datapoint, fn RangeFunc, start, end, step, window int64) []ftsdb.Datapoint {
+	result := []ftsdb.Datapoint{}
+
+	lower, upper := 0, 0
+	for _, ts := range ftsdb.StepTimestamps(start, end, step) {
+		for lower < len(datapoints) && datapoints[lower].Timestamp < ts-window {
+			lower++
+		}
+		if upper < lower {
+			upper = lower
+		}
+		for upper < len(datapoints) && datapoints[upper].Timestamp <= ts {
+			upper++
+		}
+
+		value, ok := fn(Window{
+			Start:      ts - window,
+			End:        ts,
+			Datapoints: datapoints[lower:upper],
+		})
+		if ok {
+			result = append(result, ftsdb.Datapoint{Timestamp: ts, Value: value})
+		}
+	}
+
+	return result
+}
+
+type Series struct {
+	Series     ftsdb.Series
+	Datapoints []ftsdb.Datapoint
+}
+
+// EvaluateSeries runs Evaluate over every series of ss. Series without any
+// value are left out. The query behind ss should cover start-window to end.
+func EvaluateSeries(ss *ftsdb.SeriesIterator, fn RangeFunc, start, end, step, window int64) ([]Series, error) {
+	if step <= 0 || window <= 0 {
+		return nil, errors.New("step and window must be positive")
+	}
+
+	result := []Series{}
+
+	for s := ss.Next(); s != nil; s = s.Next() {
+		datapoints := []ftsdb.Datapoint{}
+		for dd := s.DatapointsIterator.Next(); dd != nil; dd = dd.Next() {
+			datapoints = append(datapoints, dd.GetDatapoint())
+		}
+
+		evaluated := Evaluate(datapoints, fn, start, end, step, window)
+		if len(evaluated) > 0 {
+			result = append(result, Series{
+				Series:     s.GetSeries(),
+				Datapoints: evaluated,
+			})
+		}
+	}
+
+	if err := ss.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
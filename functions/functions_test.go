@@ -0,0 +1,186 @@
+package functions
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/Marvin9/ftsdb/ftsdb"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/require"
+)
+
+const fixtureStart int64 = 1700000000000
+
+// fixtures returns the series shared by ftsdb and prometheus in the tests,
+// by name: counters with resets, gauges, and series with irregular gaps.
+func fixtures() map[string][]ftsdb.Datapoint {
+	r := rand.New(rand.NewSource(1))
+
+	series := map[string][]ftsdb.Datapoint{}
+
+	counter := func(name string, interval int64, resets ...int) {
+		value := 0.0
+		for i := 0; i < 200; i++ {
+			for _, reset := range resets {
+				if i == reset {
+					value = float64(r.Intn(5))
+				}
+			}
+			ts := fixtureStart + int64(i)*interval + int64(r.Intn(1000))
+			series[name] = append(series[name], ftsdb.Datapoint{Timestamp: ts, Value: value})
+			value += float64(r.Intn(100))
+		}
+	}
+
+	gauge := func(name string, interval int64, gaps bool) {
+		ts := fixtureStart
+		for i := 0; i < 200; i++ {
+			ts += interval
+			if gaps && r.Intn(5) == 0 {
+				ts += interval * int64(r.Intn(8))
+			}
+			series[name] = append(series[name], ftsdb.Datapoint{Timestamp: ts, Value: r.NormFloat64() * 100})
+		}
+	}
+
+	counter("counter-regular", 15000)
+	counter("counter-resets", 15000, 40, 41, 120)
+	counter("counter-slow", 45000, 100)
+	gauge("gauge-regular", 10000, false)
+	gauge("gauge-gaps", 10000, true)
+
+	return series
+}
+
+func TestFunctionsMatchPromQL(t *testing.T) {
+	db, err := tsdb.Open(t.TempDir(), nil, nil, tsdb.DefaultOptions(), nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	series := fixtures()
+
+	app := db.Appender(context.Background())
+	for name, datapoints := range series {
+		ls := labels.FromStrings(labels.MetricName, "fixture", "name", name)
+		for _, dp := range datapoints {
+			_, err := app.Append(0, ls, dp.Timestamp, dp.Value)
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, app.Commit())
+
+	engine := promql.NewEngine(promql.EngineOpts{
+		MaxSamples:    1000000,
+		Timeout:       time.Minute,
+		LookbackDelta: 5 * time.Minute,
+	})
+
+	// PromQL steps from start, Evaluate from multiples of step, so start is
+	// a multiple of step for both to evaluate at the same timestamps
+	step := int64(30 * 1000)
+	start := fixtureStart + 100*1000
+	end := fixtureStart + 60*60*1000
+
+	for _, window := range []int64{60 * 1000, 5 * 60 * 1000} {
+		for _, tc := range []struct {
+			function string
+			fn       RangeFunc
+		}{
+			{"rate", Rate},
+			{"irate", Irate},
+			{"increase", Increase},
+			{"delta", Delta},
+			{"deriv", Deriv},
+			{"avg_over_time", AvgOverTime},
+			{"min_over_time", MinOverTime},
+			{"max_over_time", MaxOverTime},
+			{"sum_over_time", SumOverTime},
+			{"count_over_time", CountOverTime},
+			{"last_over_time", LastOverTime},
+			{"quantile_over_time", QuantileOverTime(0.9)},
+		} {
+			expr := fmt.Sprintf("%s(fixture[%dms])", tc.function, window)
+			if tc.function == "quantile_over_time" {
+				expr = fmt.Sprintf("quantile_over_time(0.9, fixture[%dms])", window)
+			}
+
+			query, err := engine.NewRangeQuery(context.Background(), db, nil, expr, time.UnixMilli(start), time.UnixMilli(end), time.Duration(step)*time.Millisecond)
+			require.NoError(t, err)
+
+			result := query.Exec(context.Background())
+			require.NoError(t, result.Err)
+
+			matrix, err := result.Matrix()
+			require.NoError(t, err)
+
+			expected := map[string][]ftsdb.Datapoint{}
+			for _, s := range matrix {
+				for _, p := range s.Floats {
+					expected[s.Metric.Get("name")] = append(expected[s.Metric.Get("name")], ftsdb.Datapoint{Timestamp: p.T, Value: p.F})
+				}
+			}
+			query.Close()
+
+			for name, datapoints := range series {
+				found := Evaluate(datapoints, tc.fn, start, end, step, window)
+
+				require.Len(t, found, len(expected[name]), "%s on %s", expr, name)
+				for idx := range found {
+					require.Equal(t, expected[name][idx].Timestamp, found[idx].Timestamp, "%s on %s", expr, name)
+					want := expected[name][idx].Value
+					require.InDelta(t, want, found[idx].Value, 1e-9*math.Max(1, math.Abs(want)), "%s on %s at %d", expr, name, found[idx].Timestamp)
+				}
+			}
+		}
+	}
+}
+
+func TestRateCounterReset(t *testing.T) {
+	w := Window{
+		Start: 0,
+		End:   40000,
+		Datapoints: []ftsdb.Datapoint{
+			{Timestamp: 0, Value: 10},
+			{Timestamp: 10000, Value: 20},
+			{Timestamp: 20000, Value: 5},
+			{Timestamp: 30000, Value: 15},
+			{Timestamp: 40000, Value: 25},
+		},
+	}
+
+	// 10 + 5 + 10 + 10 after the reset from 20 to 5, with no extrapolation
+	// as the datapoints reach both ends of the window
+	increase, ok := Increase(w)
+	require.True(t, ok)
+	require.Equal(t, 35.0, increase)
+
+	rate, ok := Rate(w)
+	require.True(t, ok)
+	require.Equal(t, 35.0/40, rate)
+
+	irate, ok := Irate(w)
+	require.True(t, ok)
+	require.Equal(t, 1.0, irate)
+
+	// a gauge has no resets
+	delta, ok := Delta(w)
+	require.True(t, ok)
+	require.Equal(t, 15.0, delta)
+
+	_, ok = Rate(Window{Start: 0, End: 10, Datapoints: w.Datapoints[:1]})
+	require.False(t, ok)
+
+	deriv, ok := Deriv(Window{Datapoints: []ftsdb.Datapoint{{Timestamp: 0, Value: 1}, {Timestamp: 1000, Value: 3}, {Timestamp: 2000, Value: 5}}})
+	require.True(t, ok)
+	require.Equal(t, 2.0, deriv)
+
+	deriv, ok = Deriv(Window{Datapoints: []ftsdb.Datapoint{{Timestamp: 0, Value: math.Inf(1)}, {Timestamp: 1000, Value: math.Inf(1)}}})
+	require.True(t, ok)
+	require.True(t, math.IsNaN(deriv))
+}
@@ -0,0 +1,146 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package functions
+
+import (
+	"math"
+
+	"github.com/Marvin9/ftsdb/ftsdb"
+)
+
+// AvgOverTime is the mean of the values in a window.
+//
+// Derived from funcAvgOverTime in promql/functions.go of Prometheus v0.50.1.
+func AvgOverTime(w Window) (float64, bool) {
+	if len(w.Datapoints) == 0 {
+		return 0, false
+	}
+
+	// incremental mean, as a plain sum could overflow
+	var mean kahanSum
+	count := 0.0
+	for _, dp := range w.Datapoints {
+		count++
+		if math.IsInf(mean.sum, 0) {
+			if math.IsInf(dp.Value, 0) && (mean.sum > 0) == (dp.Value > 0) {
+				continue
+			}
+			if !math.IsInf(dp.Value, 0) && !math.IsNaN(dp.Value) {
+				continue
+			}
+		}
+		mean.add(dp.Value/count - mean.sum/count)
+	}
+
+	if math.IsInf(mean.sum, 0) {
+		return mean.sum, true
+	}
+	return mean.value(), true
+}
+
+func MinOverTime(w Window) (float64, bool) {
+	if len(w.Datapoints) == 0 {
+		return 0, false
+	}
+
+	min := w.Datapoints[0].Value
+	for _, dp := range w.Datapoints {
+		if dp.Value < min || math.IsNaN(min) {
+			min = dp.Value
+		}
+	}
+	return min, true
+}
+
+func MaxOverTime(w Window) (float64, bool) {
+	if len(w.Datapoints) == 0 {
+		return 0, false
+	}
+
+	max := w.Datapoints[0].Value
+	for _, dp := range w.Datapoints {
+		if dp.Value > max || math.IsNaN(max) {
+			max = dp.Value
+		}
+	}
+	return max, true
+}
+
+func SumOverTime(w Window) (float64, bool) {
+	if len(w.Datapoints) == 0 {
+		return 0, false
+	}
+
+	var sum kahanSum
+	for _, dp := range w.Datapoints {
+		sum.add(dp.Value)
+	}
+
+	if math.IsInf(sum.sum, 0) {
+		return sum.sum, true
+	}
+	return sum.value(), true
+}
+
+func CountOverTime(w Window) (float64, bool) {
+	if len(w.Datapoints) == 0 {
+		return 0, false
+	}
+	return float64(len(w.Datapoints)), true
+}
+
+func LastOverTime(w Window) (float64, bool) {
+	if len(w.Datapoints) == 0 {
+		return 0, false
+	}
+	return w.Datapoints[len(w.Datapoints)-1].Value, true
+}
+
+// QuantileOverTime returns a RangeFunc computing the φ-quantile of the values
+// in a window.
+func QuantileOverTime(q float64) RangeFunc {
+	return func(w Window) (float64, bool) {
+		if len(w.Datapoints) == 0 {
+			return 0, false
+		}
+
+		values := make([]float64, len(w.Datapoints))
+		for idx, dp := range w.Datapoints {
+			values[idx] = dp.Value
+		}
+		return ftsdb.Quantile(q, values), true
+	}
+}
+
+// kahanSum is a sum with Neumaier compensation for the precision lost when
+// adding numbers of different magnitude, as PromQL uses it.
+//
+// Derived from kahanSumInc in promql/functions.go of Prometheus v0.50.1.
+type kahanSum struct {
+	sum, c float64
+}
+
+func (k *kahanSum) add(inc float64) {
+	t := k.sum + inc
+	if math.Abs(k.sum) >= math.Abs(inc) {
+		k.c += (k.sum - t) + inc
+	} else {
+		k.c += (inc - t) + k.sum
+	}
+	k.sum = t
+}
+
+func (k *kahanSum) value() float64 {
+	return k.sum + k.c
+}
@@ -0,0 +1,160 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package functions
+
+import "math"
+
+// Rate is the per second increase of a counter over the window, extrapolated
+// to the window boundaries and adjusted for counter resets.
+func Rate(w Window) (float64, bool) {
+	return extrapolatedRate(w, true, true)
+}
+
+// Increase is the increase of a counter over the window, extrapolated to the
+// window boundaries and adjusted for counter resets.
+func Increase(w Window) (float64, bool) {
+	return extrapolatedRate(w, true, false)
+}
+
+// Delta is the difference between the first and the last value of a gauge,
+// extrapolated to the window boundaries.
+func Delta(w Window) (float64, bool) {
+	return extrapolatedRate(w, false, false)
+}
+
+// extrapolatedRate follows PromQL: the change seen between the first and the
+// last datapoint is extrapolated to the window boundaries, but only up to half
+// the average interval between datapoints past a boundary that has no
+// datapoint close to it. Counters are never extrapolated below zero.
+//
+// Derived from extrapolatedRate in promql/functions.go of Prometheus v0.50.1.
+func extrapolatedRate(w Window, isCounter, isRate bool) (float64, bool) {
+	points := w.Datapoints
+	if len(points) < 2 {
+		return 0, false
+	}
+
+	first := points[0]
+	last := points[len(points)-1]
+
+	result := last.Value - first.Value
+	if isCounter {
+		prev := first.Value
+		for _, dp := range points[1:] {
+			if dp.Value < prev {
+				result += prev
+			}
+			prev = dp.Value
+		}
+	}
+
+	durationToStart := float64(first.Timestamp-w.Start) / 1000
+	durationToEnd := float64(w.End-last.Timestamp) / 1000
+
+	sampledInterval := float64(last.Timestamp-first.Timestamp) / 1000
+	averageDurationBetweenSamples := sampledInterval / float64(len(points)-1)
+
+	if isCounter && result > 0 && first.Value >= 0 {
+		durationToZero := sampledInterval * (first.Value / result)
+		if durationToZero < durationToStart {
+			durationToStart = durationToZero
+		}
+	}
+
+	extrapolationThreshold := averageDurationBetweenSamples * 1.1
+	extrapolateToInterval := sampledInterval
+
+	if durationToStart < extrapolationThreshold {
+		extrapolateToInterval += durationToStart
+	} else {
+		extrapolateToInterval += averageDurationBetweenSamples / 2
+	}
+	if durationToEnd < extrapolationThreshold {
+		extrapolateToInterval += durationToEnd
+	} else {
+		extrapolateToInterval += averageDurationBetweenSamples / 2
+	}
+
+	factor := extrapolateToInterval / sampledInterval
+	if isRate {
+		factor /= float64(w.End-w.Start) / 1000
+	}
+
+	return result * factor, true
+}
+
+// Irate is the per second rate of a counter between the last two datapoints
+// of the window.
+func Irate(w Window) (float64, bool) {
+	points := w.Datapoints
+	if len(points) < 2 {
+		return 0, false
+	}
+
+	last := points[len(points)-1]
+	previous := points[len(points)-2]
+
+	interval := last.Timestamp - previous.Timestamp
+	if interval == 0 {
+		return 0, false
+	}
+
+	result := last.Value - previous.Value
+	if last.Value < previous.Value {
+		// counter reset
+		result = last.Value
+	}
+
+	return result / (float64(interval) / 1000), true
+}
+
+// Deriv is the per second derivative of a gauge, by simple linear regression
+// over the datapoints of the window.
+//
+// Derived from linearRegression in promql/functions.go of Prometheus v0.50.1.
+func Deriv(w Window) (float64, bool) {
+	points := w.Datapoints
+	if len(points) < 2 {
+		return 0, false
+	}
+
+	// timestamps relative to the first one keep the sums small
+	var sumX, sumY, sumXY, sumX2 kahanSum
+	n := float64(len(points))
+
+	constY := true
+	for _, dp := range points {
+		if dp.Value != points[0].Value {
+			constY = false
+		}
+
+		x := float64(dp.Timestamp-points[0].Timestamp) / 1000
+		sumX.add(x)
+		sumY.add(dp.Value)
+		sumXY.add(x * dp.Value)
+		sumX2.add(x * x)
+	}
+
+	if constY {
+		if math.IsInf(points[0].Value, 0) {
+			return math.NaN(), true
+		}
+		return 0, true
+	}
+
+	covXY := sumXY.value() - sumX.value()*sumY.value()/n
+	varX := sumX2.value() - sumX.value()*sumX.value()/n
+
+	return covXY / varX, true
+}
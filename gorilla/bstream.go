@@ -0,0 +1,114 @@
+package gorilla
+
+import "io"
+
+// BitWriter appends individual bits to a byte slice, most significant bit first.
+type BitWriter struct {
+	stream []byte
+	// number of bits still free in the last byte of stream
+	free uint8
+}
+
+func NewBitWriter() *BitWriter {
+	return &BitWriter{
+		stream: make([]byte, 0, 64),
+	}
+}
+
+func (w *BitWriter) WriteBit(bit bool) {
+	if w.free == 0 {
+		w.stream = append(w.stream, 0)
+		w.free = 8
+	}
+
+	if bit {
+		w.stream[len(w.stream)-1] |= 1 << (w.free - 1)
+	}
+
+	w.free--
+}
+
+// WriteBits writes the nbits least significant bits of u.
+func (w *BitWriter) WriteBits(u uint64, nbits int) {
+	u <<= 64 - uint(nbits)
+
+	for nbits >= 8 {
+		w.writeByte(byte(u >> 56))
+		u <<= 8
+		nbits -= 8
+	}
+
+	for nbits > 0 {
+		w.WriteBit((u >> 63) == 1)
+		u <<= 1
+		nbits--
+	}
+}
+
+func (w *BitWriter) writeByte(b byte) {
+	if w.free == 0 {
+		w.stream = append(w.stream, b)
+		return
+	}
+
+	w.stream[len(w.stream)-1] |= b >> (8 - w.free)
+	w.stream = append(w.stream, b<<w.free)
+}
+
+func (w *BitWriter) Bytes() []byte {
+	return w.stream
+}
+
+// Len returns the number of bits written so far.
+func (w *BitWriter) Len() int {
+	return len(w.stream)*8 - int(w.free)
+}
+
+// BitReader reads back a stream produced by BitWriter.
+type BitReader struct {
+	stream []byte
+	// position of the next bit to read
+	pos int
+}
+
+func NewBitReader(b []byte) *BitReader {
+	return &BitReader{
+		stream: b,
+	}
+}
+
+func (r *BitReader) ReadBit() (bool, error) {
+	if r.pos >= len(r.stream)*8 {
+		return false, io.ErrUnexpectedEOF
+	}
+
+	bit := r.stream[r.pos/8]&(1<<(7-uint(r.pos%8))) != 0
+	r.pos++
+
+	return bit, nil
+}
+
+func (r *BitReader) ReadBits(nbits int) (uint64, error) {
+	if r.pos+nbits > len(r.stream)*8 {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	var u uint64
+	for nbits > 0 {
+		offset := uint(r.pos % 8)
+		available := 8 - int(offset)
+
+		take := available
+		if nbits < take {
+			take = nbits
+		}
+
+		b := uint64(r.stream[r.pos/8]<<offset) >> (8 - uint(take))
+		u = u<<uint(take) | b
+
+		r.pos += take
+		nbits -= take
+	}
+
+	return u, nil
+}
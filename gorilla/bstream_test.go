@@ -0,0 +1,46 @@
+package gorilla
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBitStreamRoundTrip(t *testing.T) {
+	w := NewBitWriter()
+
+	w.WriteBit(true)
+	w.WriteBits(0b101, 3)
+	w.WriteBits(0xdeadbeefcafebabe, 64)
+	w.WriteBit(false)
+	w.WriteBits(0x1f, 5)
+
+	require.Equal(t, 1+3+64+1+5, w.Len())
+
+	r := NewBitReader(w.Bytes())
+
+	bit, err := r.ReadBit()
+	require.NoError(t, err)
+	require.True(t, bit)
+
+	u, err := r.ReadBits(3)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0b101), u)
+
+	u, err = r.ReadBits(64)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0xdeadbeefcafebabe), u)
+
+	bit, err = r.ReadBit()
+	require.NoError(t, err)
+	require.False(t, bit)
+
+	u, err = r.ReadBits(5)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0x1f), u)
+
+	// only the padding of the last byte is left
+	_, err = r.ReadBits(8)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,164 @@
+package gorilla
+
+// dodBucket is one of the variable width encodings of a delta-of-delta from
+// section 4.1.1 of the Gorilla paper. The paper ends with a 32 bit bucket for
+// second precision timestamps; ftsdb stores milliseconds, so the last bucket
+// holds the full 64 bits instead.
+type dodBucket struct {
+	control     uint64
+	controlBits int
+	valueBits   int
+}
+
+var dodBuckets = []dodBucket{
+	{control: 0b10, controlBits: 2, valueBits: 7},
+	{control: 0b110, controlBits: 3, valueBits: 9},
+	{control: 0b1110, controlBits: 4, valueBits: 12},
+	{control: 0b1111, controlBits: 4, valueBits: 64},
+}
+
+// fits reports whether dod lies in [-(2^(nbits-1)-1), 2^(nbits-1)], the range
+// used by the paper for an nbits wide bucket.
+func fits(dod int64, nbits int) bool {
+	if nbits == 64 {
+		return true
+	}
+	return -((int64(1)<<(nbits-1))-1) <= dod && dod <= int64(1)<<(nbits-1)
+}
+
+// TimestampEncoder compresses increasing timestamps by storing the difference
+// between consecutive deltas. Regular intervals cost a single bit per timestamp.
+type TimestampEncoder struct {
+	w         *BitWriter
+	prev      int64
+	prevDelta int64
+	count     int
+}
+
+func NewTimestampEncoder() *TimestampEncoder {
+	return &TimestampEncoder{
+		w: NewBitWriter(),
+	}
+}
+
+func (e *TimestampEncoder) Encode(timestamp int64) {
+	if e.count == 0 {
+		e.w.WriteBits(uint64(timestamp), 64)
+		e.prev = timestamp
+		e.count++
+		return
+	}
+
+	delta := timestamp - e.prev
+	dod := delta - e.prevDelta
+
+	e.prev = timestamp
+	e.prevDelta = delta
+	e.count++
+
+	if dod == 0 {
+		e.w.WriteBit(false)
+		return
+	}
+
+	for _, bucket := range dodBuckets {
+		if fits(dod, bucket.valueBits) {
+			e.w.WriteBits(bucket.control, bucket.controlBits)
+			e.w.WriteBits(uint64(dod), bucket.valueBits)
+			return
+		}
+	}
+}
+
+func (e *TimestampEncoder) Bytes() []byte {
+	return e.w.Bytes()
+}
+
+func (e *TimestampEncoder) Count() int {
+	return e.count
+}
+
+// TimestampDecoder reads timestamps written by TimestampEncoder. Like the
+// value stream it carries no length, callers must know how many to read.
+type TimestampDecoder struct {
+	r         *BitReader
+	prev      int64
+	prevDelta int64
+	read      int
+}
+
+func NewTimestampDecoder(b []byte) *TimestampDecoder {
+	return &TimestampDecoder{
+		r: NewBitReader(b),
+	}
+}
+
+func (d *TimestampDecoder) Decode() (int64, error) {
+	if d.read == 0 {
+		u, err := d.r.ReadBits(64)
+		if err != nil {
+			return 0, err
+		}
+		d.prev = int64(u)
+		d.read++
+		return d.prev, nil
+	}
+
+	var dod int64
+
+	// count the leading ones of the control prefix, up to four
+	ones := 0
+	for ones < 4 {
+		bit, err := d.r.ReadBit()
+		if err != nil {
+			return 0, err
+		}
+		if !bit {
+			break
+		}
+		ones++
+	}
+
+	if ones > 0 {
+		bucket := dodBuckets[ones-1]
+
+		u, err := d.r.ReadBits(bucket.valueBits)
+		if err != nil {
+			return 0, err
+		}
+
+		dod = int64(u)
+		if bucket.valueBits < 64 && u > uint64(1)<<(bucket.valueBits-1) {
+			dod -= int64(1) << bucket.valueBits
+		}
+	}
+
+	d.prevDelta += dod
+	d.prev += d.prevDelta
+	d.read++
+
+	return d.prev, nil
+}
+
+func EncodeTimestamps(timestamps []int64) []byte {
+	e := NewTimestampEncoder()
+	for _, ts := range timestamps {
+		e.Encode(ts)
+	}
+	return e.Bytes()
+}
+
+func DecodeTimestamps(b []byte, n int) ([]int64, error) {
+	d := NewTimestampDecoder(b)
+	timestamps := make([]int64, n)
+
+	for i := range timestamps {
+		ts, err := d.Decode()
+		if err != nil {
+			return nil, err
+		}
+		timestamps[i] = ts
+	}
+
+	return timestamps, nil
+}
@@ -0,0 +1,54 @@
+package gorilla
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimestampRoundTrip(t *testing.T) {
+	cases := map[string][]int64{
+		"single":  {1709337711578},
+		"regular": {1000, 1050, 1100, 1150, 1200},
+		"bucket edges": {
+			0, 0, 64, 64, 1, 1, 257, 257, 0, 2048, 4096, 4097, 0,
+		},
+		"wide gaps": {0, 1 << 40, 1<<40 + 1, -(1 << 50)},
+		"extremes":  {math.MinInt64, math.MaxInt64, 0, math.MinInt64},
+	}
+
+	random := []int64{1709337711578}
+	for i := 0; i < 10000; i++ {
+		random = append(random, random[len(random)-1]+int64(rand.Intn(100000)))
+	}
+	cases["random"] = random
+
+	for name, timestamps := range cases {
+		t.Run(name, func(t *testing.T) {
+			decoded, err := DecodeTimestamps(EncodeTimestamps(timestamps), len(timestamps))
+			require.NoError(t, err)
+			require.Equal(t, timestamps, decoded)
+		})
+	}
+}
+
+func TestTimestampRegularIntervalSize(t *testing.T) {
+	timestamps := make([]int64, 10000)
+	for i := range timestamps {
+		timestamps[i] = 1709337711578 + int64(i)*50
+	}
+
+	// 64 bits for the first timestamp, 2+7 bits for the first delta of 50ms
+	// and one bit for every following timestamp
+	bits := 64 + 9 + len(timestamps) - 2
+	require.Equal(t, (bits+7)/8, len(EncodeTimestamps(timestamps)))
+}
+
+func TestTimestampTruncatedStream(t *testing.T) {
+	encoded := EncodeTimestamps([]int64{0, 1 << 40, 1 << 41})
+
+	_, err := DecodeTimestamps(encoded[:10], 3)
+	require.Error(t, err)
+}
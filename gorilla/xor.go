@@ -0,0 +1,171 @@
+package gorilla
+
+import (
+	"math"
+	"math/bits"
+)
+
+// XOREncoder compresses a sequence of float64 values as described in section
+// 4.1.2 of the Gorilla paper: every value is XORed with its predecessor and
+// only the meaningful bits of the result are stored.
+type XOREncoder struct {
+	w        *BitWriter
+	prev     uint64
+	leading  uint8
+	trailing uint8
+	count    int
+}
+
+func NewXOREncoder() *XOREncoder {
+	return &XOREncoder{
+		w: NewBitWriter(),
+		// 0xff marks that there is no previous meaningful-bits window to reuse
+		leading: 0xff,
+	}
+}
+
+func (e *XOREncoder) Encode(value float64) {
+	v := math.Float64bits(value)
+
+	if e.count == 0 {
+		e.w.WriteBits(v, 64)
+		e.prev = v
+		e.count++
+		return
+	}
+
+	xor := v ^ e.prev
+	e.prev = v
+	e.count++
+
+	if xor == 0 {
+		e.w.WriteBit(false)
+		return
+	}
+	e.w.WriteBit(true)
+
+	leading := uint8(bits.LeadingZeros64(xor))
+	trailing := uint8(bits.TrailingZeros64(xor))
+
+	// leading zeros are stored in 5 bits
+	if leading >= 32 {
+		leading = 31
+	}
+
+	if e.leading != 0xff && leading >= e.leading && trailing >= e.trailing {
+		e.w.WriteBit(false)
+		e.w.WriteBits(xor>>e.trailing, 64-int(e.leading)-int(e.trailing))
+		return
+	}
+
+	e.leading, e.trailing = leading, trailing
+
+	e.w.WriteBit(true)
+	e.w.WriteBits(uint64(leading), 5)
+
+	// 64 meaningful bits do not fit in 6 bits, they are written as 0 and
+	// there is no way for an xor != 0 to have 0 meaningful bits
+	sigbits := 64 - leading - trailing
+	e.w.WriteBits(uint64(sigbits), 6)
+	e.w.WriteBits(xor>>trailing, int(sigbits))
+}
+
+func (e *XOREncoder) Bytes() []byte {
+	return e.w.Bytes()
+}
+
+func (e *XOREncoder) Count() int {
+	return e.count
+}
+
+// XORDecoder reads values written by XOREncoder. The stream carries no length,
+// so callers have to know how many values were encoded.
+type XORDecoder struct {
+	r        *BitReader
+	value    uint64
+	leading  uint8
+	trailing uint8
+	read     int
+}
+
+func NewXORDecoder(b []byte) *XORDecoder {
+	return &XORDecoder{
+		r: NewBitReader(b),
+	}
+}
+
+func (d *XORDecoder) Decode() (float64, error) {
+	if d.read == 0 {
+		v, err := d.r.ReadBits(64)
+		if err != nil {
+			return 0, err
+		}
+		d.value = v
+		d.read++
+		return math.Float64frombits(v), nil
+	}
+
+	d.read++
+
+	changed, err := d.r.ReadBit()
+	if err != nil {
+		return 0, err
+	}
+	if !changed {
+		return math.Float64frombits(d.value), nil
+	}
+
+	newWindow, err := d.r.ReadBit()
+	if err != nil {
+		return 0, err
+	}
+
+	if newWindow {
+		leading, err := d.r.ReadBits(5)
+		if err != nil {
+			return 0, err
+		}
+		sigbits, err := d.r.ReadBits(6)
+		if err != nil {
+			return 0, err
+		}
+		if sigbits == 0 {
+			sigbits = 64
+		}
+		d.leading = uint8(leading)
+		d.trailing = 64 - uint8(leading) - uint8(sigbits)
+	}
+
+	sigbits := 64 - int(d.leading) - int(d.trailing)
+	xor, err := d.r.ReadBits(sigbits)
+	if err != nil {
+		return 0, err
+	}
+
+	d.value ^= xor << d.trailing
+
+	return math.Float64frombits(d.value), nil
+}
+
+func EncodeXOR(values []float64) []byte {
+	e := NewXOREncoder()
+	for _, v := range values {
+		e.Encode(v)
+	}
+	return e.Bytes()
+}
+
+func DecodeXOR(b []byte, n int) ([]float64, error) {
+	d := NewXORDecoder(b)
+	values := make([]float64, n)
+
+	for i := range values {
+		v, err := d.Decode()
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+
+	return values, nil
+}
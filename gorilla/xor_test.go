@@ -0,0 +1,51 @@
+package gorilla
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestXORRoundTrip(t *testing.T) {
+	values := []float64{
+		37.25, 37.25, 37.5, 12, 0, -0.001, 1e300, -1e-300,
+		math.Inf(1), math.Inf(-1), math.MaxFloat64, math.SmallestNonzeroFloat64,
+	}
+
+	for i := 0; i < 1000; i++ {
+		values = append(values, rand.Float64()*100)
+	}
+
+	encoded := EncodeXOR(values)
+
+	decoded, err := DecodeXOR(encoded, len(values))
+	require.NoError(t, err)
+	require.Equal(t, values, decoded)
+}
+
+func TestXORNaN(t *testing.T) {
+	nan := math.Float64frombits(0x7ff8000000000001)
+
+	decoded, err := DecodeXOR(EncodeXOR([]float64{1, nan, 2}), 3)
+	require.NoError(t, err)
+	require.Equal(t, math.Float64bits(nan), math.Float64bits(decoded[1]))
+}
+
+func TestXORCompressesRepeatedValues(t *testing.T) {
+	values := make([]float64, 1000)
+	for i := range values {
+		values[i] = 42.5
+	}
+
+	// first value takes 64 bits, every repeat a single bit
+	require.Equal(t, (64+999+7)/8, len(EncodeXOR(values)))
+}
+
+func TestXORTruncatedStream(t *testing.T) {
+	encoded := EncodeXOR([]float64{1, 2, 3})
+
+	_, err := DecodeXOR(encoded[:4], 3)
+	require.Error(t, err)
+}
@@ -0,0 +1,60 @@
+package graphite
+
+import (
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Marvin9/ftsdb/ftsdb"
+	"github.com/Marvin9/ftsdb/shared"
+	"go.uber.org/zap"
+)
+
+// Listener appends the lines sent to it over TCP to a database. The protocol
+// has no replies, so lines that can not be parsed or appended are logged and
+// dropped.
+type Listener struct {
+	db     ftsdb.DBInterface
+	parser *Parser
+	logger *zap.Logger
+	server *shared.LineServer
+}
+
+func NewListener(logger *zap.Logger, db ftsdb.DBInterface, templates []*Template) *Listener {
+	listener := &Listener{
+		db:     db,
+		parser: NewParser(templates),
+		logger: logger,
+	}
+	listener.server = shared.NewLineServer(logger, listener.handle)
+
+	return listener
+}
+
+// Serve accepts connections on l until Close is called.
+func (l *Listener) Serve(listener net.Listener) error {
+	return l.server.Serve(listener)
+}
+
+// Close stops accepting connections and waits until the lines already
+// received are appended.
+func (l *Listener) Close() error {
+	return l.server.Close()
+}
+
+func (l *Listener) handle(_ io.Writer, line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+
+	point, err := l.parser.Parse(line, time.Now())
+	if err != nil {
+		l.logger.Warn("dropping graphite line", zap.String("line", line), zap.Error(err))
+		return
+	}
+
+	if err := l.db.CreateMetric(point.Metric).Append(point.Labels, point.Timestamp, point.Value); err != nil {
+		l.logger.Warn("appending graphite line", zap.String("line", line), zap.Error(err))
+	}
+}
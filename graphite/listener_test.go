@@ -0,0 +1,56 @@
+package graphite
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Marvin9/ftsdb/ftsdb"
+	"github.com/Marvin9/ftsdb/ftsdb/ftsdbtest"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestListener(t *testing.T) {
+	db := ftsdbtest.NewDB(t)
+
+	template, err := ParseTemplate("host.*.cpu.* .host.metric.metric")
+	require.NoError(t, err)
+
+	listener := NewListener(zap.NewNop(), db, []*Template{template})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- listener.Serve(l)
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte(
+		"host.web-1.cpu.idle 90 1000\n" +
+			"host.web-1.cpu.idle oops 1010\n" +
+			"\n" +
+			"host.web-1.cpu.idle 80 1010\r\n" +
+			"host.web-2.cpu.idle 70 1000\n",
+	))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	expected := map[string][]ftsdb.Datapoint{
+		`{host="web-1"}`: {{Timestamp: 1000000, Value: 90}, {Timestamp: 1010000, Value: 80}},
+		`{host="web-2"}`: {{Timestamp: 1000000, Value: 70}},
+	}
+	require.Eventually(t, func() bool {
+		return reflect.DeepEqual(expected, ftsdbtest.Collect(t, db, "cpu_idle"))
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, listener.Close())
+	require.NoError(t, <-served)
+
+	_, err = net.Dial("tcp", l.Addr().String())
+	require.Error(t, err)
+}
@@ -0,0 +1,95 @@
+package graphite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/util/strutil"
+)
+
+// Point is a parsed line.
+type Point struct {
+	Metric string
+	Labels map[string]string
+	// Timestamp is in milliseconds
+	Timestamp int64
+	Value     float64
+}
+
+// Parser parses lines, mapping their paths with the first template whose
+// filter matches. Paths no template matches become the metric with the dots
+// replaced by underscores and no labels.
+type Parser struct {
+	templates []*Template
+}
+
+func NewParser(templates []*Template) *Parser {
+	return &Parser{templates: templates}
+}
+
+// Parse parses a line. Lines without a timestamp, or with -1, get now.
+// Timestamps are in seconds and may have a fraction. Tags of the path, as in
+// "cpu.idle;host=web-1", are added to the labels.
+func (p *Parser) Parse(line string, now time.Time) (Point, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return Point{}, errors.New("expected <path> <value> [<timestamp>]")
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return Point{}, fmt.Errorf("invalid value %q", fields[1])
+	}
+
+	timestamp := now.UnixMilli()
+	if len(fields) == 3 && fields[2] != "-1" {
+		seconds, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+			return Point{}, fmt.Errorf("invalid timestamp %q", fields[2])
+		}
+		timestamp = int64(math.Round(seconds * 1000))
+	}
+
+	path, tags, _ := strings.Cut(fields[0], ";")
+	if path == "" {
+		return Point{}, errors.New("empty path")
+	}
+
+	metric, labels, err := p.mapPath(path)
+	if err != nil {
+		return Point{}, fmt.Errorf("path %q: %w", path, err)
+	}
+
+	if tags != "" {
+		for _, tag := range strings.Split(tags, ";") {
+			name, value, found := strings.Cut(tag, "=")
+			if !found || name == "" || value == "" {
+				return Point{}, fmt.Errorf("invalid tag %q", tag)
+			}
+			labels[strutil.SanitizeFullLabelName(name)] = value
+		}
+	}
+
+	return Point{
+		Metric:    metric,
+		Labels:    labels,
+		Timestamp: timestamp,
+		Value:     value,
+	}, nil
+}
+
+func (p *Parser) mapPath(path string) (string, map[string]string, error) {
+	nodes := strings.Split(path, ".")
+
+	for _, template := range p.templates {
+		if template.matches(nodes) {
+			return template.apply(nodes)
+		}
+	}
+
+	return strutil.SanitizeFullLabelName(strings.Join(nodes, "_")), map[string]string{}, nil
+}
@@ -0,0 +1,154 @@
+// Package graphite receives the Graphite plaintext protocol
+//
+//	<path> <value> [<timestamp>]
+//
+// and turns paths into metrics and labels with templates.
+package graphite
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/prometheus/prometheus/util/strutil"
+)
+
+// Template maps the nodes of Graphite paths to a metric and labels. It is
+// written as
+//
+//	[filter] template [label=value,...]
+//
+// The filter selects the paths the template applies to by their first
+// nodes, each filter node a glob like servers.*.cpu. Without one the template
+// applies to every path.
+//
+// Each node of the template names what the node of the path at the same
+// position becomes: "metric" nodes are joined with underscores into the
+// metric, "metric*" takes the rest of the path into the metric, any other
+// name makes the node a label of that name, and empty nodes are dropped. The
+// labels after the template are added to every series it maps. For example
+// the template
+//
+//	host.*.cpu.* .host.metric.metric
+//
+// maps host.web-1.cpu.idle to the metric cpu_idle with the label
+// host="web-1".
+type Template struct {
+	filter []string
+	nodes  []string
+	labels map[string]string
+}
+
+func ParseTemplate(s string) (*Template, error) {
+	fields := strings.Fields(s)
+
+	t := &Template{labels: map[string]string{}}
+
+	var template, labels string
+	switch len(fields) {
+	case 1:
+		template = fields[0]
+	case 2:
+		if strings.Contains(fields[1], "=") {
+			template, labels = fields[0], fields[1]
+		} else {
+			t.filter = strings.Split(fields[0], ".")
+			template = fields[1]
+		}
+	case 3:
+		t.filter = strings.Split(fields[0], ".")
+		template, labels = fields[1], fields[2]
+	default:
+		return nil, fmt.Errorf("invalid template %q", s)
+	}
+
+	for _, node := range t.filter {
+		if _, err := path.Match(node, ""); err != nil {
+			return nil, fmt.Errorf("invalid filter of template %q: %w", s, err)
+		}
+	}
+
+	t.nodes = strings.Split(template, ".")
+
+	hasMetric := false
+	for idx, node := range t.nodes {
+		switch node {
+		case "metric":
+			hasMetric = true
+		case "metric*":
+			if idx != len(t.nodes)-1 {
+				return nil, fmt.Errorf("invalid template %q: metric* has to be the last node", s)
+			}
+			hasMetric = true
+		}
+	}
+	if !hasMetric {
+		return nil, fmt.Errorf("invalid template %q: no metric node", s)
+	}
+
+	if labels != "" {
+		for _, label := range strings.Split(labels, ",") {
+			name, value, found := strings.Cut(label, "=")
+			if !found || name == "" || value == "" {
+				return nil, fmt.Errorf("invalid label %q of template %q", label, s)
+			}
+			t.labels[strutil.SanitizeFullLabelName(name)] = value
+		}
+	}
+
+	return t, nil
+}
+
+// matches reports whether the filter of t selects nodes.
+func (t *Template) matches(nodes []string) bool {
+	if len(nodes) < len(t.filter) {
+		return false
+	}
+	for idx, pattern := range t.filter {
+		if matched, _ := path.Match(pattern, nodes[idx]); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// apply maps nodes to a metric and labels. Several nodes mapped to the same
+// label are joined with dots.
+func (t *Template) apply(nodes []string) (string, map[string]string, error) {
+	metric := []string{}
+	labels := map[string]string{}
+	fromNodes := map[string]bool{}
+
+	for name, value := range t.labels {
+		labels[name] = value
+	}
+
+	for idx, node := range t.nodes {
+		if idx >= len(nodes) {
+			break
+		}
+
+		switch node {
+		case "":
+		case "metric":
+			metric = append(metric, nodes[idx])
+		case "metric*":
+			metric = append(metric, nodes[idx:]...)
+		default:
+			name := strutil.SanitizeFullLabelName(node)
+			if fromNodes[name] {
+				labels[name] += "." + nodes[idx]
+			} else {
+				labels[name] = nodes[idx]
+				fromNodes[name] = true
+			}
+		}
+	}
+
+	if len(metric) == 0 {
+		return "", nil, errors.New("template maps no node to the metric")
+	}
+
+	return strutil.SanitizeFullLabelName(strings.Join(metric, "_")), labels, nil
+}
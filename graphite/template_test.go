@@ -0,0 +1,139 @@
+package graphite
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	now := time.UnixMilli(1700000000123)
+
+	templates := []*Template{}
+	for _, s := range []string{
+		"host.*.cpu.* .host.metric.metric",
+		"servers.* .host.metric* region=eu",
+		"app.*.*.requests .service.instance.metric",
+		"dc.*.*.* .dc.dc.metric",
+	} {
+		template, err := ParseTemplate(s)
+		require.NoError(t, err)
+		templates = append(templates, template)
+	}
+	parser := NewParser(templates)
+
+	for _, tc := range []struct {
+		name  string
+		line  string
+		point Point
+	}{
+		{
+			name: "template",
+			line: "host.web-1.cpu.idle 90.5 1700000000",
+			point: Point{
+				Metric:    "cpu_idle",
+				Labels:    map[string]string{"host": "web-1"},
+				Timestamp: 1700000000000,
+				Value:     90.5,
+			},
+		},
+		{
+			name: "rest of the path and extra labels",
+			line: "servers.db-1.disk.sda.reads 7 1700000000.25",
+			point: Point{
+				Metric:    "disk_sda_reads",
+				Labels:    map[string]string{"host": "db-1", "region": "eu"},
+				Timestamp: 1700000000250,
+				Value:     7,
+			},
+		},
+		{
+			name: "several labels",
+			line: "app.api.i-3.requests 12 -1",
+			point: Point{
+				Metric:    "requests",
+				Labels:    map[string]string{"service": "api", "instance": "i-3"},
+				Timestamp: 1700000000123,
+				Value:     12,
+			},
+		},
+		{
+			name: "nodes joined into a label",
+			line: "dc.eu.west.load 1",
+			point: Point{
+				Metric:    "load",
+				Labels:    map[string]string{"dc": "eu.west"},
+				Timestamp: 1700000000123,
+				Value:     1,
+			},
+		},
+		{
+			name: "no template",
+			line: "stats.gauges.queue-depth 3 1000",
+			point: Point{
+				Metric:    "stats_gauges_queue_depth",
+				Labels:    map[string]string{},
+				Timestamp: 1000000,
+				Value:     3,
+			},
+		},
+		{
+			name: "tags",
+			line: "host.web-2.cpu.user;region=us;cpu=0 4 1000",
+			point: Point{
+				Metric:    "cpu_user",
+				Labels:    map[string]string{"host": "web-2", "region": "us", "cpu": "0"},
+				Timestamp: 1000000,
+				Value:     4,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			point, err := parser.Parse(tc.line, now)
+			require.NoError(t, err)
+			require.Equal(t, tc.point, point)
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	parser := NewParser(nil)
+
+	for _, tc := range []struct {
+		line string
+		err  string
+	}{
+		{"cpu.idle", "expected <path> <value> [<timestamp>]"},
+		{"cpu.idle 1 2 3", "expected <path> <value> [<timestamp>]"},
+		{"cpu.idle oops", `invalid value "oops"`},
+		{"cpu.idle 1 yesterday", `invalid timestamp "yesterday"`},
+		{"cpu.idle;host 1", `invalid tag "host"`},
+	} {
+		_, err := parser.Parse(tc.line, time.Now())
+		require.EqualError(t, err, tc.err, tc.line)
+	}
+}
+
+func TestParseTemplate(t *testing.T) {
+	for _, tc := range []struct {
+		template string
+		err      string
+	}{
+		{"host.metric", ""},
+		{"host.metric region=eu,env=prod", ""},
+		{"servers.* host.metric* region=eu", ""},
+		{"host.region", `invalid template "host.region": no metric node`},
+		{"metric*.host", `invalid template "metric*.host": metric* has to be the last node`},
+		{"host.metric region=", `invalid label "region=" of template "host.metric region="`},
+		{"[.* host.metric", `invalid filter of template "[.* host.metric": syntax error in pattern`},
+		{"a b c d", `invalid template "a b c d"`},
+	} {
+		_, err := ParseTemplate(tc.template)
+		if tc.err == "" {
+			require.NoError(t, err, tc.template)
+		} else {
+			require.EqualError(t, err, tc.err, tc.template)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+// Package influx parses the InfluxDB line protocol.
+//
+//	measurement[,tag=value...] field=value[,field=value...] [timestamp]
+//
+// Commas and spaces in the measurement, and commas, equal signs and spaces in
+// tag keys, tag values and field keys are escaped with a backslash. String
+// field values are double quoted, with double quotes and backslashes in them
+// escaped.
+package influx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Precision is the unit of the timestamps of a batch of lines.
+type Precision time.Duration
+
+const (
+	Nanosecond  = Precision(time.Nanosecond)
+	Microsecond = Precision(time.Microsecond)
+	Millisecond = Precision(time.Millisecond)
+	Second      = Precision(time.Second)
+	Minute      = Precision(time.Minute)
+	Hour        = Precision(time.Hour)
+)
+
+// ParsePrecision parses the precision parameter of the InfluxDB write APIs.
+// The empty string is nanoseconds.
+func ParsePrecision(s string) (Precision, error) {
+	switch s {
+	case "", "n", "ns":
+		return Nanosecond, nil
+	case "u", "us", "µ", "µs":
+		return Microsecond, nil
+	case "ms":
+		return Millisecond, nil
+	case "s":
+		return Second, nil
+	case "m":
+		return Minute, nil
+	case "h":
+		return Hour, nil
+	}
+	return 0, fmt.Errorf("invalid precision %q", s)
+}
+
+// toMillis converts a timestamp of precision p to milliseconds, rounding
+// down.
+func (p Precision) toMillis(ts int64) (int64, error) {
+	if p >= Millisecond {
+		factor := int64(p) / int64(Millisecond)
+		if ts > math.MaxInt64/factor || ts < math.MinInt64/factor {
+			return 0, errors.New("timestamp out of range")
+		}
+		return ts * factor, nil
+	}
+
+	divisor := int64(Millisecond) / int64(p)
+	ms := ts / divisor
+	if ts%divisor < 0 {
+		ms--
+	}
+	return ms, nil
+}
+
+// Point is a parsed line. Integer, unsigned and boolean fields are converted
+// to floats, true being 1. String fields can not be stored as datapoints and
+// are left out, a line with nothing but string fields fails to parse.
+type Point struct {
+	// Line is the line of the batch the point was parsed from, counting
+	// from 1
+	Line        int
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]float64
+	// Timestamp is in milliseconds
+	Timestamp int64
+}
+
+// LineError reports a line that could not be parsed. Line counts from 1.
+type LineError struct {
+	Line int
+	Text string
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("unable to parse '%s': %s", e.Text, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// Parse parses a batch of lines with timestamps of the given precision.
+// Lines without a timestamp get now. Empty lines and comments, lines
+// starting with #, are skipped. Lines that fail to parse are returned as
+// errors, the others as points.
+func Parse(data []byte, precision Precision, now time.Time) ([]Point, []*LineError) {
+	points := []Point{}
+	lineErrors := []*LineError{}
+
+	for idx, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" || trimmed[0] == '#' {
+			continue
+		}
+
+		point, err := parseLine(trimmed, precision, now)
+		if err != nil {
+			lineErrors = append(lineErrors, &LineError{Line: idx + 1, Text: line, Err: err})
+			continue
+		}
+		point.Line = idx + 1
+		points = append(points, point)
+	}
+
+	return points, lineErrors
+}
+
+func parseLine(line string, precision Precision, now time.Time) (Point, error) {
+	p := &lineParser{line: line}
+
+	point := Point{
+		Tags:   map[string]string{},
+		Fields: map[string]float64{},
+	}
+
+	measurement, end := p.token(", ")
+	if measurement == "" {
+		return Point{}, errors.New("missing measurement")
+	}
+	point.Measurement = measurement
+
+	for end == ',' {
+		var key, value string
+
+		key, end = p.token("=, ")
+		if end != '=' || key == "" {
+			return Point{}, errors.New("missing tag key")
+		}
+		value, end = p.token("=, ")
+		if value == "" || end == '=' {
+			return Point{}, fmt.Errorf("invalid value of tag %s", key)
+		}
+		point.Tags[key] = value
+	}
+
+	if end != ' ' {
+		return Point{}, errors.New("missing fields")
+	}
+	p.skipSpaces()
+
+	for {
+		key, sep := p.token("=, ")
+		if sep != '=' || key == "" {
+			return Point{}, errors.New("missing field key")
+		}
+
+		if p.peek() == '"' {
+			if _, err := p.quoted(); err != nil {
+				return Point{}, fmt.Errorf("invalid value of field %s: %w", key, err)
+			}
+			if end = p.next(); end != ',' && end != ' ' && end != 0 {
+				return Point{}, fmt.Errorf("invalid value of field %s", key)
+			}
+		} else {
+			var raw string
+			raw, end = p.token(", ")
+			value, err := parseFieldValue(raw)
+			if err != nil {
+				return Point{}, fmt.Errorf("invalid value of field %s: %w", key, err)
+			}
+			point.Fields[key] = value
+		}
+
+		if end != ',' {
+			break
+		}
+	}
+
+	if len(point.Fields) == 0 {
+		return Point{}, errors.New("no numeric fields")
+	}
+
+	p.skipSpaces()
+
+	raw := strings.TrimRight(p.rest(), " \t")
+	if raw == "" {
+		point.Timestamp = now.UnixMilli()
+		return point, nil
+	}
+
+	ts, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return Point{}, fmt.Errorf("invalid timestamp %q", raw)
+	}
+	point.Timestamp, err = precision.toMillis(ts)
+	if err != nil {
+		return Point{}, err
+	}
+
+	return point, nil
+}
+
+func parseFieldValue(raw string) (float64, error) {
+	if raw == "" {
+		return 0, errors.New("missing value")
+	}
+
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, nil
+	}
+
+	switch raw[len(raw)-1] {
+	case 'i':
+		v, err := strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid integer %q", raw)
+		}
+		return float64(v), nil
+	case 'u':
+		v, err := strconv.ParseUint(raw[:len(raw)-1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid unsigned integer %q", raw)
+		}
+		return float64(v), nil
+	}
+
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) || strings.ContainsAny(raw, "_xX") {
+		return 0, fmt.Errorf("invalid number %q", raw)
+	}
+	return v, nil
+}
+
+// lineParser walks a line, unescaping as it goes.
+type lineParser struct {
+	line string
+	pos  int
+}
+
+// token reads up to the first unescaped byte in stops, which it consumes
+// and returns, or to the end of the line, returning 0. A backslash escapes
+// the byte after it if that is one of stops, otherwise it is kept.
+func (p *lineParser) token(stops string) (string, byte) {
+	var b strings.Builder
+
+	for p.pos < len(p.line) {
+		c := p.line[p.pos]
+
+		if c == '\\' && p.pos+1 < len(p.line) {
+			next := p.line[p.pos+1]
+			if strings.IndexByte(stops, next) >= 0 {
+				b.WriteByte(next)
+				p.pos += 2
+				continue
+			}
+		}
+
+		p.pos++
+		if strings.IndexByte(stops, c) >= 0 {
+			return b.String(), c
+		}
+		b.WriteByte(c)
+	}
+
+	return b.String(), 0
+}
+
+// quoted reads a double quoted string.
+func (p *lineParser) quoted() (string, error) {
+	var b strings.Builder
+
+	p.pos++
+	for p.pos < len(p.line) {
+		c := p.line[p.pos]
+		p.pos++
+
+		switch {
+		case c == '\\' && p.pos < len(p.line) && (p.line[p.pos] == '"' || p.line[p.pos] == '\\'):
+			b.WriteByte(p.line[p.pos])
+			p.pos++
+		case c == '"':
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return "", errors.New("unterminated string")
+}
+
+func (p *lineParser) peek() byte {
+	if p.pos < len(p.line) {
+		return p.line[p.pos]
+	}
+	return 0
+}
+
+func (p *lineParser) next() byte {
+	c := p.peek()
+	if c != 0 {
+		p.pos++
+	}
+	return c
+}
+
+func (p *lineParser) skipSpaces() {
+	for p.pos < len(p.line) && p.line[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *lineParser) rest() string {
+	return p.line[p.pos:]
+}
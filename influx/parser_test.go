@@ -0,0 +1,187 @@
+package influx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	now := time.UnixMilli(1700000000123)
+
+	for _, tc := range []struct {
+		name  string
+		line  string
+		point Point
+	}{
+		{
+			name: "tags and fields",
+			line: "cpu,host=web-1,region=eu usage_idle=90.5,usage_user=4 1700000000000000000",
+			point: Point{
+				Measurement: "cpu",
+				Tags:        map[string]string{"host": "web-1", "region": "eu"},
+				Fields:      map[string]float64{"usage_idle": 90.5, "usage_user": 4},
+				Timestamp:   1700000000000,
+			},
+		},
+		{
+			name: "field types",
+			line: `m i=-3i,u=7u,t=t,T=TRUE,f=false,e=1e3,s="skipped" 0`,
+			point: Point{
+				Measurement: "m",
+				Tags:        map[string]string{},
+				Fields:      map[string]float64{"i": -3, "u": 7, "t": 1, "T": 1, "f": 0, "e": 1000},
+				Timestamp:   0,
+			},
+		},
+		{
+			name: "no timestamp",
+			line: "m v=1",
+			point: Point{
+				Measurement: "m",
+				Tags:        map[string]string{},
+				Fields:      map[string]float64{"v": 1},
+				Timestamp:   1700000000123,
+			},
+		},
+		{
+			name: "escaped measurement",
+			line: `disk\ io\,total,path=/ v=1 5000000`,
+			point: Point{
+				Measurement: "disk io,total",
+				Tags:        map[string]string{"path": "/"},
+				Fields:      map[string]float64{"v": 1},
+				Timestamp:   5,
+			},
+		},
+		{
+			name: "escaped tags and fields",
+			line: `m,tag\ key\=1=a\ b\,c\=d field\,key\ 2=1 5000000`,
+			point: Point{
+				Measurement: "m",
+				Tags:        map[string]string{"tag key=1": "a b,c=d"},
+				Fields:      map[string]float64{"field,key 2": 1},
+				Timestamp:   5,
+			},
+		},
+		{
+			name: "backslash kept where it escapes nothing",
+			line: `m,path=C:\dir v=1 0`,
+			point: Point{
+				Measurement: "m",
+				Tags:        map[string]string{"path": `C:\dir`},
+				Fields:      map[string]float64{"v": 1},
+			},
+		},
+		{
+			name: "strings with separators",
+			line: `m msg="a, b=c \"quoted\" \\",v=2 0`,
+			point: Point{
+				Measurement: "m",
+				Tags:        map[string]string{},
+				Fields:      map[string]float64{"v": 2},
+			},
+		},
+		{
+			name: "equal sign in measurement",
+			line: `a=b v=1 0`,
+			point: Point{
+				Measurement: "a=b",
+				Tags:        map[string]string{},
+				Fields:      map[string]float64{"v": 1},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			points, errs := Parse([]byte(tc.line), Nanosecond, now)
+			require.Empty(t, errs)
+			require.Len(t, points, 1)
+
+			tc.point.Line = 1
+			require.Equal(t, tc.point, points[0])
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		err  string
+	}{
+		{"cpu", "missing fields"},
+		{"cpu,host=a", "missing fields"},
+		{",host=a v=1", "missing measurement"},
+		{"cpu,=a v=1", "missing tag key"},
+		{"cpu,host v=1", "missing tag key"},
+		{"cpu,host= v=1", "invalid value of tag host"},
+		{"cpu,host=a=b v=1", "invalid value of tag host"},
+		{"cpu v", "missing field key"},
+		{"cpu =1", "missing field key"},
+		{"cpu v=", `invalid value of field v: missing value`},
+		{"cpu v=abc", `invalid value of field v: invalid number "abc"`},
+		{"cpu v=NaN", `invalid value of field v: invalid number "NaN"`},
+		{"cpu v=1.5i", `invalid value of field v: invalid integer "1.5i"`},
+		{"cpu v=-1u", `invalid value of field v: invalid unsigned integer "-1u"`},
+		{`cpu v="open`, "invalid value of field v: unterminated string"},
+		{`cpu v="a"b`, "invalid value of field v"},
+		{`cpu msg="a",state="up" 1`, "no numeric fields"},
+		{"cpu v=1 abc", `invalid timestamp "abc"`},
+		{"cpu v=1 1 2", `invalid timestamp "1 2"`},
+	} {
+		points, errs := Parse([]byte(tc.line), Nanosecond, time.Now())
+		require.Empty(t, points, tc.line)
+		require.Len(t, errs, 1, tc.line)
+		require.EqualError(t, errs[0], "unable to parse '"+tc.line+"': "+tc.err)
+	}
+}
+
+func TestParseBatch(t *testing.T) {
+	data := "# a comment\n" +
+		"cpu v=1 1000\r\n" +
+		"\n" +
+		"cpu v=oops 2000\n" +
+		"   cpu v=3 3000\n"
+
+	points, errs := Parse([]byte(data), Second, time.Now())
+
+	require.Len(t, points, 2)
+	require.Equal(t, 2, points[0].Line)
+	require.Equal(t, int64(1000000), points[0].Timestamp)
+	require.Equal(t, 5, points[1].Line)
+	require.Equal(t, int64(3000000), points[1].Timestamp)
+
+	require.Len(t, errs, 1)
+	require.Equal(t, 4, errs[0].Line)
+	require.Equal(t, "cpu v=oops 2000", errs[0].Text)
+}
+
+func TestPrecision(t *testing.T) {
+	for _, tc := range []struct {
+		precision string
+		ts        string
+		expected  int64
+	}{
+		{"", "1700000000123456789", 1700000000123},
+		{"ns", "-1", -1},
+		{"us", "1700000000123456", 1700000000123},
+		{"u", "-1500", -2},
+		{"ms", "1700000000123", 1700000000123},
+		{"s", "1700000000", 1700000000000},
+		{"m", "2", 120000},
+		{"h", "1", 3600000},
+	} {
+		precision, err := ParsePrecision(tc.precision)
+		require.NoError(t, err)
+
+		points, errs := Parse([]byte("m v=1 "+tc.ts), precision, time.Now())
+		require.Empty(t, errs)
+		require.Equal(t, tc.expected, points[0].Timestamp, tc.precision)
+	}
+
+	_, err := ParsePrecision("d")
+	require.Error(t, err)
+
+	_, errs := Parse([]byte("m v=1 9223372036854775807"), Second, time.Now())
+	require.Len(t, errs, 1)
+}
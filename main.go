@@ -92,6 +92,14 @@ func main() {
 
 	cpuData := dataTransformer.GenCPUData("./data/cpu_usage.json", 100000)
 
+	timestampSizes := experiments.CompareTimestampEncodings(experiments.CPUDataTimestamps(cpuData))
+	logger.Info(
+		"cpu usage timestamp encoding",
+		zap.Int("timestamps", timestampSizes.Timestamps),
+		zap.Int("delta-text-bytes", timestampSizes.DeltaText),
+		zap.Int("delta-of-delta-bytes", timestampSizes.DeltaOfDelta),
+	)
+
 	experiments.Experiment(
 		"Append 100K CPU Usage data in disk",
 		"./results/100k-cpu-usage.html",
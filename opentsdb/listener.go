@@ -0,0 +1,68 @@
+package opentsdb
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/Marvin9/ftsdb/ftsdb"
+	"github.com/Marvin9/ftsdb/shared"
+	"go.uber.org/zap"
+)
+
+// Listener serves the telnet style protocol of OpenTSDB over TCP, appending
+// the datapoints of put commands to a database. Like OpenTSDB it only
+// replies to commands that fail, and to version.
+type Listener struct {
+	db     ftsdb.DBInterface
+	logger *zap.Logger
+	server *shared.LineServer
+}
+
+func NewListener(logger *zap.Logger, db ftsdb.DBInterface) *Listener {
+	listener := &Listener{
+		db:     db,
+		logger: logger,
+	}
+	listener.server = shared.NewLineServer(logger, listener.handle)
+
+	return listener
+}
+
+// Serve accepts connections on l until Close is called.
+func (l *Listener) Serve(listener net.Listener) error {
+	return l.server.Serve(listener)
+}
+
+// Close stops accepting connections and waits until the commands already
+// received are handled.
+func (l *Listener) Close() error {
+	return l.server.Close()
+}
+
+func (l *Listener) handle(w io.Writer, line string) {
+	command, _, _ := strings.Cut(strings.TrimSpace(line), " ")
+
+	switch command {
+	case "":
+	case "put":
+		point, err := ParsePut(line)
+		if err == nil {
+			err = l.db.CreateMetric(point.Metric).Append(point.Labels, point.Timestamp, point.Value)
+		}
+		if err != nil {
+			l.reply(w, "put: %s\n", err)
+		}
+	case "version":
+		l.reply(w, "ftsdb\n")
+	default:
+		l.reply(w, "unknown command: %s\n", command)
+	}
+}
+
+func (l *Listener) reply(w io.Writer, format string, args ...interface{}) {
+	if _, err := fmt.Fprintf(w, format, args...); err != nil {
+		l.logger.Warn("writing reply", zap.Error(err))
+	}
+}
@@ -0,0 +1,65 @@
+package opentsdb
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Marvin9/ftsdb/ftsdb"
+	"github.com/Marvin9/ftsdb/ftsdb/ftsdbtest"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestListener(t *testing.T) {
+	db := ftsdbtest.NewDB(t)
+
+	listener := NewListener(zap.NewNop(), db)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- listener.Serve(l)
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	replies := bufio.NewReader(conn)
+	send := func(line string) {
+		_, err := conn.Write([]byte(line + "\n"))
+		require.NoError(t, err)
+	}
+
+	send("put sys.cpu.user 1000 1 host=web-1")
+	send("put sys.cpu.user 1000 oops host=web-1")
+	reply, err := replies.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "put: invalid value \"oops\"\n", reply)
+
+	send("put sys.cpu.user 1010 2 host=web-1")
+	send("put sys.cpu.user 1010 5 host=web-2")
+	send("version")
+	reply, err = replies.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "ftsdb\n", reply)
+
+	send("get sys.cpu.user")
+	reply, err = replies.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "unknown command: get\n", reply)
+
+	// replies are in order, so the puts before version are appended
+	require.Equal(t, map[string][]ftsdb.Datapoint{
+		`{host="web-1"}`: {{Timestamp: 1000000, Value: 1}, {Timestamp: 1010000, Value: 2}},
+		`{host="web-2"}`: {{Timestamp: 1010000, Value: 5}},
+	}, ftsdbtest.Collect(t, db, "sys_cpu_user"))
+
+	require.NoError(t, listener.Close())
+	require.NoError(t, <-served)
+}
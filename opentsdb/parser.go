@@ -0,0 +1,172 @@
+// Package opentsdb receives datapoints in the formats of OpenTSDB, the put
+// command of its telnet style protocol and the JSON body of its /api/put
+// endpoint.
+package opentsdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/prometheus/util/strutil"
+)
+
+// maxSeconds is the largest timestamp taken to be in seconds. Larger ones are
+// in milliseconds, like OpenTSDB tells them apart by their 10 or 13 digits.
+const maxSeconds = 9999999999
+
+// Point is a parsed datapoint. Metric and label names are sanitized into
+// valid Prometheus names so that they can be queried with PromQL.
+type Point struct {
+	Metric string
+	Labels map[string]string
+	// Timestamp is in milliseconds
+	Timestamp int64
+	Value     float64
+}
+
+// ParsePut parses a put command
+//
+//	put <metric> <timestamp> <value> <tagk1=tagv1 ...>
+func ParsePut(line string) (Point, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != "put" {
+		return Point{}, errors.New("not a put command")
+	}
+	if len(fields) < 4 {
+		return Point{}, errors.New("expected put <metric> <timestamp> <value> <tagk1=tagv1 ...>")
+	}
+
+	timestamp, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return Point{}, fmt.Errorf("invalid timestamp %q", fields[2])
+	}
+
+	value, err := strconv.ParseFloat(fields[3], 64)
+	if err != nil {
+		return Point{}, fmt.Errorf("invalid value %q", fields[3])
+	}
+
+	tags := map[string]string{}
+	for _, tag := range fields[4:] {
+		name, value, found := strings.Cut(tag, "=")
+		if !found {
+			return Point{}, fmt.Errorf("invalid tag %q", tag)
+		}
+		tags[name] = value
+	}
+
+	return newPoint(fields[1], timestamp, value, tags)
+}
+
+// httpPoint is a datapoint of an /api/put body. OpenTSDB accepts values both
+// as numbers and strings.
+type httpPoint struct {
+	Metric    string            `json:"metric"`
+	Timestamp int64             `json:"timestamp"`
+	Value     json.RawMessage   `json:"value"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// PointError reports a datapoint of an /api/put body that could not be
+// parsed. Index counts from 0.
+type PointError struct {
+	Index int
+	Err   error
+}
+
+func (e *PointError) Error() string {
+	return fmt.Sprintf("datapoint %d: %s", e.Index, e.Err)
+}
+
+func (e *PointError) Unwrap() error {
+	return e.Err
+}
+
+// ParseHTTP parses the body of an /api/put request, a single datapoint or an
+// array of them. Datapoints that fail to parse are returned as errors, the
+// others as points. An error is returned if the body is not valid at all.
+func ParseHTTP(data []byte) ([]Point, []*PointError, error) {
+	var raw []httpPoint
+
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		var single httpPoint
+		if err := json.Unmarshal(data, &single); err != nil {
+			return nil, nil, err
+		}
+		raw = []httpPoint{single}
+	}
+
+	points := []Point{}
+	pointErrors := []*PointError{}
+
+	for idx, dp := range raw {
+		point, err := dp.parse()
+		if err != nil {
+			pointErrors = append(pointErrors, &PointError{Index: idx, Err: err})
+			continue
+		}
+		points = append(points, point)
+	}
+
+	return points, pointErrors, nil
+}
+
+func (dp httpPoint) parse() (Point, error) {
+	if len(dp.Value) == 0 {
+		return Point{}, errors.New("missing value")
+	}
+
+	var value float64
+	if err := json.Unmarshal(dp.Value, &value); err != nil {
+		var s string
+		if err := json.Unmarshal(dp.Value, &s); err != nil {
+			return Point{}, fmt.Errorf("invalid value %s", dp.Value)
+		}
+		if value, err = strconv.ParseFloat(s, 64); err != nil {
+			return Point{}, fmt.Errorf("invalid value %q", s)
+		}
+	}
+
+	return newPoint(dp.Metric, dp.Timestamp, value, dp.Tags)
+}
+
+func newPoint(metric string, timestamp int64, value float64, tags map[string]string) (Point, error) {
+	if metric == "" {
+		return Point{}, errors.New("missing metric")
+	}
+	if timestamp <= 0 {
+		return Point{}, fmt.Errorf("invalid timestamp %d", timestamp)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return Point{}, fmt.Errorf("invalid value %v", value)
+	}
+
+	if timestamp <= maxSeconds {
+		timestamp *= 1000
+	}
+
+	labels := make(map[string]string, len(tags))
+	for name, value := range tags {
+		if name == "" || value == "" {
+			return Point{}, fmt.Errorf("invalid tag %q", name+"="+value)
+		}
+		labels[strutil.SanitizeFullLabelName(name)] = value
+	}
+
+	return Point{
+		Metric:    strutil.SanitizeFullLabelName(metric),
+		Labels:    labels,
+		Timestamp: timestamp,
+		Value:     value,
+	}, nil
+}
@@ -0,0 +1,104 @@
+package opentsdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePut(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		line  string
+		point Point
+	}{
+		{
+			name: "seconds",
+			line: "put sys.cpu.user 1700000000 42.5 host=web-1 cpu=0",
+			point: Point{
+				Metric:    "sys_cpu_user",
+				Labels:    map[string]string{"host": "web-1", "cpu": "0"},
+				Timestamp: 1700000000000,
+				Value:     42.5,
+			},
+		},
+		{
+			name: "milliseconds",
+			line: "put sys.cpu.user 1700000000123 1 host=web-1",
+			point: Point{
+				Metric:    "sys_cpu_user",
+				Labels:    map[string]string{"host": "web-1"},
+				Timestamp: 1700000000123,
+				Value:     1,
+			},
+		},
+		{
+			name: "sanitized tags",
+			line: "put  disk.used  1000  5e3  mount.point=/var ",
+			point: Point{
+				Metric:    "disk_used",
+				Labels:    map[string]string{"mount_point": "/var"},
+				Timestamp: 1000000,
+				Value:     5000,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			point, err := ParsePut(tc.line)
+			require.NoError(t, err)
+			require.Equal(t, tc.point, point)
+		})
+	}
+}
+
+func TestParsePutErrors(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		err  string
+	}{
+		{"get sys.cpu.user", "not a put command"},
+		{"put sys.cpu.user 1000", "expected put <metric> <timestamp> <value> <tagk1=tagv1 ...>"},
+		{"put sys.cpu.user now 1", `invalid timestamp "now"`},
+		{"put sys.cpu.user -5 1", "invalid timestamp -5"},
+		{"put sys.cpu.user 1000 oops", `invalid value "oops"`},
+		{"put sys.cpu.user 1000 NaN", "invalid value NaN"},
+		{"put sys.cpu.user 1000 1 host", `invalid tag "host"`},
+		{"put sys.cpu.user 1000 1 host=", `invalid tag "host="`},
+	} {
+		_, err := ParsePut(tc.line)
+		require.EqualError(t, err, tc.err, tc.line)
+	}
+}
+
+func TestParseHTTP(t *testing.T) {
+	points, pointErrors, err := ParseHTTP([]byte(`[
+		{"metric": "sys.cpu.user", "timestamp": 1000, "value": 1, "tags": {"host": "web-1"}},
+		{"metric": "sys.cpu.user", "timestamp": 1000123, "value": "2.5", "tags": {"host": "web-2"}},
+		{"metric": "sys.cpu.user", "timestamp": 1000, "value": "oops", "tags": {"host": "web-3"}},
+		{"metric": "", "timestamp": 1000, "value": 1},
+		{"metric": "sys.cpu.user", "timestamp": 1000, "tags": {"host": "web-4"}}
+	]`))
+	require.NoError(t, err)
+	require.Equal(t, []Point{
+		{Metric: "sys_cpu_user", Labels: map[string]string{"host": "web-1"}, Timestamp: 1000000, Value: 1},
+		{Metric: "sys_cpu_user", Labels: map[string]string{"host": "web-2"}, Timestamp: 1000123000, Value: 2.5},
+	}, points)
+
+	errs := []string{}
+	for _, pointErr := range pointErrors {
+		errs = append(errs, pointErr.Error())
+	}
+	require.Equal(t, []string{
+		`datapoint 2: invalid value "oops"`,
+		"datapoint 3: missing metric",
+		"datapoint 4: missing value",
+	}, errs)
+
+	points, pointErrors, err = ParseHTTP([]byte(`{"metric": "m", "timestamp": 1000, "value": 3, "tags": {"a": "b"}}`))
+	require.NoError(t, err)
+	require.Empty(t, pointErrors)
+	require.Equal(t, []Point{{Metric: "m", Labels: map[string]string{"a": "b"}, Timestamp: 1000000, Value: 3}}, points)
+
+	_, _, err = ParseHTTP([]byte(`{"metric": `))
+	require.Error(t, err)
+}
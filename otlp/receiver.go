@@ -0,0 +1,291 @@
+// Package otlp appends metrics received with the OpenTelemetry protocol to
+// an ftsdb database.
+package otlp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Marvin9/ftsdb/ftsdb"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/util/strutil"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// Receiver appends OTLP metrics to a database the way Prometheus stores
+// them. The attributes of the resource, the scope and the datapoint become
+// the labels of a series, the more specific ones winning. Names are
+// sanitized into valid Prometheus names so that they can be queried with
+// PromQL.
+//
+// Gauges and non-monotonic sums are stored as they are and monotonic sums
+// become counters with the suffix _total. Explicit bucket histograms are
+// expanded into the series <metric>_bucket with an le label for every
+// bucket, <metric>_sum and <metric>_count, with cumulative buckets like
+// Prometheus histograms.
+//
+// Series with delta temporality are turned into cumulative ones by adding
+// every datapoint to the running total of its series, so that functions like
+// rate work on them. The totals are kept in memory, so after a restart they
+// start again from zero, which queries see as a counter reset. The same
+// happens to series that got no datapoint for the delta staleness, whose
+// totals are dropped.
+type Receiver struct {
+	db ftsdb.DBInterface
+
+	mtx       sync.Mutex
+	totals    map[string]deltaTotal
+	staleness time.Duration
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// DefaultDeltaStaleness is how long the running total of a delta series is
+// kept without new datapoints.
+const DefaultDeltaStaleness = 5 * time.Minute
+
+type deltaTotal struct {
+	timestamp int64
+	value     float64
+	updated   time.Time
+}
+
+func NewReceiver(db ftsdb.DBInterface) *Receiver {
+	return &Receiver{
+		db:        db,
+		totals:    map[string]deltaTotal{},
+		staleness: DefaultDeltaStaleness,
+		now:       time.Now,
+	}
+}
+
+// SetDeltaStaleness sets how long the running total of a delta series is
+// kept without new datapoints.
+func (r *Receiver) SetDeltaStaleness(staleness time.Duration) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if staleness > 0 {
+		r.staleness = staleness
+	}
+}
+
+// Append appends metrics, carrying on past datapoints that fail. It returns
+// how many datapoints were rejected and why.
+func (r *Receiver) Append(metrics pmetric.Metrics) (int, []error) {
+	rejected := 0
+	errs := []error{}
+
+	reject := func(metric pmetric.Metric, err error) {
+		rejected++
+		errs = append(errs, fmt.Errorf("metric %s: %w", metric.Name(), err))
+	}
+
+	for i := 0; i < metrics.ResourceMetrics().Len(); i++ {
+		rm := metrics.ResourceMetrics().At(i)
+
+		for j := 0; j < rm.ScopeMetrics().Len(); j++ {
+			sm := rm.ScopeMetrics().At(j)
+
+			for k := 0; k < sm.Metrics().Len(); k++ {
+				metric := sm.Metrics().At(k)
+				attributes := []pcommon.Map{rm.Resource().Attributes(), sm.Scope().Attributes()}
+
+				if metric.Name() == "" {
+					reject(metric, errors.New("missing name"))
+					continue
+				}
+				name := strutil.SanitizeFullLabelName(metric.Name())
+
+				switch metric.Type() {
+				case pmetric.MetricTypeGauge:
+					points := metric.Gauge().DataPoints()
+					for l := 0; l < points.Len(); l++ {
+						if err := r.appendNumber(name, attributes, points.At(l), false); err != nil {
+							reject(metric, err)
+						}
+					}
+				case pmetric.MetricTypeSum:
+					sum := metric.Sum()
+					if sum.IsMonotonic() && !strings.HasSuffix(name, "_total") {
+						name += "_total"
+					}
+					delta := sum.AggregationTemporality() == pmetric.AggregationTemporalityDelta
+
+					points := sum.DataPoints()
+					for l := 0; l < points.Len(); l++ {
+						if err := r.appendNumber(name, attributes, points.At(l), delta); err != nil {
+							reject(metric, err)
+						}
+					}
+				case pmetric.MetricTypeHistogram:
+					histogram := metric.Histogram()
+					delta := histogram.AggregationTemporality() == pmetric.AggregationTemporalityDelta
+
+					points := histogram.DataPoints()
+					for l := 0; l < points.Len(); l++ {
+						if err := r.appendHistogram(name, attributes, points.At(l), delta); err != nil {
+							reject(metric, err)
+						}
+					}
+				default:
+					reject(metric, fmt.Errorf("unsupported type %s", metric.Type()))
+				}
+			}
+		}
+	}
+
+	return rejected, errs
+}
+
+func (r *Receiver) appendNumber(name string, attributes []pcommon.Map, point pmetric.NumberDataPoint, delta bool) error {
+	if point.Flags().NoRecordedValue() {
+		return nil
+	}
+
+	var value float64
+	switch point.ValueType() {
+	case pmetric.NumberDataPointValueTypeDouble:
+		value = point.DoubleValue()
+	case pmetric.NumberDataPointValueTypeInt:
+		value = float64(point.IntValue())
+	default:
+		return errors.New("datapoint without value")
+	}
+
+	timestamp, err := timestampOf(point.Timestamp())
+	if err != nil {
+		return err
+	}
+
+	series := seriesLabels(append(attributes, point.Attributes()))
+
+	return r.append(name, series, timestamp, value, delta)
+}
+
+func (r *Receiver) appendHistogram(name string, attributes []pcommon.Map, point pmetric.HistogramDataPoint, delta bool) error {
+	if point.Flags().NoRecordedValue() {
+		return nil
+	}
+
+	bounds := point.ExplicitBounds()
+	counts := point.BucketCounts()
+	if counts.Len() != 0 && counts.Len() != bounds.Len()+1 {
+		return fmt.Errorf("%d bucket counts for %d bounds", counts.Len(), bounds.Len())
+	}
+
+	timestamp, err := timestampOf(point.Timestamp())
+	if err != nil {
+		return err
+	}
+
+	series := seriesLabels(append(attributes, point.Attributes()))
+
+	var first error
+	appendSeries := func(metric string, series map[string]string, value float64) {
+		if err := r.append(metric, series, timestamp, value, delta); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	if counts.Len() != 0 {
+		cumulative := uint64(0)
+		for idx := 0; idx < bounds.Len(); idx++ {
+			cumulative += counts.At(idx)
+			appendSeries(name+"_bucket", withLabel(series, labels.BucketLabel, strconv.FormatFloat(bounds.At(idx), 'f', -1, 64)), float64(cumulative))
+		}
+	}
+	appendSeries(name+"_bucket", withLabel(series, labels.BucketLabel, "+Inf"), float64(point.Count()))
+	if point.HasSum() {
+		appendSeries(name+"_sum", series, point.Sum())
+	}
+	appendSeries(name+"_count", series, float64(point.Count()))
+
+	return first
+}
+
+// append appends a datapoint, adding it to the running total of its series
+// if it is a delta.
+func (r *Receiver) append(metric string, series map[string]string, timestamp int64, value float64, delta bool) error {
+	if !delta {
+		return r.db.CreateMetric(metric).Append(series, timestamp, value)
+	}
+
+	key := metric + labels.FromMap(series).String()
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	now := r.now()
+	r.sweep(now)
+
+	total, found := r.totals[key]
+	if found && now.Sub(total.updated) > r.staleness {
+		total, found = deltaTotal{}, false
+	}
+	if found && timestamp <= total.timestamp {
+		return fmt.Errorf("delta at %d is not newer than the one at %d", timestamp, total.timestamp)
+	}
+
+	total = deltaTotal{timestamp: timestamp, value: total.value + value, updated: now}
+	if err := r.db.CreateMetric(metric).Append(series, total.timestamp, total.value); err != nil {
+		return err
+	}
+	r.totals[key] = total
+
+	return nil
+}
+
+// sweep drops the totals that went stale, at most once per staleness. The
+// caller holds mtx.
+func (r *Receiver) sweep(now time.Time) {
+	if now.Sub(r.lastSweep) < r.staleness {
+		return
+	}
+	r.lastSweep = now
+
+	for key, total := range r.totals {
+		if now.Sub(total.updated) > r.staleness {
+			delete(r.totals, key)
+		}
+	}
+}
+
+func timestampOf(ts pcommon.Timestamp) (int64, error) {
+	if ts == 0 {
+		return 0, errors.New("datapoint without timestamp")
+	}
+	return ts.AsTime().UnixMilli(), nil
+}
+
+// seriesLabels merges attributes into labels, later maps overriding earlier
+// ones.
+func seriesLabels(attributes []pcommon.Map) map[string]string {
+	series := map[string]string{}
+
+	for _, attrs := range attributes {
+		attrs.Range(func(key string, value pcommon.Value) bool {
+			if s := value.AsString(); s != "" {
+				series[strutil.SanitizeFullLabelName(key)] = s
+			}
+			return true
+		})
+	}
+
+	return series
+}
+
+func withLabel(series map[string]string, name, value string) map[string]string {
+	with := make(map[string]string, len(series)+1)
+	for k, v := range series {
+		with[k] = v
+	}
+	with[name] = value
+
+	return with
+}
@@ -0,0 +1,262 @@
+package otlp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Marvin9/ftsdb/ftsdb"
+	"github.com/Marvin9/ftsdb/ftsdb/ftsdbtest"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+func receiverFixture(t *testing.T) (ftsdb.DBInterface, *Receiver) {
+	db := ftsdbtest.NewDB(t)
+
+	db.SetRejectOutOfOrder(true)
+
+	return db, NewReceiver(db)
+}
+
+// newMetrics returns metrics with a resource and a scope that have
+// attributes, and the list to add metrics to.
+func newMetrics() (pmetric.Metrics, pmetric.MetricSlice) {
+	metrics := pmetric.NewMetrics()
+
+	rm := metrics.ResourceMetrics().AppendEmpty()
+	rm.Resource().Attributes().PutStr("service.name", "checkout")
+	rm.Resource().Attributes().PutStr("host", "resource")
+
+	sm := rm.ScopeMetrics().AppendEmpty()
+	sm.Scope().SetName("ignored")
+	sm.Scope().Attributes().PutStr("host", "scope")
+	sm.Scope().Attributes().PutInt("shard", 3)
+
+	return metrics, sm.Metrics()
+}
+
+func timestamp(ms int64) pcommon.Timestamp {
+	return pcommon.NewTimestampFromTime(time.UnixMilli(ms))
+}
+
+func TestGauge(t *testing.T) {
+	db, receiver := receiverFixture(t)
+
+	metrics, ms := newMetrics()
+	gauge := ms.AppendEmpty()
+	gauge.SetName("queue.depth")
+
+	dp := gauge.SetEmptyGauge().DataPoints().AppendEmpty()
+	dp.SetTimestamp(timestamp(1000))
+	dp.SetIntValue(3)
+	dp.Attributes().PutStr("host", "web-1")
+
+	dp = gauge.Gauge().DataPoints().AppendEmpty()
+	dp.SetTimestamp(timestamp(2000))
+	dp.SetDoubleValue(1.5)
+
+	dp = gauge.Gauge().DataPoints().AppendEmpty()
+	dp.SetTimestamp(timestamp(3000))
+	dp.SetFlags(pmetric.DefaultDataPointFlags.WithNoRecordedValue(true))
+
+	rejected, errs := receiver.Append(metrics)
+	require.Zero(t, rejected)
+	require.Empty(t, errs)
+
+	require.Equal(t, map[string][]ftsdb.Datapoint{
+		`{host="web-1", service_name="checkout", shard="3"}`: {{Timestamp: 1000, Value: 3}},
+		`{host="scope", service_name="checkout", shard="3"}`: {{Timestamp: 2000, Value: 1.5}},
+	}, ftsdbtest.Collect(t, db, "queue_depth"))
+}
+
+func TestSum(t *testing.T) {
+	db, receiver := receiverFixture(t)
+
+	addSum := func(ms pmetric.MetricSlice, name string, temporality pmetric.AggregationTemporality, monotonic bool, values ...float64) {
+		metric := ms.AppendEmpty()
+		metric.SetName(name)
+
+		sum := metric.SetEmptySum()
+		sum.SetAggregationTemporality(temporality)
+		sum.SetIsMonotonic(monotonic)
+		for idx, value := range values {
+			dp := sum.DataPoints().AppendEmpty()
+			dp.SetTimestamp(timestamp(int64(idx+1) * 1000))
+			dp.SetDoubleValue(value)
+		}
+	}
+
+	metrics, ms := newMetrics()
+	addSum(ms, "requests", pmetric.AggregationTemporalityCumulative, true, 5, 8)
+	addSum(ms, "sent_total", pmetric.AggregationTemporalityDelta, true, 5, 3)
+	addSum(ms, "inflight", pmetric.AggregationTemporalityDelta, false, 2, -1)
+	addSum(ms, "memory", pmetric.AggregationTemporalityCumulative, false, 100, 90)
+
+	rejected, errs := receiver.Append(metrics)
+	require.Zero(t, rejected)
+	require.Empty(t, errs)
+
+	series := `{host="scope", service_name="checkout", shard="3"}`
+	for metric, datapoints := range map[string][]ftsdb.Datapoint{
+		"requests_total": {{Timestamp: 1000, Value: 5}, {Timestamp: 2000, Value: 8}},
+		"sent_total":     {{Timestamp: 1000, Value: 5}, {Timestamp: 2000, Value: 8}},
+		"inflight":       {{Timestamp: 1000, Value: 2}, {Timestamp: 2000, Value: 1}},
+		"memory":         {{Timestamp: 1000, Value: 100}, {Timestamp: 2000, Value: 90}},
+	} {
+		require.Equal(t, map[string][]ftsdb.Datapoint{series: datapoints}, ftsdbtest.Collect(t, db, metric), metric)
+	}
+
+	// deltas keep adding up across requests, and old ones are rejected
+	metrics, ms = newMetrics()
+	addSum(ms, "sent_total", pmetric.AggregationTemporalityDelta, true, 100, 100, 4)
+
+	rejected, errs = receiver.Append(metrics)
+	require.Equal(t, 2, rejected)
+	require.Len(t, errs, 2)
+	require.EqualError(t, errs[0], "metric sent_total: delta at 1000 is not newer than the one at 2000")
+
+	require.Equal(t, map[string][]ftsdb.Datapoint{
+		series: {{Timestamp: 1000, Value: 5}, {Timestamp: 2000, Value: 8}, {Timestamp: 3000, Value: 12}},
+	}, ftsdbtest.Collect(t, db, "sent_total"))
+}
+
+func TestDeltaStaleness(t *testing.T) {
+	db, receiver := receiverFixture(t)
+
+	now := time.Unix(0, 0)
+	receiver.now = func() time.Time { return now }
+	receiver.SetDeltaStaleness(time.Minute)
+
+	appendDelta := func(name string, ts int64, value float64) {
+		metrics, ms := newMetrics()
+		metric := ms.AppendEmpty()
+		metric.SetName(name)
+
+		sum := metric.SetEmptySum()
+		sum.SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+		sum.SetIsMonotonic(true)
+		dp := sum.DataPoints().AppendEmpty()
+		dp.SetTimestamp(timestamp(ts))
+		dp.SetDoubleValue(value)
+
+		rejected, errs := receiver.Append(metrics)
+		require.Zero(t, rejected)
+		require.Empty(t, errs)
+	}
+
+	appendDelta("sent", 1000, 5)
+	appendDelta("received", 1000, 1)
+
+	now = now.Add(30 * time.Second)
+	appendDelta("sent", 2000, 3)
+
+	// received went stale and is dropped, sent is still updated
+	now = now.Add(45 * time.Second)
+	appendDelta("sent", 3000, 2)
+	require.Len(t, receiver.totals, 1)
+
+	// a stale series starts again from zero
+	appendDelta("received", 4000, 2)
+
+	series := `{host="scope", service_name="checkout", shard="3"}`
+	require.Equal(t, map[string][]ftsdb.Datapoint{
+		series: {{Timestamp: 1000, Value: 5}, {Timestamp: 2000, Value: 8}, {Timestamp: 3000, Value: 10}},
+	}, ftsdbtest.Collect(t, db, "sent_total"))
+	require.Equal(t, map[string][]ftsdb.Datapoint{
+		series: {{Timestamp: 1000, Value: 1}, {Timestamp: 4000, Value: 2}},
+	}, ftsdbtest.Collect(t, db, "received_total"))
+}
+
+func TestHistogram(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		temporality pmetric.AggregationTemporality
+		second      []uint64
+	}{
+		{name: "cumulative", temporality: pmetric.AggregationTemporalityCumulative, second: []uint64{2, 4, 1}},
+		{name: "delta", temporality: pmetric.AggregationTemporalityDelta, second: []uint64{1, 3, 0}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, receiver := receiverFixture(t)
+
+			metrics, ms := newMetrics()
+			metric := ms.AppendEmpty()
+			metric.SetName("latency")
+
+			histogram := metric.SetEmptyHistogram()
+			histogram.SetAggregationTemporality(tc.temporality)
+
+			for idx, counts := range [][]uint64{{1, 1, 1}, tc.second} {
+				dp := histogram.DataPoints().AppendEmpty()
+				dp.SetTimestamp(timestamp(int64(idx+1) * 1000))
+				dp.ExplicitBounds().FromRaw([]float64{0.1, 0.5})
+				dp.BucketCounts().FromRaw(counts)
+
+				count := uint64(0)
+				for _, c := range counts {
+					count += c
+				}
+				dp.SetCount(count)
+				dp.SetSum(float64(count))
+			}
+
+			rejected, errs := receiver.Append(metrics)
+			require.Zero(t, rejected)
+			require.Empty(t, errs)
+
+			bucket := func(le string) string {
+				return `{host="scope", le="` + le + `", service_name="checkout", shard="3"}`
+			}
+			series := `{host="scope", service_name="checkout", shard="3"}`
+			require.Equal(t, map[string][]ftsdb.Datapoint{
+				bucket("0.1"):  {{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}},
+				bucket("0.5"):  {{Timestamp: 1000, Value: 2}, {Timestamp: 2000, Value: 6}},
+				bucket("+Inf"): {{Timestamp: 1000, Value: 3}, {Timestamp: 2000, Value: 7}},
+			}, ftsdbtest.Collect(t, db, "latency_bucket"))
+			require.Equal(t, map[string][]ftsdb.Datapoint{
+				series: {{Timestamp: 1000, Value: 3}, {Timestamp: 2000, Value: 7}},
+			}, ftsdbtest.Collect(t, db, "latency_count"))
+			require.Equal(t, map[string][]ftsdb.Datapoint{
+				series: {{Timestamp: 1000, Value: 3}, {Timestamp: 2000, Value: 7}},
+			}, ftsdbtest.Collect(t, db, "latency_sum"))
+		})
+	}
+}
+
+func TestRejected(t *testing.T) {
+	_, receiver := receiverFixture(t)
+
+	metrics, ms := newMetrics()
+
+	summary := ms.AppendEmpty()
+	summary.SetName("rpc.duration")
+	summary.SetEmptySummary().DataPoints().AppendEmpty()
+
+	gauge := ms.AppendEmpty()
+	gauge.SetName("temperature")
+	gauge.SetEmptyGauge().DataPoints().AppendEmpty().SetDoubleValue(20)
+
+	histogram := ms.AppendEmpty()
+	histogram.SetName("latency")
+	dp := histogram.SetEmptyHistogram().DataPoints().AppendEmpty()
+	dp.SetTimestamp(timestamp(1000))
+	dp.ExplicitBounds().FromRaw([]float64{1})
+	dp.BucketCounts().FromRaw([]uint64{1})
+
+	ms.AppendEmpty().SetEmptyGauge()
+
+	rejected, errs := receiver.Append(metrics)
+	require.Equal(t, 4, rejected)
+
+	msgs := []string{}
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	require.Equal(t, []string{
+		"metric rpc.duration: unsupported type Summary",
+		"metric temperature: datapoint without timestamp",
+		"metric latency: 1 bucket counts for 1 bounds",
+		"metric : missing name",
+	}, msgs)
+}
@@ -0,0 +1,255 @@
+// Package promadapter exposes an ftsdb database as Prometheus storage, so
+// that the PromQL engine and anything else built on storage.Queryable can
+// query it.
+package promadapter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Marvin9/ftsdb/ftsdb"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/util/annotations"
+)
+
+// ErrSampleLimit is returned by Select of a queryable with a sample limit
+// once the series it reads hold more samples than the limit.
+type ErrSampleLimit int
+
+func (e ErrSampleLimit) Error() string {
+	return fmt.Sprintf("exceeded sample limit (%d)", int(e))
+}
+
+type queryable struct {
+	db          ftsdb.DBInterface
+	sampleLimit int
+}
+
+// NewQueryable returns a storage.Queryable reading from db. The metric of a
+// series is exposed as its __name__ label, and labels with an empty value
+// are dropped like Prometheus does on append.
+func NewQueryable(db ftsdb.DBInterface) storage.Queryable {
+	return &queryable{db: db}
+}
+
+// NewQueryableWithSampleLimit is like NewQueryable, but a Select stops
+// reading and fails with ErrSampleLimit at the first sample over limit. A
+// limit of zero disables it.
+func NewQueryableWithSampleLimit(db ftsdb.DBInterface, limit int) storage.Queryable {
+	return &queryable{db: db, sampleLimit: limit}
+}
+
+func (q *queryable) Querier(mint, maxt int64) (storage.Querier, error) {
+	return &querier{
+		db:          q.db,
+		mint:        mint,
+		maxt:        maxt,
+		sampleLimit: q.sampleLimit,
+	}, nil
+}
+
+type querier struct {
+	db          ftsdb.DBInterface
+	mint, maxt  int64
+	sampleLimit int
+
+	// iterators are the ones opened by the querier, closed with it
+	mtx       sync.Mutex
+	iterators []*ftsdb.SeriesIterator
+}
+
+func (q *querier) Select(ctx context.Context, sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	mint, maxt := q.mint, q.maxt
+	if hints != nil {
+		mint, maxt = hints.Start, hints.End
+	}
+
+	query, err := q.query(mint, maxt, matchers)
+	if err != nil {
+		return storage.ErrSeriesSet(err)
+	}
+
+	ss, err := q.find(query)
+	if err != nil {
+		return storage.ErrSeriesSet(err)
+	}
+
+	// Series of a SeriesIterator can only be read while it is at them, but
+	// a SeriesSet has to keep them iterable, so they are read up front. The
+	// sample limit is checked while reading, as chunks are only loaded once
+	// the iteration gets to them.
+	set := &seriesSet{idx: -1}
+	samples := 0
+	for s := ss.Next(); s != nil; s = s.Next() {
+		if err := ctx.Err(); err != nil {
+			return storage.ErrSeriesSet(err)
+		}
+
+		datapoints := []ftsdb.Datapoint{}
+		for dd := s.DatapointsIterator.Next(); dd != nil; dd = dd.Next() {
+			samples++
+			if q.sampleLimit > 0 && samples > q.sampleLimit {
+				return storage.ErrSeriesSet(ErrSampleLimit(q.sampleLimit))
+			}
+			datapoints = append(datapoints, dd.GetDatapoint())
+		}
+		if len(datapoints) == 0 {
+			continue
+		}
+
+		set.series = append(set.series, &series{
+			labels:     seriesLabels(s.GetSeries()),
+			datapoints: datapoints,
+		})
+	}
+	if err := ss.Err(); err != nil {
+		return storage.ErrSeriesSet(err)
+	}
+
+	if sortSeries {
+		sort.Slice(set.series, func(i, j int) bool {
+			return labels.Compare(set.series[i].labels, set.series[j].labels) < 0
+		})
+	}
+
+	return set
+}
+
+func (q *querier) LabelValues(ctx context.Context, name string, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	values := map[string]struct{}{}
+
+	err := q.forEachSeries(ctx, matchers, func(ls labels.Labels) {
+		if value := ls.Get(name); value != "" {
+			values[value] = struct{}{}
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return sortedKeys(values), nil, nil
+}
+
+func (q *querier) LabelNames(ctx context.Context, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	names := map[string]struct{}{}
+
+	err := q.forEachSeries(ctx, matchers, func(ls labels.Labels) {
+		ls.Range(func(l labels.Label) {
+			names[l.Name] = struct{}{}
+		})
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return sortedKeys(names), nil, nil
+}
+
+// Close releases the chunks read by the querier.
+func (q *querier) Close() error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	for _, ss := range q.iterators {
+		ss.Close()
+	}
+	q.iterators = nil
+
+	return nil
+}
+
+func (q *querier) find(query *ftsdb.Query) (*ftsdb.SeriesIterator, error) {
+	ss, err := q.db.Find(*query)
+	if err != nil {
+		return nil, err
+	}
+
+	q.mtx.Lock()
+	q.iterators = append(q.iterators, ss)
+	q.mtx.Unlock()
+
+	return ss, nil
+}
+
+// forEachSeries calls fn with the labels of every series selected by
+// matchers that has datapoints within the range of the querier.
+func (q *querier) forEachSeries(ctx context.Context, matchers []*labels.Matcher, fn func(labels.Labels)) error {
+	query, err := q.query(q.mint, q.maxt, matchers)
+	if err != nil {
+		return err
+	}
+
+	ss, err := q.find(query)
+	if err != nil {
+		return err
+	}
+
+	for s := ss.Next(); s != nil; s = s.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if s.DatapointsIterator.Next() != nil {
+			fn(seriesLabels(s.GetSeries()))
+		}
+	}
+
+	return ss.Err()
+}
+
+func (q *querier) query(mint, maxt int64, matchers []*labels.Matcher) (*ftsdb.Query, error) {
+	query := &ftsdb.Query{}
+	query.RangeStart(mint).RangeEnd(maxt)
+
+	for _, m := range matchers {
+		matcher, err := toMatcher(m)
+		if err != nil {
+			return nil, err
+		}
+		query.Where(matcher)
+	}
+
+	return query, nil
+}
+
+func toMatcher(m *labels.Matcher) (*ftsdb.Matcher, error) {
+	var t ftsdb.MatchType
+
+	switch m.Type {
+	case labels.MatchEqual:
+		t = ftsdb.MatchEqual
+	case labels.MatchNotEqual:
+		t = ftsdb.MatchNotEqual
+	case labels.MatchRegexp:
+		t = ftsdb.MatchRegexp
+	case labels.MatchNotRegexp:
+		t = ftsdb.MatchNotRegexp
+	default:
+		return nil, fmt.Errorf("unsupported matcher %s", m)
+	}
+
+	return ftsdb.NewMatcher(t, m.Name, m.Value)
+}
+
+func seriesLabels(s ftsdb.Series) labels.Labels {
+	b := labels.NewScratchBuilder(len(s.SeriesValue) + 1)
+	for name, value := range s.SeriesValue {
+		if value != "" && name != labels.MetricName {
+			b.Add(name, value)
+		}
+	}
+	b.Add(labels.MetricName, s.Metric)
+	b.Sort()
+	return b.Labels()
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,277 @@
+package promadapter
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Marvin9/ftsdb/ftsdb"
+	"github.com/Marvin9/ftsdb/ftsdb/ftsdbtest"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const fixtureStart int64 = 1700000000000
+
+// fixtures loads the same series into ftsdb, half committed and half in the
+// head, and into prometheus tsdb.
+func fixtures(t *testing.T) (ftsdb.DBInterface, *tsdb.DB) {
+	fdb := ftsdbtest.NewDB(t)
+
+	pdb, err := tsdb.Open(t.TempDir(), nil, nil, tsdb.DefaultOptions(), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { pdb.Close() })
+
+	r := rand.New(rand.NewSource(1))
+	app := pdb.Appender(context.Background())
+
+	for _, metric := range []string{"http_requests_total", "cpu"} {
+		m := fdb.CreateMetric(metric)
+
+		for _, host := range []string{"web-1", "web-2", "db-1"} {
+			for _, region := range []string{"eu", "us", ""} {
+				series := map[string]string{"host": host, "region": region}
+				ls := labels.FromMap(map[string]string{labels.MetricName: metric, "host": host, "region": region})
+
+				value := 0.0
+				for i := int64(0); i < 240; i++ {
+					ts := fixtureStart + i*15000 + int64(r.Intn(1000))
+					value += float64(r.Intn(100))
+
+					require.NoError(t, m.Append(series, ts, value))
+					_, err := app.Append(0, ls, ts, value)
+					require.NoError(t, err)
+
+					if i == 120 && host == "db-1" && region == "" && metric == "cpu" {
+						require.NoError(t, fdb.Commit())
+					}
+				}
+			}
+		}
+	}
+
+	require.NoError(t, app.Commit())
+
+	return fdb, pdb
+}
+
+func TestPromQLMatchesPrometheus(t *testing.T) {
+	fdb, pdb := fixtures(t)
+
+	engine := promql.NewEngine(promql.EngineOpts{
+		MaxSamples:    1000000,
+		Timeout:       time.Minute,
+		LookbackDelta: 5 * time.Minute,
+	})
+
+	start := time.UnixMilli(fixtureStart + 10*60*1000)
+	end := time.UnixMilli(fixtureStart + 55*60*1000)
+
+	for _, qs := range []string{
+		`cpu`,
+		`cpu{host="web-1"}`,
+		`cpu{region=""}`,
+		`{__name__=~"cpu|http_.*", host!~"web-.*"}`,
+		`rate(http_requests_total[5m])`,
+		`sum by (region) (rate(http_requests_total{region!="eu"}[1m]))`,
+		`max_over_time(cpu[10m]) - min_over_time(cpu[10m])`,
+		`topk(2, cpu)`,
+		`count(cpu) by (host)`,
+	} {
+		for _, instant := range []bool{true, false} {
+			run := func(queryable storage.Queryable) string {
+				var query promql.Query
+				var err error
+				if instant {
+					query, err = engine.NewInstantQuery(context.Background(), queryable, nil, qs, end)
+				} else {
+					query, err = engine.NewRangeQuery(context.Background(), queryable, nil, qs, start, end, time.Minute)
+				}
+				require.NoError(t, err)
+				defer query.Close()
+
+				result := query.Exec(context.Background())
+				require.NoError(t, result.Err)
+
+				// vectors come in the order series were selected in,
+				// matrices are sorted by labels
+				if vector, err := result.Vector(); err == nil {
+					sort.Slice(vector, func(i, j int) bool {
+						return labels.Compare(vector[i].Metric, vector[j].Metric) < 0
+					})
+					return vector.String()
+				}
+				return result.Value.String()
+			}
+
+			expected := run(pdb)
+			require.NotEmpty(t, expected, qs)
+			require.Equal(t, expected, run(NewQueryable(fdb)), "%s, instant %t", qs, instant)
+		}
+	}
+}
+
+func TestLabelNamesAndValues(t *testing.T) {
+	fdb, _ := fixtures(t)
+
+	querier, err := NewQueryable(fdb).Querier(fixtureStart, fixtureStart+time.Hour.Milliseconds())
+	require.NoError(t, err)
+	defer querier.Close()
+
+	names, _, err := querier.LabelNames(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{labels.MetricName, "host", "region"}, names)
+
+	values, _, err := querier.LabelValues(context.Background(), labels.MetricName)
+	require.NoError(t, err)
+	require.Equal(t, []string{"cpu", "http_requests_total"}, values)
+
+	values, _, err = querier.LabelValues(context.Background(), "host", labels.MustNewMatcher(labels.MatchRegexp, "host", "web-.*"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"web-1", "web-2"}, values)
+
+	// series without the label do not list it
+	names, _, err = querier.LabelNames(context.Background(), labels.MustNewMatcher(labels.MatchEqual, "region", ""))
+	require.NoError(t, err)
+	require.Equal(t, []string{labels.MetricName, "host"}, names)
+
+	// nothing was appended before the fixtures start
+	querier, err = NewQueryable(fdb).Querier(0, fixtureStart-1)
+	require.NoError(t, err)
+
+	values, _, err = querier.LabelValues(context.Background(), "host")
+	require.NoError(t, err)
+	require.Empty(t, values)
+}
+
+func TestSelectSorted(t *testing.T) {
+	fdb, _ := fixtures(t)
+
+	querier, err := NewQueryable(fdb).Querier(fixtureStart, fixtureStart+time.Hour.Milliseconds())
+	require.NoError(t, err)
+
+	set := querier.Select(context.Background(), true, nil, labels.MustNewMatcher(labels.MatchEqual, "host", "web-1"))
+
+	found := []string{}
+	for set.Next() {
+		s := set.At()
+		found = append(found, s.Labels().String())
+
+		// the iterator can be read again, and seeks within the series
+		it := s.Iterator(nil)
+		count := 0
+		for it.Next() != 0 {
+			count++
+		}
+		require.Equal(t, 240, count)
+
+		it = s.Iterator(it)
+		require.NotZero(t, it.Seek(fixtureStart+30*60*1000))
+		ts, _ := it.At()
+		require.GreaterOrEqual(t, ts, fixtureStart+30*60*1000)
+	}
+	require.NoError(t, set.Err())
+
+	require.Equal(t, []string{
+		`{__name__="cpu", host="web-1"}`,
+		`{__name__="cpu", host="web-1", region="eu"}`,
+		`{__name__="cpu", host="web-1", region="us"}`,
+		`{__name__="http_requests_total", host="web-1"}`,
+		`{__name__="http_requests_total", host="web-1", region="eu"}`,
+		`{__name__="http_requests_total", host="web-1", region="us"}`,
+	}, found)
+}
+
+func TestSelectSampleLimit(t *testing.T) {
+	dir := t.TempDir()
+
+	fdb, err := ftsdb.NewFTSDB(zap.NewNop(), dir)
+	require.NoError(t, err)
+	t.Cleanup(fdb.Close)
+	fdb.SetFlushLimit(1)
+
+	cpu := fdb.CreateMetric("cpu")
+	for ts := int64(0); ts < 20; ts++ {
+		require.NoError(t, cpu.Append(map[string]string{"host": "web-1"}, ts, float64(ts)))
+		if ts == 9 {
+			require.NoError(t, fdb.Commit())
+		}
+	}
+	require.NoError(t, fdb.Commit())
+
+	// a Select reading every chunk fails on the second one
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "10", "chunk"), []byte("garbage"), 0666))
+
+	matcher := labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "cpu")
+
+	querier, err := NewQueryable(fdb).Querier(0, 19)
+	require.NoError(t, err)
+	set := querier.Select(context.Background(), false, nil, matcher)
+	require.False(t, set.Next())
+	var corrupt *ftsdb.ErrCorruptChunk
+	require.ErrorAs(t, set.Err(), &corrupt)
+
+	// the limit is hit within the first chunk, before the second is read
+	querier, err = NewQueryableWithSampleLimit(fdb, 5).Querier(0, 19)
+	require.NoError(t, err)
+	set = querier.Select(context.Background(), false, nil, matcher)
+	require.False(t, set.Next())
+	require.Equal(t, ErrSampleLimit(5), set.Err())
+
+	querier, err = NewQueryableWithSampleLimit(fdb, 10).Querier(0, 9)
+	require.NoError(t, err)
+	set = querier.Select(context.Background(), false, nil, matcher)
+	require.True(t, set.Next())
+	require.False(t, set.Next())
+	require.NoError(t, set.Err())
+}
+
+func TestQuerierCloseReleasesChunks(t *testing.T) {
+	dir := t.TempDir()
+
+	fdb, err := ftsdb.NewFTSDB(zap.NewNop(), dir)
+	require.NoError(t, err)
+	t.Cleanup(fdb.Close)
+	fdb.SetFlushLimit(1)
+
+	hour := int64(time.Hour / time.Millisecond)
+	cpu := fdb.CreateMetric("cpu")
+	for _, ts := range []int64{0, hour, 3 * hour} {
+		require.NoError(t, cpu.Append(map[string]string{"host": "web-1"}, ts, float64(ts)))
+		require.NoError(t, fdb.Commit())
+	}
+
+	chunkDirs := func() []string {
+		files, err := os.ReadDir(dir)
+		require.NoError(t, err)
+
+		names := []string{}
+		for _, file := range files {
+			if file.IsDir() && file.Name() != "wal" {
+				names = append(names, file.Name())
+			}
+		}
+		return names
+	}
+
+	// stopped by the limit before the end, the chunks stay read until the
+	// querier is closed
+	querier, err := NewQueryableWithSampleLimit(fdb, 1).Querier(0, 3*hour)
+	require.NoError(t, err)
+	set := querier.Select(context.Background(), false, nil, labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "cpu"))
+	require.Equal(t, ErrSampleLimit(1), set.Err())
+
+	require.NoError(t, fdb.Compact())
+	require.ElementsMatch(t, []string{"0", "3600000", "0_1", "10800000"}, chunkDirs())
+
+	require.NoError(t, querier.Close())
+	require.ElementsMatch(t, []string{"0_1", "10800000"}, chunkDirs())
+}
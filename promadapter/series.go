@@ -0,0 +1,86 @@
+package promadapter
+
+import (
+	"github.com/Marvin9/ftsdb/ftsdb"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/util/annotations"
+)
+
+type seriesSet struct {
+	series []*series
+	idx    int
+}
+
+func (s *seriesSet) Next() bool {
+	if s.idx+1 >= len(s.series) {
+		return false
+	}
+	s.idx++
+	return true
+}
+
+func (s *seriesSet) At() storage.Series {
+	return s.series[s.idx]
+}
+
+func (s *seriesSet) Err() error {
+	return nil
+}
+
+func (s *seriesSet) Warnings() annotations.Annotations {
+	return nil
+}
+
+type series struct {
+	labels     labels.Labels
+	datapoints []ftsdb.Datapoint
+}
+
+func (s *series) Labels() labels.Labels {
+	return s.labels
+}
+
+func (s *series) Iterator(it chunkenc.Iterator) chunkenc.Iterator {
+	if lsi, ok := it.(interface{ Reset(storage.Samples) }); ok {
+		lsi.Reset(samples(s.datapoints))
+		return it
+	}
+	return storage.NewListSeriesIterator(samples(s.datapoints))
+}
+
+// samples exposes datapoints as float samples to storage.NewListSeriesIterator.
+type samples []ftsdb.Datapoint
+
+func (s samples) Get(i int) chunks.Sample {
+	return sample(s[i])
+}
+
+func (s samples) Len() int {
+	return len(s)
+}
+
+type sample ftsdb.Datapoint
+
+func (s sample) T() int64 {
+	return s.Timestamp
+}
+
+func (s sample) F() float64 {
+	return s.Value
+}
+
+func (s sample) H() *histogram.Histogram {
+	return nil
+}
+
+func (s sample) FH() *histogram.FloatHistogram {
+	return nil
+}
+
+func (s sample) Type() chunkenc.ValueType {
+	return chunkenc.ValFloat
+}
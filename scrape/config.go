@@ -0,0 +1,156 @@
+package scrape
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	DefaultScrapeInterval = model.Duration(time.Minute)
+	DefaultScrapeTimeout  = model.Duration(10 * time.Second)
+	DefaultMetricsPath    = "/metrics"
+	DefaultScheme         = "http"
+)
+
+// Config lists the targets to scrape, in a subset of the Prometheus
+// configuration file format:
+//
+//	scrape_interval: 15s
+//	scrape_configs:
+//	  - job_name: node
+//	    scrape_interval: 10s
+//	    scrape_timeout: 5s
+//	    metrics_path: /metrics
+//	    scheme: http
+//	    static_configs:
+//	      - targets: [localhost:9100]
+//	        labels:
+//	          env: prod
+//
+// The interval and timeout at the top are the defaults of the jobs.
+type Config struct {
+	ScrapeInterval model.Duration  `yaml:"scrape_interval,omitempty"`
+	ScrapeTimeout  model.Duration  `yaml:"scrape_timeout,omitempty"`
+	ScrapeConfigs  []*ScrapeConfig `yaml:"scrape_configs,omitempty"`
+}
+
+// ScrapeConfig is a job, a set of targets scraped the same way.
+type ScrapeConfig struct {
+	JobName        string          `yaml:"job_name"`
+	ScrapeInterval model.Duration  `yaml:"scrape_interval,omitempty"`
+	ScrapeTimeout  model.Duration  `yaml:"scrape_timeout,omitempty"`
+	MetricsPath    string          `yaml:"metrics_path,omitempty"`
+	Scheme         string          `yaml:"scheme,omitempty"`
+	StaticConfigs  []*StaticConfig `yaml:"static_configs,omitempty"`
+}
+
+// StaticConfig lists targets by their host:port, with labels added to all
+// their series.
+type StaticConfig struct {
+	Targets []string          `yaml:"targets"`
+	Labels  map[string]string `yaml:"labels,omitempty"`
+}
+
+// LoadConfig reads the config in filename.
+func LoadConfig(filename string) (*Config, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := ParseConfig(b)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", filename, err)
+	}
+
+	return cfg, nil
+}
+
+// ParseConfig parses and validates a config, filling in the defaults.
+func ParseConfig(b []byte) (*Config, error) {
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(b, cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.ScrapeInterval == 0 {
+		cfg.ScrapeInterval = DefaultScrapeInterval
+	}
+	if cfg.ScrapeTimeout == 0 {
+		cfg.ScrapeTimeout = min(DefaultScrapeTimeout, cfg.ScrapeInterval)
+	}
+	if cfg.ScrapeTimeout > cfg.ScrapeInterval {
+		return nil, fmt.Errorf("scrape_timeout %s is longer than scrape_interval %s", cfg.ScrapeTimeout, cfg.ScrapeInterval)
+	}
+
+	jobs := map[string]bool{}
+	for _, sc := range cfg.ScrapeConfigs {
+		if sc == nil {
+			return nil, errors.New("empty scrape config")
+		}
+		if sc.JobName == "" {
+			return nil, errors.New("scrape config without job_name")
+		}
+		if jobs[sc.JobName] {
+			return nil, fmt.Errorf("duplicate job_name %q", sc.JobName)
+		}
+		jobs[sc.JobName] = true
+
+		if err := sc.validate(cfg); err != nil {
+			return nil, fmt.Errorf("job %q: %w", sc.JobName, err)
+		}
+	}
+
+	return cfg, nil
+}
+
+func (sc *ScrapeConfig) validate(cfg *Config) error {
+	if sc.ScrapeInterval == 0 {
+		sc.ScrapeInterval = cfg.ScrapeInterval
+	}
+	if sc.ScrapeTimeout == 0 {
+		sc.ScrapeTimeout = min(cfg.ScrapeTimeout, sc.ScrapeInterval)
+	}
+	if sc.ScrapeTimeout > sc.ScrapeInterval {
+		return fmt.Errorf("scrape_timeout %s is longer than scrape_interval %s", sc.ScrapeTimeout, sc.ScrapeInterval)
+	}
+
+	if sc.MetricsPath == "" {
+		sc.MetricsPath = DefaultMetricsPath
+	}
+	if !strings.HasPrefix(sc.MetricsPath, "/") {
+		return fmt.Errorf("metrics_path %q does not start with /", sc.MetricsPath)
+	}
+
+	if sc.Scheme == "" {
+		sc.Scheme = DefaultScheme
+	}
+	if sc.Scheme != "http" && sc.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", sc.Scheme)
+	}
+
+	for _, static := range sc.StaticConfigs {
+		if static == nil {
+			return errors.New("empty static config")
+		}
+		for _, target := range static.Targets {
+			if _, err := url.Parse(sc.Scheme + "://" + target); err != nil || strings.ContainsAny(target, "/?#") {
+				return fmt.Errorf("invalid target %q, expected host:port", target)
+			}
+		}
+		for name := range static.Labels {
+			if !model.LabelName(name).IsValid() || strings.HasPrefix(name, model.ReservedLabelPrefix) {
+				return fmt.Errorf("invalid label name %q", name)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package scrape
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`
+scrape_interval: 15s
+scrape_configs:
+  - job_name: node
+    static_configs:
+      - targets: [localhost:9100, db-1:9100]
+        labels:
+          env: prod
+  - job_name: app
+    scrape_interval: 5s
+    scrape_timeout: 2s
+    metrics_path: /internal/metrics
+    scheme: https
+    static_configs:
+      - targets: [app:8443]
+`))
+	require.NoError(t, err)
+
+	require.Equal(t, &Config{
+		ScrapeInterval: model.Duration(15 * time.Second),
+		ScrapeTimeout:  model.Duration(10 * time.Second),
+		ScrapeConfigs: []*ScrapeConfig{
+			{
+				JobName:        "node",
+				ScrapeInterval: model.Duration(15 * time.Second),
+				ScrapeTimeout:  model.Duration(10 * time.Second),
+				MetricsPath:    "/metrics",
+				Scheme:         "http",
+				StaticConfigs: []*StaticConfig{
+					{Targets: []string{"localhost:9100", "db-1:9100"}, Labels: map[string]string{"env": "prod"}},
+				},
+			},
+			{
+				JobName:        "app",
+				ScrapeInterval: model.Duration(5 * time.Second),
+				ScrapeTimeout:  model.Duration(2 * time.Second),
+				MetricsPath:    "/internal/metrics",
+				Scheme:         "https",
+				StaticConfigs:  []*StaticConfig{{Targets: []string{"app:8443"}}},
+			},
+		},
+	}, cfg)
+
+	// the timeout defaults to at most the interval
+	cfg, err = ParseConfig([]byte("scrape_interval: 5s\nscrape_configs: [{job_name: fast}]"))
+	require.NoError(t, err)
+	require.Equal(t, model.Duration(5*time.Second), cfg.ScrapeConfigs[0].ScrapeTimeout)
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	for _, tc := range []struct {
+		config string
+		err    string
+	}{
+		{"scrape_interval: 5s\nscrape_timeout: 10s", "scrape_timeout 10s is longer than scrape_interval 5s"},
+		{"scrape_configs: [{scrape_interval: 5s}]", "scrape config without job_name"},
+		{"scrape_configs: [{job_name: a}, {job_name: a}]", `duplicate job_name "a"`},
+		{"scrape_configs: [{job_name: a, scheme: ftp}]", `job "a": unsupported scheme "ftp"`},
+		{"scrape_configs: [{job_name: a, metrics_path: metrics}]", `job "a": metrics_path "metrics" does not start with /`},
+		{"scrape_configs: [{job_name: a, static_configs: [{targets: [localhost:9100/metrics]}]}]", `job "a": invalid target "localhost:9100/metrics", expected host:port`},
+		{"scrape_configs: [{job_name: a, static_configs: [{targets: [a:1], labels: {__name__: x}}]}]", `job "a": invalid label name "__name__"`},
+	} {
+		_, err := ParseConfig([]byte(tc.config))
+		require.EqualError(t, err, tc.err, tc.config)
+	}
+
+	_, err := ParseConfig([]byte("scrape_configs: [{job_name: a, honor_labels: true}]"))
+	require.ErrorContains(t, err, "field honor_labels not found")
+}
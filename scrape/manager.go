@@ -0,0 +1,234 @@
+// Package scrape pulls metrics from targets exposing them in the Prometheus
+// text format or OpenMetrics, and appends them to an ftsdb database.
+package scrape
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Marvin9/ftsdb/ftsdb"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
+	"github.com/prometheus/prometheus/model/value"
+	"go.uber.org/zap"
+)
+
+// acceptHeader prefers OpenMetrics over the text format, like Prometheus.
+const acceptHeader = "application/openmetrics-text;version=1.0.0,application/openmetrics-text;version=0.0.1;q=0.75,text/plain;version=0.0.4;q=0.5,*/*;q=0.1"
+
+// Manager scrapes the targets of a config, each on its own interval.
+//
+// Every sample of a scrape is appended with the time the scrape started, and
+// the labels of the target: job, instance and the labels of its static
+// config. Exposed labels clashing with them are kept with the prefix
+// exported_. Every scrape also appends the series up, 1 if the scrape
+// succeeded and 0 if not, and scrape_duration_seconds. A scrape that fails
+// to parse appends none of its samples.
+type Manager struct {
+	db      ftsdb.DBInterface
+	logger  *zap.Logger
+	client  *http.Client
+	targets []*target
+}
+
+type target struct {
+	url      string
+	labels   map[string]string
+	interval time.Duration
+	timeout  time.Duration
+}
+
+type sample struct {
+	metric string
+	series map[string]string
+	value  float64
+}
+
+func NewManager(logger *zap.Logger, db ftsdb.DBInterface, cfg *Config) *Manager {
+	m := &Manager{
+		db:     db,
+		logger: logger,
+		client: &http.Client{},
+	}
+
+	for _, sc := range cfg.ScrapeConfigs {
+		for _, static := range sc.StaticConfigs {
+			for _, address := range static.Targets {
+				t := &target{
+					url:      (&url.URL{Scheme: sc.Scheme, Host: address, Path: sc.MetricsPath}).String(),
+					labels:   map[string]string{},
+					interval: time.Duration(sc.ScrapeInterval),
+					timeout:  time.Duration(sc.ScrapeTimeout),
+				}
+				for name, value := range static.Labels {
+					t.labels[name] = value
+				}
+				t.labels[model.JobLabel] = sc.JobName
+				t.labels[model.InstanceLabel] = address
+
+				m.targets = append(m.targets, t)
+			}
+		}
+	}
+
+	return m
+}
+
+// Run scrapes the targets until ctx is done, and returns once the scrapes in
+// progress are appended.
+func (m *Manager) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for _, t := range m.targets {
+		wg.Add(1)
+		go func(t *target) {
+			defer wg.Done()
+			m.loop(ctx, t)
+		}(t)
+	}
+
+	wg.Wait()
+}
+
+func (m *Manager) loop(ctx context.Context, t *target) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		m.scrape(ctx, t, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scrape scrapes t once, appending its samples with the timestamp start.
+func (m *Manager) scrape(ctx context.Context, t *target, start time.Time) {
+	timestamp := start.UnixMilli()
+
+	samples, err := m.fetch(ctx, t)
+	duration := time.Since(start).Seconds()
+	if ctx.Err() != nil {
+		// stopped, not a failed target
+		return
+	}
+
+	up := 1.0
+	if err != nil {
+		up = 0
+		m.logger.Warn("scrape failed", zap.String("target", t.url), zap.Error(err))
+	}
+
+	failed := 0
+	var first error
+	for _, s := range samples {
+		if err := m.db.CreateMetric(s.metric).Append(s.series, timestamp, s.value); err != nil {
+			failed++
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	if failed > 0 {
+		m.logger.Warn("appending scraped samples", zap.String("target", t.url), zap.Int("failed", failed), zap.Error(first))
+	}
+
+	for metric, value := range map[string]float64{
+		"up":                      up,
+		"scrape_duration_seconds": duration,
+	} {
+		if err := m.db.CreateMetric(metric).Append(t.labels, timestamp, value); err != nil {
+			m.logger.Warn("appending scrape report", zap.String("target", t.url), zap.String("metric", metric), zap.Error(err))
+		}
+	}
+}
+
+func (m *Manager) fetch(ctx context.Context, t *target) ([]sample, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", acceptHeader)
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", fmt.Sprintf("%g", t.timeout.Seconds()))
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned HTTP status %s", resp.Status)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return parse(b, resp.Header.Get("Content-Type"), t.labels)
+}
+
+// parse parses an exposition of the given content type, adding the target
+// labels to every sample.
+func parse(b []byte, contentType string, targetLabels map[string]string) ([]sample, error) {
+	// an unknown content type falls back to the text format
+	parser, _ := textparse.New(b, contentType, false)
+
+	samples := []sample{}
+	for {
+		entry, err := parser.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if entry != textparse.EntrySeries {
+			continue
+		}
+
+		_, _, v := parser.Series()
+		if value.IsStaleNaN(v) {
+			continue
+		}
+
+		var lset labels.Labels
+		parser.Metric(&lset)
+
+		series := map[string]string{}
+		lset.Range(func(l labels.Label) {
+			if l.Name != labels.MetricName {
+				series[l.Name] = l.Value
+			}
+		})
+
+		for name, value := range targetLabels {
+			if exposed, found := series[name]; found {
+				series["exported_"+name] = exposed
+			}
+			series[name] = value
+		}
+
+		samples = append(samples, sample{
+			metric: lset.Get(labels.MetricName),
+			series: series,
+			value:  v,
+		})
+	}
+
+	return samples, nil
+}
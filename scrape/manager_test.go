@@ -0,0 +1,181 @@
+package scrape
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Marvin9/ftsdb/ftsdb"
+	"github.com/Marvin9/ftsdb/ftsdb/ftsdbtest"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const textExposition = `# HELP http_requests_total Requests served.
+# TYPE http_requests_total counter
+http_requests_total{code="200",job="exposed"} 1027
+http_requests_total{code="500"} 3 1000
+# TYPE temperature gauge
+temperature 21.5
+`
+
+const openMetricsExposition = `# TYPE http_requests counter
+# UNIT http_requests requests
+http_requests_total{code="200"} 1027
+http_requests_created{code="200"} 1700000000
+# TYPE latency histogram
+latency_bucket{le="0.5"} 4
+latency_bucket{le="+Inf"} 5
+latency_count 5
+latency_sum 2.5
+# EOF
+`
+
+// exposer serves body with contentType, or fails if status is not 200.
+func exposer(t *testing.T, contentType, body string, status int) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/metrics", r.URL.Path)
+		require.Contains(t, r.Header.Get("Accept"), "application/openmetrics-text")
+
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func managerFixture(t *testing.T, interval string, servers ...*httptest.Server) (ftsdb.DBInterface, *Manager) {
+	db := ftsdbtest.NewDB(t)
+
+	targets := []string{}
+	for _, srv := range servers {
+		targets = append(targets, srv.Listener.Addr().String())
+	}
+
+	cfg, err := ParseConfig([]byte(fmt.Sprintf(`
+scrape_configs:
+  - job_name: app
+    scrape_interval: %s
+    static_configs:
+      - targets: [%s]
+        labels:
+          env: prod
+`, interval, strings.Join(targets, ", "))))
+	require.NoError(t, err)
+
+	return db, NewManager(zap.NewNop(), db, cfg)
+}
+
+func TestScrapeText(t *testing.T) {
+	srv := exposer(t, "text/plain; version=0.0.4", textExposition, http.StatusOK)
+	db, m := managerFixture(t, "1m", srv)
+	instance := srv.Listener.Addr().String()
+
+	m.scrape(context.Background(), m.targets[0], time.UnixMilli(5000))
+
+	// exposed timestamps are ignored, samples get the one of the scrape
+	require.Equal(t, map[string][]ftsdb.Datapoint{
+		`{code="200", env="prod", exported_job="exposed", instance="` + instance + `", job="app"}`: {{Timestamp: 5000, Value: 1027}},
+		`{code="500", env="prod", instance="` + instance + `", job="app"}`:                         {{Timestamp: 5000, Value: 3}},
+	}, ftsdbtest.Collect(t, db, "http_requests_total"))
+	require.Equal(t, map[string][]ftsdb.Datapoint{
+		`{env="prod", instance="` + instance + `", job="app"}`: {{Timestamp: 5000, Value: 21.5}},
+	}, ftsdbtest.Collect(t, db, "temperature"))
+	require.Equal(t, map[string][]ftsdb.Datapoint{
+		`{env="prod", instance="` + instance + `", job="app"}`: {{Timestamp: 5000, Value: 1}},
+	}, ftsdbtest.Collect(t, db, "up"))
+
+	durations := ftsdbtest.Collect(t, db, "scrape_duration_seconds")[`{env="prod", instance="`+instance+`", job="app"}`]
+	require.Len(t, durations, 1)
+	require.Greater(t, durations[0].Value, 0.0)
+}
+
+func TestScrapeOpenMetrics(t *testing.T) {
+	srv := exposer(t, "application/openmetrics-text; version=1.0.0; charset=utf-8", openMetricsExposition, http.StatusOK)
+	db, m := managerFixture(t, "1m", srv)
+	instance := srv.Listener.Addr().String()
+
+	m.scrape(context.Background(), m.targets[0], time.UnixMilli(5000))
+
+	for metric, expected := range map[string]map[string][]ftsdb.Datapoint{
+		"http_requests_total": {
+			`{code="200", env="prod", instance="` + instance + `", job="app"}`: {{Timestamp: 5000, Value: 1027}},
+		},
+		"http_requests_created": {
+			`{code="200", env="prod", instance="` + instance + `", job="app"}`: {{Timestamp: 5000, Value: 1700000000}},
+		},
+		"latency_bucket": {
+			`{env="prod", instance="` + instance + `", job="app", le="0.5"}`:  {{Timestamp: 5000, Value: 4}},
+			`{env="prod", instance="` + instance + `", job="app", le="+Inf"}`: {{Timestamp: 5000, Value: 5}},
+		},
+		"latency_count": {
+			`{env="prod", instance="` + instance + `", job="app"}`: {{Timestamp: 5000, Value: 5}},
+		},
+		"latency_sum": {
+			`{env="prod", instance="` + instance + `", job="app"}`: {{Timestamp: 5000, Value: 2.5}},
+		},
+		"up": {
+			`{env="prod", instance="` + instance + `", job="app"}`: {{Timestamp: 5000, Value: 1}},
+		},
+	} {
+		require.Equal(t, expected, ftsdbtest.Collect(t, db, metric), metric)
+	}
+}
+
+func TestScrapeFailed(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "status", body: textExposition, status: http.StatusInternalServerError},
+		{name: "invalid exposition", body: "temperature 21.5\ntemperature{ 1\n", status: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := exposer(t, "text/plain; version=0.0.4", tc.body, tc.status)
+			db, m := managerFixture(t, "1m", srv)
+
+			m.scrape(context.Background(), m.targets[0], time.UnixMilli(5000))
+
+			require.Equal(t, map[string][]ftsdb.Datapoint{
+				`{env="prod", instance="` + srv.Listener.Addr().String() + `", job="app"}`: {{Timestamp: 5000, Value: 0}},
+			}, ftsdbtest.Collect(t, db, "up"))
+			require.Empty(t, ftsdbtest.Collect(t, db, "temperature"))
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	srv1 := exposer(t, "text/plain; version=0.0.4", textExposition, http.StatusOK)
+	srv2 := exposer(t, "text/plain; version=0.0.4", textExposition, http.StatusOK)
+	db, m := managerFixture(t, "20ms", srv1, srv2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		up := ftsdbtest.Collect(t, db, "up")
+		if len(up) != 2 {
+			return false
+		}
+		for _, datapoints := range up {
+			if len(datapoints) < 2 {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,463 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/util/annotations"
+	"go.uber.org/zap"
+)
+
+// maxPoints is the most steps a range query may have per series, the same
+// limit Prometheus enforces.
+const maxPoints = 11000
+
+type errorType string
+
+const (
+	errorBadData  errorType = "bad_data"
+	errorExec     errorType = "execution"
+	errorCanceled errorType = "canceled"
+	errorTimeout  errorType = "timeout"
+	errorInternal errorType = "internal"
+	errorNotFound errorType = "not_found"
+)
+
+type apiError struct {
+	typ errorType
+	err error
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s: %s", e.typ, e.err)
+}
+
+func badData(err error) *apiError {
+	return &apiError{typ: errorBadData, err: err}
+}
+
+type response struct {
+	Status    string      `json:"status"`
+	Data      interface{} `json:"data,omitempty"`
+	ErrorType errorType   `json:"errorType,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	Warnings  []string    `json:"warnings,omitempty"`
+}
+
+type queryData struct {
+	ResultType parser.ValueType `json:"resultType"`
+	Result     interface{}      `json:"result"`
+}
+
+// matrixSeries is the JSON form of a series of a matrix result.
+type matrixSeries struct {
+	Metric labels.Labels   `json:"metric"`
+	Values []promql.FPoint `json:"values"`
+}
+
+func (s *Server) query(w http.ResponseWriter, r *http.Request) {
+	ts, err := parseTimeParam(r, "time", time.Now())
+	if err != nil {
+		s.respondError(w, badData(err))
+		return
+	}
+
+	ctx, cancel, err := queryContext(r)
+	if err != nil {
+		s.respondError(w, badData(err))
+		return
+	}
+	defer cancel()
+
+	query, err := s.engine.NewInstantQuery(ctx, s.queryable, nil, r.FormValue("query"), ts)
+	if err != nil {
+		s.respondError(w, badData(err))
+		return
+	}
+
+	s.respondQuery(ctx, w, query)
+}
+
+func (s *Server) queryRange(w http.ResponseWriter, r *http.Request) {
+	start, err := parseTime(r.FormValue("start"))
+	if err != nil {
+		s.respondError(w, badData(fmt.Errorf("invalid parameter \"start\": %w", err)))
+		return
+	}
+	end, err := parseTime(r.FormValue("end"))
+	if err != nil {
+		s.respondError(w, badData(fmt.Errorf("invalid parameter \"end\": %w", err)))
+		return
+	}
+	if end.Before(start) {
+		s.respondError(w, badData(errors.New("end timestamp must not be before start time")))
+		return
+	}
+
+	step, err := parseDuration(r.FormValue("step"))
+	if err != nil {
+		s.respondError(w, badData(fmt.Errorf("invalid parameter \"step\": %w", err)))
+		return
+	}
+	if step <= 0 {
+		s.respondError(w, badData(errors.New("zero or negative query resolution step widths are not accepted. Try a positive integer")))
+		return
+	}
+	if end.Sub(start)/step > maxPoints {
+		s.respondError(w, badData(errors.New("exceeded maximum resolution of 11,000 points per timeseries. Try decreasing the query resolution (?step=XX)")))
+		return
+	}
+
+	ctx, cancel, err := queryContext(r)
+	if err != nil {
+		s.respondError(w, badData(err))
+		return
+	}
+	defer cancel()
+
+	query, err := s.engine.NewRangeQuery(ctx, s.queryable, nil, r.FormValue("query"), start, end, step)
+	if err != nil {
+		s.respondError(w, badData(err))
+		return
+	}
+
+	s.respondQuery(ctx, w, query)
+}
+
+func (s *Server) respondQuery(ctx context.Context, w http.ResponseWriter, query promql.Query) {
+	defer query.Close()
+
+	result := query.Exec(ctx)
+	if result.Err != nil {
+		s.respondError(w, queryError(result.Err))
+		return
+	}
+
+	data := queryData{
+		ResultType: result.Value.Type(),
+		Result:     result.Value,
+	}
+
+	switch value := result.Value.(type) {
+	case promql.Matrix:
+		series := make([]matrixSeries, len(value))
+		for idx, s := range value {
+			series[idx] = matrixSeries{Metric: s.Metric, Values: s.Floats}
+		}
+		data.Result = series
+	case promql.Vector:
+		if value == nil {
+			data.Result = promql.Vector{}
+		}
+	}
+
+	s.respond(w, data, result.Warnings)
+}
+
+func (s *Server) series(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.respondError(w, badData(err))
+		return
+	}
+	if len(r.Form["match[]"]) == 0 {
+		s.respondError(w, badData(errors.New("no match[] parameter provided")))
+		return
+	}
+
+	matcherSets, err := parseMatchersParam(r.Form["match[]"])
+	if err != nil {
+		s.respondError(w, badData(err))
+		return
+	}
+
+	mint, maxt, err := timeRange(r)
+	if err != nil {
+		s.respondError(w, badData(err))
+		return
+	}
+
+	querier, err := s.queryable.Querier(mint, maxt)
+	if err != nil {
+		s.respondError(w, &apiError{typ: errorInternal, err: err})
+		return
+	}
+	defer querier.Close()
+
+	found := map[string]labels.Labels{}
+	warnings := annotations.Annotations{}
+
+	hints := &storage.SelectHints{Start: mint, End: maxt, Func: "series"}
+	for _, matchers := range matcherSets {
+		set := querier.Select(r.Context(), false, hints, matchers...)
+		for set.Next() {
+			ls := set.At().Labels()
+			found[ls.String()] = ls
+		}
+		warnings.Merge(set.Warnings())
+		if err := set.Err(); err != nil {
+			s.respondError(w, queryError(err))
+			return
+		}
+	}
+
+	result := make([]labels.Labels, 0, len(found))
+	for _, ls := range found {
+		result = append(result, ls)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return labels.Compare(result[i], result[j]) < 0
+	})
+
+	s.respond(w, result, warnings)
+}
+
+func (s *Server) labelNames(w http.ResponseWriter, r *http.Request) {
+	s.labels(w, r, func(ctx context.Context, querier storage.Querier, matchers []*labels.Matcher) ([]string, annotations.Annotations, error) {
+		return querier.LabelNames(ctx, matchers...)
+	})
+}
+
+func (s *Server) labelValues(w http.ResponseWriter, r *http.Request) {
+	name, found := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/label/"), "/values")
+	if !found || strings.Contains(name, "/") {
+		s.respondError(w, &apiError{typ: errorNotFound, err: errors.New("not found")})
+		return
+	}
+	if !model.LabelName(name).IsValid() {
+		s.respondError(w, badData(fmt.Errorf("invalid label name: %q", name)))
+		return
+	}
+
+	s.labels(w, r, func(ctx context.Context, querier storage.Querier, matchers []*labels.Matcher) ([]string, annotations.Annotations, error) {
+		return querier.LabelValues(ctx, name, matchers...)
+	})
+}
+
+// labels responds with the union of what list returns for every match[]
+// parameter, or for all series when there is none.
+func (s *Server) labels(w http.ResponseWriter, r *http.Request, list func(context.Context, storage.Querier, []*labels.Matcher) ([]string, annotations.Annotations, error)) {
+	if err := r.ParseForm(); err != nil {
+		s.respondError(w, badData(err))
+		return
+	}
+
+	matcherSets := [][]*labels.Matcher{nil}
+	if len(r.Form["match[]"]) > 0 {
+		var err error
+		matcherSets, err = parseMatchersParam(r.Form["match[]"])
+		if err != nil {
+			s.respondError(w, badData(err))
+			return
+		}
+	}
+
+	mint, maxt, err := timeRange(r)
+	if err != nil {
+		s.respondError(w, badData(err))
+		return
+	}
+
+	querier, err := s.queryable.Querier(mint, maxt)
+	if err != nil {
+		s.respondError(w, &apiError{typ: errorInternal, err: err})
+		return
+	}
+	defer querier.Close()
+
+	found := map[string]struct{}{}
+	warnings := annotations.Annotations{}
+
+	for _, matchers := range matcherSets {
+		values, ws, err := list(r.Context(), querier, matchers)
+		if err != nil {
+			s.respondError(w, queryError(err))
+			return
+		}
+		warnings.Merge(ws)
+
+		for _, value := range values {
+			found[value] = struct{}{}
+		}
+	}
+
+	result := make([]string, 0, len(found))
+	for value := range found {
+		result = append(result, value)
+	}
+	sort.Strings(result)
+
+	s.respond(w, result, warnings)
+}
+
+// timeRange returns the start and end parameters in milliseconds. They
+// default to all of time.
+func timeRange(r *http.Request) (int64, int64, error) {
+	mint, maxt := int64(math.MinInt64), int64(math.MaxInt64)
+
+	if r.FormValue("start") != "" {
+		start, err := parseTimeParam(r, "start", time.Time{})
+		if err != nil {
+			return 0, 0, err
+		}
+		mint = start.UnixMilli()
+	}
+	if r.FormValue("end") != "" {
+		end, err := parseTimeParam(r, "end", time.Time{})
+		if err != nil {
+			return 0, 0, err
+		}
+		maxt = end.UnixMilli()
+	}
+
+	return mint, maxt, nil
+}
+
+func (s *Server) respond(w http.ResponseWriter, data interface{}, warnings annotations.Annotations) {
+	s.writeJSON(w, http.StatusOK, response{
+		Status:   "success",
+		Data:     data,
+		Warnings: warnings.AsStrings("", 0),
+	})
+}
+
+func (s *Server) respondError(w http.ResponseWriter, apiErr *apiError) {
+	code := http.StatusInternalServerError
+	switch apiErr.typ {
+	case errorBadData:
+		code = http.StatusBadRequest
+	case errorExec:
+		code = http.StatusUnprocessableEntity
+	case errorCanceled:
+		code = 499
+	case errorTimeout:
+		code = http.StatusServiceUnavailable
+	case errorNotFound:
+		code = http.StatusNotFound
+	}
+
+	s.writeJSON(w, code, response{
+		Status:    "error",
+		ErrorType: apiErr.typ,
+		Error:     apiErr.err.Error(),
+	})
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, code int, resp response) {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		s.logger.Error("encoding response", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if _, err := w.Write(b); err != nil {
+		s.logger.Warn("writing response", zap.Error(err))
+	}
+}
+
+func queryError(err error) *apiError {
+	switch {
+	case errors.As(err, new(promql.ErrQueryCanceled)), errors.Is(err, context.Canceled):
+		return &apiError{typ: errorCanceled, err: err}
+	case errors.As(err, new(promql.ErrQueryTimeout)), errors.Is(err, context.DeadlineExceeded):
+		return &apiError{typ: errorTimeout, err: err}
+	case errors.As(err, new(promql.ErrStorage)):
+		return &apiError{typ: errorInternal, err: err}
+	}
+	return &apiError{typ: errorExec, err: err}
+}
+
+// queryContext applies the optional timeout parameter to the request context.
+func queryContext(r *http.Request) (context.Context, context.CancelFunc, error) {
+	if r.FormValue("timeout") == "" {
+		ctx, cancel := context.WithCancel(r.Context())
+		return ctx, cancel, nil
+	}
+
+	timeout, err := parseDuration(r.FormValue("timeout"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid parameter \"timeout\": %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	return ctx, cancel, nil
+}
+
+func parseTimeParam(r *http.Request, name string, defaultValue time.Time) (time.Time, error) {
+	if r.FormValue(name) == "" {
+		return defaultValue, nil
+	}
+
+	t, err := parseTime(r.FormValue(name))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid parameter %q: %w", name, err)
+	}
+	return t, nil
+}
+
+// parseTime accepts unix timestamps in seconds, with an optional fraction,
+// and RFC 3339 times.
+func parseTime(s string) (time.Time, error) {
+	if t, err := strconv.ParseFloat(s, 64); err == nil {
+		sec, frac := math.Modf(t)
+		frac = math.Round(frac*1000) / 1000
+		return time.Unix(int64(sec), int64(frac*float64(time.Second))).UTC(), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q to a valid timestamp", s)
+}
+
+// parseDuration accepts seconds, with an optional fraction, and Prometheus
+// durations like 5m.
+func parseDuration(s string) (time.Duration, error) {
+	if d, err := strconv.ParseFloat(s, 64); err == nil {
+		ts := d * float64(time.Second)
+		if ts > float64(math.MaxInt64) || ts < float64(math.MinInt64) {
+			return 0, fmt.Errorf("cannot parse %q to a valid duration. It overflows int64", s)
+		}
+		return time.Duration(ts), nil
+	}
+	if d, err := model.ParseDuration(s); err == nil {
+		return time.Duration(d), nil
+	}
+	return 0, fmt.Errorf("cannot parse %q to a valid duration", s)
+}
+
+func parseMatchersParam(matchers []string) ([][]*labels.Matcher, error) {
+	matcherSets, err := parser.ParseMetricSelectors(matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ms := range matcherSets {
+		empty := true
+		for _, m := range ms {
+			if !m.Matches("") {
+				empty = false
+				break
+			}
+		}
+		if empty {
+			return nil, errors.New("match[] must contain at least one non-empty matcher")
+		}
+	}
+
+	return matcherSets, nil
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"testing"
+
+	"github.com/Marvin9/ftsdb/ftsdb"
+	"github.com/stretchr/testify/require"
+)
+
+func apiFixture(t *testing.T) (ftsdb.DBInterface, *httptest.Server) {
+	db, srv := newTestServer(t)
+
+	cpu := db.CreateMetric("cpu")
+	mem := db.CreateMetric("mem")
+
+	// a datapoint every 10s from 1000s to 1100s
+	for ts := int64(1000000); ts <= 1100000; ts += 10000 {
+		require.NoError(t, cpu.Append(map[string]string{"host": "web-1", "region": "eu"}, ts, float64(ts/1000)))
+		require.NoError(t, cpu.Append(map[string]string{"host": "web-2"}, ts, 2*float64(ts/1000)))
+		require.NoError(t, mem.Append(map[string]string{"host": "web-1"}, ts, 1))
+	}
+
+	return db, srv
+}
+
+func get(t *testing.T, srv *httptest.Server, path string, params url.Values) (int, string) {
+	resp, err := http.Get(srv.URL + path + "?" + params.Encode())
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	return resp.StatusCode, string(body)
+}
+
+func TestQuery(t *testing.T) {
+	_, srv := apiFixture(t)
+
+	code, body := get(t, srv, "/api/v1/query", url.Values{"query": {"cpu"}, "time": {"1050.5"}})
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{
+		"status": "success",
+		"data": {
+			"resultType": "vector",
+			"result": [
+				{"metric": {"__name__": "cpu", "host": "web-1", "region": "eu"}, "value": [1050.5, "1050"]},
+				{"metric": {"__name__": "cpu", "host": "web-2"}, "value": [1050.5, "2100"]}
+			]
+		}
+	}`, sortedResult(t, body))
+
+	code, body = get(t, srv, "/api/v1/query", url.Values{"query": {"1 + 1"}, "time": {"1000"}})
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{"status": "success", "data": {"resultType": "scalar", "result": [1000, "2"]}}`, body)
+
+	code, body = get(t, srv, "/api/v1/query", url.Values{"query": {"cpu"}, "time": {"1"}})
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{"status": "success", "data": {"resultType": "vector", "result": []}}`, body)
+
+	// POSTed forms are accepted too, like Grafana sends them
+	resp, err := http.PostForm(srv.URL+"/api/v1/query", url.Values{"query": {"sum(mem)"}, "time": {"1100"}})
+	require.NoError(t, err)
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.JSONEq(t, `{"status": "success", "data": {"resultType": "vector", "result": [{"metric": {}, "value": [1100, "1"]}]}}`, string(b))
+}
+
+func TestQueryRange(t *testing.T) {
+	_, srv := apiFixture(t)
+
+	code, body := get(t, srv, "/api/v1/query_range", url.Values{
+		"query": {`delta(cpu{host="web-2"}[30s])`},
+		"start": {"1060"},
+		"end":   {"1080"},
+		"step":  {"10s"},
+	})
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{
+		"status": "success",
+		"data": {
+			"resultType": "matrix",
+			"result": [
+				{"metric": {"host": "web-2"}, "values": [[1060, "60"], [1070, "60"], [1080, "60"]]}
+			]
+		}
+	}`, body)
+
+	for _, tc := range []struct {
+		params url.Values
+		err    string
+	}{
+		{url.Values{"query": {"cpu"}, "start": {"x"}, "end": {"1"}, "step": {"1"}}, `invalid parameter \"start\"`},
+		{url.Values{"query": {"cpu"}, "start": {"2"}, "end": {"1"}, "step": {"1"}}, "end timestamp must not be before start time"},
+		{url.Values{"query": {"cpu"}, "start": {"1"}, "end": {"2"}, "step": {"0"}}, "zero or negative query resolution step"},
+		{url.Values{"query": {"cpu"}, "start": {"0"}, "end": {"100000"}, "step": {"1"}}, "exceeded maximum resolution"},
+		{url.Values{"query": {"cpu{"}, "start": {"1"}, "end": {"2"}, "step": {"1"}}, "parse error"},
+	} {
+		code, body := get(t, srv, "/api/v1/query_range", tc.params)
+		require.Equal(t, http.StatusBadRequest, code, body)
+		require.Contains(t, body, `"status":"error","errorType":"bad_data"`)
+		require.Contains(t, body, tc.err)
+	}
+}
+
+func TestSeries(t *testing.T) {
+	_, srv := apiFixture(t)
+
+	code, body := get(t, srv, "/api/v1/series", url.Values{"match[]": {`{host="web-1"}`, `cpu{region="eu"}`}})
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{
+		"status": "success",
+		"data": [
+			{"__name__": "cpu", "host": "web-1", "region": "eu"},
+			{"__name__": "mem", "host": "web-1"}
+		]
+	}`, body)
+
+	// no datapoint in range
+	code, body = get(t, srv, "/api/v1/series", url.Values{"match[]": {"cpu"}, "start": {"0"}, "end": {"999"}})
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{"status": "success", "data": []}`, body)
+
+	code, body = get(t, srv, "/api/v1/series", url.Values{})
+	require.Equal(t, http.StatusBadRequest, code)
+	require.Contains(t, body, "no match[] parameter provided")
+
+	code, body = get(t, srv, "/api/v1/series", url.Values{"match[]": {`{host=~".*"}`}})
+	require.Equal(t, http.StatusBadRequest, code)
+	require.Contains(t, body, "at least one non-empty matcher")
+}
+
+func TestLabels(t *testing.T) {
+	_, srv := apiFixture(t)
+
+	code, body := get(t, srv, "/api/v1/labels", url.Values{})
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{"status": "success", "data": ["__name__", "host", "region"]}`, body)
+
+	code, body = get(t, srv, "/api/v1/labels", url.Values{"match[]": {"mem"}})
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{"status": "success", "data": ["__name__", "host"]}`, body)
+
+	code, body = get(t, srv, "/api/v1/label/host/values", url.Values{})
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{"status": "success", "data": ["web-1", "web-2"]}`, body)
+
+	code, body = get(t, srv, "/api/v1/label/__name__/values", url.Values{"match[]": {`{host="web-2"}`, `{region="eu"}`}})
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{"status": "success", "data": ["cpu"]}`, body)
+
+	code, body = get(t, srv, "/api/v1/label/host/values", url.Values{"start": {"2000"}})
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{"status": "success", "data": []}`, body)
+
+	code, _ = get(t, srv, "/api/v1/label/host-name/values", url.Values{})
+	require.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = get(t, srv, "/api/v1/label/host", url.Values{})
+	require.Equal(t, http.StatusNotFound, code)
+}
+
+// sortedResult orders the result of a vector response by host, as vectors
+// come in the order series were found in.
+func sortedResult(t *testing.T, body string) string {
+	var resp struct {
+		Status string `json:"status"`
+		Data   struct {
+			ResultType string `json:"resultType"`
+			Result     []struct {
+				Metric map[string]string `json:"metric"`
+				Value  []interface{}     `json:"value"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &resp))
+
+	result := resp.Data.Result
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Metric["host"] < result[j].Metric["host"]
+	})
+
+	b, err := json.Marshal(resp)
+	require.NoError(t, err)
+	return string(b)
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Marvin9/ftsdb/influx"
+	"github.com/prometheus/prometheus/util/strutil"
+	"go.uber.org/zap"
+)
+
+// maxReportedErrors is the most failures the response to a write lists.
+const maxReportedErrors = 100
+
+// influxWrite receives InfluxDB line protocol like the /write endpoint of
+// InfluxDB 1.x. Every field of a line becomes a datapoint of the metric
+// <measurement>_<field>, with the tags as labels. Names are sanitized into
+// valid Prometheus names so that they can be queried with PromQL.
+//
+// Lines that can not be parsed or appended do not stop the others from
+// being written. They are listed in the error of a 400 response. Bodies over
+// the max request size, gzipped or not, are rejected with a 413.
+func (s *Server) influxWrite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		s.respondInfluxError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	precision, err := influx.ParsePrecision(r.URL.Query().Get("precision"))
+	if err != nil {
+		s.respondInfluxError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	data, err := s.readBody(w, r)
+	if err != nil {
+		s.respondInfluxError(w, bodyErrorCode(err), err.Error())
+		return
+	}
+
+	points, lineErrors := influx.Parse(data, precision, time.Now())
+
+	failures := []string{}
+	for _, lineErr := range lineErrors {
+		failures = append(failures, lineErr.Error())
+	}
+
+	written := 0
+	for _, point := range points {
+		if err := s.appendInfluxPoint(point); err != nil {
+			failures = append(failures, fmt.Sprintf("line %d: %s", point.Line, err))
+			continue
+		}
+		written++
+	}
+
+	if len(failures) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	s.logger.Warn("rejected influx lines", zap.Int("written", written), zap.Int("failed", len(failures)))
+
+	if len(failures) > maxReportedErrors {
+		failures = append(failures[:maxReportedErrors], fmt.Sprintf("and %d more", len(failures)-maxReportedErrors))
+	}
+
+	msg := strings.Join(failures, "\n")
+	if written > 0 {
+		msg = "partial write: " + msg
+	}
+	s.respondInfluxError(w, http.StatusBadRequest, msg)
+}
+
+// appendInfluxPoint appends every field of point, carrying on past fields
+// that fail and returning the first error.
+func (s *Server) appendInfluxPoint(point influx.Point) error {
+	series := make(map[string]string, len(point.Tags))
+	for key, value := range point.Tags {
+		if value != "" {
+			series[strutil.SanitizeFullLabelName(key)] = value
+		}
+	}
+
+	var first error
+	for field, value := range point.Fields {
+		metric := strutil.SanitizeFullLabelName(point.Measurement + "_" + field)
+
+		if err := s.db.CreateMetric(metric).Append(series, point.Timestamp, value); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+func (s *Server) respondInfluxError(w http.ResponseWriter, code int, msg string) {
+	b, err := json.Marshal(map[string]string{"error": msg})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if _, err := w.Write(b); err != nil {
+		s.logger.Warn("writing response", zap.Error(err))
+	}
+}
+
+// influxPing lets InfluxDB clients check that the server is up.
+func (s *Server) influxPing(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeLines(t *testing.T, srv *httptest.Server, precision string, body io.Reader, gzipped bool) (int, string) {
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/write?db=telegraf&precision="+precision, body)
+	require.NoError(t, err)
+	if gzipped {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp.StatusCode, string(b)
+}
+
+func TestInfluxWrite(t *testing.T) {
+	_, srv := newTestServer(t)
+
+	code, body := writeLines(t, srv, "s", strings.NewReader(
+		"cpu,host=web-1,cpu=cpu0 usage_idle=90,usage_user=10i 1000\n"+
+			"cpu,host=web-1,cpu=cpu0 usage_idle=80,usage_user=20i 1010\n"+
+			`disk.io,host=web-1,dev\ name=sda reads=5u,label="ignored" 1000`+"\n",
+	), false)
+	require.Equal(t, http.StatusNoContent, code, body)
+
+	code, body = get(t, srv, "/api/v1/series", url.Values{"match[]": {`{host="web-1"}`}})
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{
+		"status": "success",
+		"data": [
+			{"__name__": "cpu_usage_idle", "cpu": "cpu0", "host": "web-1"},
+			{"__name__": "cpu_usage_user", "cpu": "cpu0", "host": "web-1"},
+			{"__name__": "disk_io_reads", "dev_name": "sda", "host": "web-1"}
+		]
+	}`, body)
+
+	code, body = get(t, srv, "/api/v1/query", url.Values{"query": {"cpu_usage_user"}, "time": {"1010"}})
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, body, `"value":[1010,"20"]`)
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	_, err := gz.Write([]byte("mem,host=web-1 used=512 1020000"))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	code, body = writeLines(t, srv, "ms", &gzipped, true)
+	require.Equal(t, http.StatusNoContent, code, body)
+
+	code, body = get(t, srv, "/api/v1/query", url.Values{"query": {"mem_used"}, "time": {"1020"}})
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, body, `"value":[1020,"512"]`)
+}
+
+func TestInfluxWritePartialFailure(t *testing.T) {
+	_, srv := newTestServer(t)
+
+	code, body := writeLines(t, srv, "s", strings.NewReader(
+		"cpu,host=web-1 usage=1 1000\n"+
+			"cpu,host=web-1 usage=oops 1010\n"+
+			"cpu,host=web-1 usage=2 1020\n"+
+			"cpu,host=web-1 usage=3 1015\n"+
+			"cpu host=web-1\n",
+	), false)
+	require.Equal(t, http.StatusBadRequest, code)
+	require.JSONEq(t, `{"error": "partial write: `+
+		`unable to parse 'cpu,host=web-1 usage=oops 1010': invalid value of field usage: invalid number \"oops\"\n`+
+		`unable to parse 'cpu host=web-1': invalid value of field host: invalid number \"web-1\"\n`+
+		`line 4: out of order sample: 1015000 is older than 1020000 of cpu_usage map[host:web-1]"}`, body)
+
+	code, body = get(t, srv, "/api/v1/query", url.Values{"query": {"count_over_time(cpu_usage[1h])"}, "time": {"1020"}})
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, body, `"value":[1020,"2"]`)
+
+	// nothing written
+	code, body = writeLines(t, srv, "s", strings.NewReader("cpu"), false)
+	require.Equal(t, http.StatusBadRequest, code)
+	require.JSONEq(t, `{"error": "unable to parse 'cpu': missing fields"}`, body)
+
+	// only string fields, nothing to store
+	code, body = writeLines(t, srv, "s", strings.NewReader(`cpu,host=web-1 state="down" 1030`), false)
+	require.Equal(t, http.StatusBadRequest, code)
+	require.JSONEq(t, `{"error": "unable to parse 'cpu,host=web-1 state=\"down\" 1030': no numeric fields"}`, body)
+
+	code, body = writeLines(t, srv, "d", strings.NewReader("cpu v=1"), false)
+	require.Equal(t, http.StatusBadRequest, code)
+	require.JSONEq(t, `{"error": "invalid precision \"d\""}`, body)
+
+	resp, err := http.Get(srv.URL + "/ping")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
+
+func TestInfluxWriteMaxRequestSize(t *testing.T) {
+	_, srv := newTestServer(t)
+	srv.Config.Handler.(*Server).SetMaxRequestSize(1024)
+
+	line := "cpu,host=web-1 usage=1 1000\n"
+	lines := strings.Repeat(line, 100)
+
+	code, body := writeLines(t, srv, "s", strings.NewReader(lines), false)
+	require.Equal(t, http.StatusRequestEntityTooLarge, code)
+	require.JSONEq(t, `{"error": "http: request body too large"}`, body)
+
+	// small gzipped, but over the limit once decompressed
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	_, err := gz.Write([]byte(lines))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	require.Less(t, gzipped.Len(), 1024)
+
+	code, body = writeLines(t, srv, "s", &gzipped, true)
+	require.Equal(t, http.StatusRequestEntityTooLarge, code)
+	require.JSONEq(t, `{"error": "http: request body too large"}`, body)
+
+	code, body = writeLines(t, srv, "s", strings.NewReader(strings.Repeat(line, 10)), false)
+	require.Equal(t, http.StatusNoContent, code, body)
+}
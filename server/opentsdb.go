@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Marvin9/ftsdb/opentsdb"
+	"go.uber.org/zap"
+)
+
+// openTSDBPut receives datapoints like the /api/put endpoint of OpenTSDB.
+// Datapoints that can not be parsed or appended do not stop the others from
+// being written. They are listed in the body of a 400 response, together
+// with how many were written. Bodies over the max request size, gzipped or
+// not, are rejected with a 413.
+func (s *Server) openTSDBPut(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := s.readBody(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), bodyErrorCode(err))
+		return
+	}
+
+	points, pointErrors, err := opentsdb.ParseHTTP(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	failures := []string{}
+	for _, pointErr := range pointErrors {
+		failures = append(failures, pointErr.Error())
+	}
+
+	written := 0
+	for _, point := range points {
+		if err := s.db.CreateMetric(point.Metric).Append(point.Labels, point.Timestamp, point.Value); err != nil {
+			failures = append(failures, fmt.Sprintf("%s %v: %s", point.Metric, point.Labels, err))
+			continue
+		}
+		written++
+	}
+
+	if len(failures) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	s.logger.Warn("rejected opentsdb datapoints", zap.Int("written", written), zap.Int("failed", len(failures)))
+
+	b, err := json.Marshal(map[string]interface{}{
+		"success": written,
+		"failed":  len(failures),
+		"errors":  failures,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	if _, err := w.Write(b); err != nil {
+		s.logger.Warn("writing response", zap.Error(err))
+	}
+}
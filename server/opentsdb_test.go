@@ -0,0 +1,73 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func putDatapoints(t *testing.T, url, body string) (int, string) {
+	resp, err := http.Post(url+"/api/put", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp.StatusCode, string(b)
+}
+
+func TestOpenTSDBPut(t *testing.T) {
+	_, srv := newTestServer(t)
+
+	code, body := putDatapoints(t, srv.URL, `[
+		{"metric": "sys.cpu.user", "timestamp": 1000, "value": 1, "tags": {"host": "web-1"}},
+		{"metric": "sys.cpu.user", "timestamp": 1010, "value": "2", "tags": {"host": "web-1"}}
+	]`)
+	require.Equal(t, http.StatusNoContent, code, body)
+
+	code, body = get(t, srv, "/api/v1/query", url.Values{"query": {"sys_cpu_user"}, "time": {"1010"}})
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, body, `"metric":{"__name__":"sys_cpu_user","host":"web-1"},"value":[1010,"2"]`)
+
+	code, body = putDatapoints(t, srv.URL, `[
+		{"metric": "sys.cpu.user", "timestamp": 1020, "value": 3, "tags": {"host": "web-1"}},
+		{"metric": "sys.cpu.user", "timestamp": 1005, "value": 4, "tags": {"host": "web-1"}},
+		{"metric": "sys.cpu.user", "timestamp": 1030, "value": "oops", "tags": {"host": "web-1"}}
+	]`)
+	require.Equal(t, http.StatusBadRequest, code)
+	require.JSONEq(t, `{
+		"success": 1,
+		"failed": 2,
+		"errors": [
+			"datapoint 2: invalid value \"oops\"",
+			"sys_cpu_user map[host:web-1]: out of order sample: 1005000 is older than 1020000 of sys_cpu_user map[host:web-1]"
+		]
+	}`, body)
+
+	code, _ = putDatapoints(t, srv.URL, `{"metric":`)
+	require.Equal(t, http.StatusBadRequest, code)
+
+	resp, err := http.Get(srv.URL + "/api/put")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestOpenTSDBPutMaxRequestSize(t *testing.T) {
+	_, srv := newTestServer(t)
+	srv.Config.Handler.(*Server).SetMaxRequestSize(1024)
+
+	point := `{"metric": "sys.cpu.user", "timestamp": 1000, "value": 1, "tags": {"host": "web-1"}}`
+
+	code, body := putDatapoints(t, srv.URL, "["+strings.Repeat(point+",", 20)+point+"]")
+	require.Equal(t, http.StatusRequestEntityTooLarge, code)
+	require.Equal(t, "http: request body too large\n", body)
+
+	code, body = putDatapoints(t, srv.URL, point)
+	require.Equal(t, http.StatusNoContent, code, body)
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+	"go.uber.org/zap"
+)
+
+// otlpWrite receives metrics like the /v1/metrics endpoint of OTLP/HTTP,
+// encoded as protobuf or JSON. Datapoints that can not be appended do not
+// fail the request, they are reported as a partial success in the response.
+func (s *Server) otlpWrite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	contentType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (contentType != "application/x-protobuf" && contentType != "application/json") {
+		http.Error(w, "unsupported content type, expected application/x-protobuf or application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	data, err := s.readBody(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), bodyErrorCode(err))
+		return
+	}
+
+	req := pmetricotlp.NewExportRequest()
+	if contentType == "application/json" {
+		err = req.UnmarshalJSON(data)
+	} else {
+		err = req.UnmarshalProto(data)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rejected, errs := s.otlp.Append(req.Metrics())
+
+	resp := pmetricotlp.NewExportResponse()
+	if rejected > 0 {
+		s.logger.Warn("rejected otlp datapoints", zap.Int("rejected", rejected), zap.Error(errors.Join(errs...)))
+
+		if len(errs) > maxReportedErrors {
+			errs = append(errs[:maxReportedErrors], fmt.Errorf("and %d more", len(errs)-maxReportedErrors))
+		}
+		resp.PartialSuccess().SetRejectedDataPoints(int64(rejected))
+		resp.PartialSuccess().SetErrorMessage(errors.Join(errs...).Error())
+	}
+
+	var b []byte
+	if contentType == "application/json" {
+		b, err = resp.MarshalJSON()
+	} else {
+		b, err = resp.MarshalProto()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	if _, err := w.Write(b); err != nil {
+		s.logger.Warn("writing response", zap.Error(err))
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+)
+
+func postMetrics(t *testing.T, url, contentType string, body []byte) (int, []byte) {
+	resp, err := http.Post(url+"/v1/metrics", contentType, bytes.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp.StatusCode, b
+}
+
+func TestOTLPWrite(t *testing.T) {
+	_, srv := newTestServer(t)
+
+	metrics := pmetric.NewMetrics()
+	rm := metrics.ResourceMetrics().AppendEmpty()
+	rm.Resource().Attributes().PutStr("service.name", "checkout")
+
+	sum := rm.ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	sum.SetName("http.requests")
+	sum.SetEmptySum().SetIsMonotonic(true)
+	sum.Sum().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+	for idx, value := range []int64{4, 6} {
+		dp := sum.Sum().DataPoints().AppendEmpty()
+		dp.SetTimestamp(pcommon.NewTimestampFromTime(time.Unix(int64(1000+10*idx), 0)))
+		dp.SetIntValue(value)
+	}
+
+	body, err := pmetricotlp.NewExportRequestFromMetrics(metrics).MarshalProto()
+	require.NoError(t, err)
+
+	code, b := postMetrics(t, srv.URL, "application/x-protobuf", body)
+	require.Equal(t, http.StatusOK, code, string(b))
+
+	resp := pmetricotlp.NewExportResponse()
+	require.NoError(t, resp.UnmarshalProto(b))
+	require.Zero(t, resp.PartialSuccess().RejectedDataPoints())
+
+	code, qbody := get(t, srv, "/api/v1/query", url.Values{"query": {"http_requests_total"}, "time": {"1010"}})
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, qbody, `"metric":{"__name__":"http_requests_total","service_name":"checkout"},"value":[1010,"10"]`)
+
+	code, b = postMetrics(t, srv.URL, "application/json", []byte(`{"resourceMetrics": [{
+		"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "checkout"}}]},
+		"scopeMetrics": [{"metrics": [
+			{"name": "queue.depth", "gauge": {"dataPoints": [{"timeUnixNano": "1020000000000", "asDouble": 7}]}},
+			{"name": "rpc.duration", "summary": {"dataPoints": [{"timeUnixNano": "1020000000000"}]}}
+		]}]
+	}]}`))
+	require.Equal(t, http.StatusOK, code, string(b))
+	require.JSONEq(t, `{"partialSuccess": {"rejectedDataPoints": "1", "errorMessage": "metric rpc.duration: unsupported type Summary"}}`, string(b))
+
+	code, qbody = get(t, srv, "/api/v1/query", url.Values{"query": {"queue_depth"}, "time": {"1020"}})
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, qbody, `"value":[1020,"7"]`)
+
+	code, _ = postMetrics(t, srv.URL, "application/json", []byte(`{"resourceMetrics": `))
+	require.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = postMetrics(t, srv.URL, "text/plain", []byte("queue.depth 7"))
+	require.Equal(t, http.StatusUnsupportedMediaType, code)
+
+	r, err := http.Get(srv.URL + "/v1/metrics")
+	require.NoError(t, err)
+	r.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, r.StatusCode)
+}
+
+func TestOTLPWriteMaxRequestSize(t *testing.T) {
+	_, srv := newTestServer(t)
+	srv.Config.Handler.(*Server).SetMaxRequestSize(1024)
+
+	body := []byte(`{"resourceMetrics": [{"scopeMetrics": [{"metrics": [` +
+		`{"name": "queue.depth", "gauge": {"dataPoints": [{"timeUnixNano": "1020000000000", "asDouble": 7}]}}` +
+		`]}]}]}`)
+
+	code, b := postMetrics(t, srv.URL, "application/json", body)
+	require.Equal(t, http.StatusOK, code, string(b))
+
+	// small gzipped, but over the limit once decompressed
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	_, err := gz.Write(append(body, bytes.Repeat([]byte(" "), 2048)...))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	require.Less(t, gzipped.Len(), 1024)
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/v1/metrics", &gzipped)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	code, _ = postMetrics(t, srv.URL, "application/json", append(body, bytes.Repeat([]byte(" "), 2048)...))
+	require.Equal(t, http.StatusRequestEntityTooLarge, code)
+}
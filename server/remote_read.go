@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/Marvin9/ftsdb/promadapter"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/prometheus/prometheus/util/annotations"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultRemoteReadSampleLimit is the most samples a single query of a
+	// remote read request may return, the Prometheus default.
+	DefaultRemoteReadSampleLimit = 50000000
+
+	// remoteReadMaxBytesInFrame is the size streamed responses cut frames
+	// at, the Prometheus default.
+	remoteReadMaxBytesInFrame = 1024 * 1024
+)
+
+// SetRemoteReadSampleLimit bounds the samples a query of a remote read
+// request may return. A limit of zero disables it.
+func (s *Server) SetRemoteReadSampleLimit(limit int) {
+	if limit >= 0 {
+		s.remoteReadSampleLimit = limit
+	}
+}
+
+// remoteRead serves the remote read protocol of Prometheus, answering with
+// raw samples or, when the client accepts them, with streamed XOR chunks.
+// A query over the sample limit fails the request with a 400.
+func (s *Server) remoteRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, err := remote.DecodeReadRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	responseType, err := remote.NegotiateResponseType(req.AcceptedResponseTypes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// every query is selected up front, so a failing one is reported before
+	// any part of a streamed response is written
+	sets := make([]storage.SeriesSet, len(req.Queries))
+	for idx, query := range req.Queries {
+		matchers, err := remote.FromLabelMatchers(query.Matchers)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sets[idx], err = s.selectRemoteRead(r.Context(), query, matchers, responseType == prompb.ReadRequest_STREAMED_XOR_CHUNKS)
+		if err != nil {
+			s.respondRemoteReadError(w, err)
+			return
+		}
+	}
+
+	switch responseType {
+	case prompb.ReadRequest_STREAMED_XOR_CHUNKS:
+		s.remoteReadStreamed(w, sets)
+	default:
+		s.remoteReadSamples(w, sets)
+	}
+}
+
+func (s *Server) remoteReadSamples(w http.ResponseWriter, sets []storage.SeriesSet) {
+	resp := &prompb.ReadResponse{
+		Results: make([]*prompb.QueryResult, len(sets)),
+	}
+
+	for idx, set := range sets {
+		result, _, err := remote.ToQueryResult(set, 0)
+		if err != nil {
+			s.respondRemoteReadError(w, err)
+			return
+		}
+		resp.Results[idx] = result
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Header().Set("Content-Encoding", "snappy")
+
+	if err := remote.EncodeReadResponse(resp, w); err != nil {
+		s.logger.Warn("writing remote read response", zap.Error(err))
+	}
+}
+
+func (s *Server) remoteReadStreamed(w http.ResponseWriter, sets []storage.SeriesSet) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "response writer can not be flushed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-streamed-protobuf; proto=prometheus.ChunkedReadResponse")
+
+	marshalPool := &sync.Pool{}
+	for idx, set := range sets {
+		_, err := remote.StreamChunkedReadResponses(
+			remote.NewChunkedWriter(w, f),
+			int64(idx),
+			storage.NewSeriesSetToChunkSet(set),
+			nil,
+			remoteReadMaxBytesInFrame,
+			marshalPool,
+		)
+		if err != nil {
+			// frames may already be out, the client sees a broken stream
+			s.logger.Warn("streaming remote read response", zap.Error(err))
+			return
+		}
+	}
+}
+
+// selectRemoteRead selects the series of a query within its time bounds and
+// reads them, failing as soon as they hold more samples than the limit.
+// Streamed responses need the series sorted.
+func (s *Server) selectRemoteRead(ctx context.Context, query *prompb.Query, matchers []*labels.Matcher, sorted bool) (storage.SeriesSet, error) {
+	queryable := promadapter.NewQueryableWithSampleLimit(s.db, s.remoteReadSampleLimit)
+
+	querier, err := queryable.Querier(query.StartTimestampMs, query.EndTimestampMs)
+	if err != nil {
+		return nil, err
+	}
+	defer querier.Close()
+
+	hints := &storage.SelectHints{
+		Start: query.StartTimestampMs,
+		End:   query.EndTimestampMs,
+	}
+	if query.Hints != nil {
+		hints.Step = query.Hints.StepMs
+		hints.Func = query.Hints.Func
+		hints.Grouping = query.Hints.Grouping
+		hints.By = query.Hints.By
+		hints.Range = query.Hints.RangeMs
+	}
+
+	set := querier.Select(ctx, sorted, hints, matchers...)
+
+	selected := &seriesSlice{idx: -1}
+	for set.Next() {
+		selected.series = append(selected.series, set.At())
+	}
+
+	return selected, set.Err()
+}
+
+func (s *Server) respondRemoteReadError(w http.ResponseWriter, err error) {
+	var limit promadapter.ErrSampleLimit
+	if errors.As(err, &limit) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.logger.Error("remote read", zap.Error(err))
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// seriesSlice is a storage.SeriesSet of series already read.
+type seriesSlice struct {
+	series []storage.Series
+	idx    int
+}
+
+func (s *seriesSlice) Next() bool {
+	if s.idx+1 >= len(s.series) {
+		return false
+	}
+	s.idx++
+	return true
+}
+
+func (s *seriesSlice) At() storage.Series {
+	return s.series[s.idx]
+}
+
+func (s *seriesSlice) Err() error {
+	return nil
+}
+
+func (s *seriesSlice) Warnings() annotations.Annotations {
+	return nil
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/stretchr/testify/require"
+)
+
+func remoteReadFixture(t *testing.T) *httptest.Server {
+	db, srv := newTestServer(t)
+
+	// committed chunks and the head are both read
+	db.SetFlushLimit(1)
+
+	cpu := db.CreateMetric("cpu")
+	for ts := int64(0); ts < 100; ts++ {
+		require.NoError(t, cpu.Append(map[string]string{"host": "web-1"}, ts*1000, float64(ts)))
+		require.NoError(t, cpu.Append(map[string]string{"host": "web-2"}, ts*1000, float64(2*ts)))
+		if ts == 50 {
+			require.NoError(t, db.Commit())
+		}
+	}
+	require.NoError(t, db.CreateMetric("mem").Append(map[string]string{"host": "web-1"}, 0, 1))
+
+	return srv
+}
+
+func readClient(t *testing.T, srv *httptest.Server) remote.ReadClient {
+	u, err := url.Parse(srv.URL + "/api/v1/read")
+	require.NoError(t, err)
+
+	client, err := remote.NewReadClient("ftsdb", &remote.ClientConfig{
+		URL:     &config_util.URL{URL: u},
+		Timeout: model.Duration(10 * time.Second),
+	})
+	require.NoError(t, err)
+
+	return client
+}
+
+func TestRemoteReadSamples(t *testing.T) {
+	srv := remoteReadFixture(t)
+
+	query, err := remote.ToQuery(10000, 12000, []*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "cpu"),
+		labels.MustNewMatcher(labels.MatchRegexp, "host", "web-.*"),
+	}, nil)
+	require.NoError(t, err)
+
+	result, err := readClient(t, srv).Read(context.Background(), query)
+	require.NoError(t, err)
+
+	require.Equal(t, []*prompb.TimeSeries{
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "cpu"}, {Name: "host", Value: "web-1"}},
+			Samples: []prompb.Sample{{Timestamp: 10000, Value: 10}, {Timestamp: 11000, Value: 11}, {Timestamp: 12000, Value: 12}},
+		},
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "cpu"}, {Name: "host", Value: "web-2"}},
+			Samples: []prompb.Sample{{Timestamp: 10000, Value: 20}, {Timestamp: 11000, Value: 22}, {Timestamp: 12000, Value: 24}},
+		},
+	}, sortedTimeSeries(result.Timeseries))
+}
+
+func TestRemoteReadStreamedChunks(t *testing.T) {
+	srv := remoteReadFixture(t)
+
+	query, err := remote.ToQuery(0, 99000, []*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchEqual, "host", "web-1"),
+	}, nil)
+	require.NoError(t, err)
+
+	resp := postReadRequest(t, srv, &prompb.ReadRequest{
+		Queries:               []*prompb.Query{query, query},
+		AcceptedResponseTypes: []prompb.ReadRequest_ResponseType{prompb.ReadRequest_STREAMED_XOR_CHUNKS},
+	})
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "application/x-streamed-protobuf; proto=prometheus.ChunkedReadResponse", resp.Header.Get("Content-Type"))
+
+	type series struct {
+		query  int64
+		labels string
+		count  int
+		sum    float64
+	}
+	found := []series{}
+
+	stream := remote.NewChunkedReader(resp.Body, remote.DefaultChunkedReadLimit, nil)
+	for {
+		res := &prompb.ChunkedReadResponse{}
+		err := stream.NextProto(res)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+
+		for _, cs := range res.ChunkedSeries {
+			s := series{query: res.QueryIndex}
+			for _, l := range cs.Labels {
+				s.labels += l.Name + "=" + l.Value + ","
+			}
+
+			for _, chk := range cs.Chunks {
+				require.Equal(t, prompb.Chunk_XOR, chk.Type)
+
+				c, err := chunkenc.FromData(chunkenc.EncXOR, chk.Data)
+				require.NoError(t, err)
+
+				it := c.Iterator(nil)
+				for it.Next() == chunkenc.ValFloat {
+					_, v := it.At()
+					s.count++
+					s.sum += v
+				}
+				require.NoError(t, it.Err())
+			}
+
+			found = append(found, s)
+		}
+	}
+
+	// series come sorted in each query
+	require.Equal(t, []series{
+		{query: 0, labels: "__name__=cpu,host=web-1,", count: 100, sum: 4950},
+		{query: 0, labels: "__name__=mem,host=web-1,", count: 1, sum: 1},
+		{query: 1, labels: "__name__=cpu,host=web-1,", count: 100, sum: 4950},
+		{query: 1, labels: "__name__=mem,host=web-1,", count: 1, sum: 1},
+	}, found)
+}
+
+func TestRemoteReadSampleLimit(t *testing.T) {
+	srv := remoteReadFixture(t)
+	srv.Config.Handler.(*Server).SetRemoteReadSampleLimit(150)
+
+	query, err := remote.ToQuery(0, 99000, []*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "cpu"),
+	}, nil)
+	require.NoError(t, err)
+
+	_, err = readClient(t, srv).Read(context.Background(), query)
+	require.ErrorContains(t, err, "exceeded sample limit (150)")
+
+	// the limit applies to streamed responses as well, before anything is sent
+	resp := postReadRequest(t, srv, &prompb.ReadRequest{
+		Queries:               []*prompb.Query{query},
+		AcceptedResponseTypes: []prompb.ReadRequest_ResponseType{prompb.ReadRequest_STREAMED_XOR_CHUNKS},
+	})
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Equal(t, "exceeded sample limit (150)\n", string(body))
+
+	// within the limit per query
+	query.EndTimestampMs = 49000
+	result, err := readClient(t, srv).Read(context.Background(), query)
+	require.NoError(t, err)
+	require.Len(t, result.Timeseries, 2)
+}
+
+func postReadRequest(t *testing.T, srv *httptest.Server, req *prompb.ReadRequest) *http.Response {
+	b, err := req.Marshal()
+	require.NoError(t, err)
+
+	resp, err := http.Post(srv.URL+"/api/v1/read", "application/x-protobuf", bytes.NewReader(snappy.Encode(nil, b)))
+	require.NoError(t, err)
+
+	return resp
+}
+
+func sortedTimeSeries(series []*prompb.TimeSeries) []*prompb.TimeSeries {
+	sort.Slice(series, func(i, j int) bool {
+		return labels.Compare(labelsOf(series[i]), labelsOf(series[j])) < 0
+	})
+	return series
+}
+
+func labelsOf(ts *prompb.TimeSeries) labels.Labels {
+	b := labels.NewScratchBuilder(len(ts.Labels))
+	for _, l := range ts.Labels {
+		b.Add(l.Name, l.Value)
+	}
+	return b.Labels()
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/Marvin9/ftsdb/ftsdb"
+	"github.com/golang/snappy"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"go.uber.org/zap"
+)
+
+// remoteWrite receives the remote write protocol of Prometheus: a snappy
+// compressed prompb.WriteRequest. The __name__ label of a series is its
+// metric.
+//
+// A request with an invalid series is rejected as a whole. Otherwise every
+// sample is appended, and samples ftsdb rejects as out of order are reported
+// with a 400, which tells the sender not to retry. Requests over the max
+// request size, compressed or not, are rejected with a 413.
+func (s *Server) remoteWrite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	compressed, err := s.readBody(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), bodyErrorCode(err))
+		return
+	}
+
+	req, err := decodeWriteRequest(compressed, s.maxRequestSize)
+	if err != nil {
+		http.Error(w, err.Error(), bodyErrorCode(err))
+		return
+	}
+
+	if err := validateWriteRequest(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rejected := s.appendWriteRequest(req)
+	if rejected.count() > 0 {
+		s.logger.Warn("rejected remote write samples",
+			zap.Int("out-of-order", rejected.outOfOrder),
+			zap.Int("duplicate", rejected.duplicate),
+			zap.Error(rejected.first),
+		)
+		http.Error(w, rejected.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeWriteRequest decodes a snappy compressed write request, failing with
+// an *http.MaxBytesError if it is over maxSize once decompressed.
+func decodeWriteRequest(compressed []byte, maxSize int64) (*prompb.WriteRequest, error) {
+	size, err := snappy.DecodedLen(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("decoding snappy: %w", err)
+	}
+	if int64(size) > maxSize {
+		return nil, &http.MaxBytesError{Limit: maxSize}
+	}
+
+	b, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, fmt.Errorf("decoding snappy: %w", err)
+	}
+
+	req := &prompb.WriteRequest{}
+	if err := req.Unmarshal(b); err != nil {
+		return nil, fmt.Errorf("decoding write request: %w", err)
+	}
+
+	return req, nil
+}
+
+func validateWriteRequest(req *prompb.WriteRequest) error {
+	for idx, ts := range req.Timeseries {
+		if err := validateLabels(ts.Labels); err != nil {
+			return fmt.Errorf("invalid series %d: %w", idx, err)
+		}
+		if len(ts.Histograms) > 0 {
+			return fmt.Errorf("invalid series %d: native histograms are not supported", idx)
+		}
+	}
+	return nil
+}
+
+func validateLabels(ls []prompb.Label) error {
+	metric := ""
+	seen := make(map[string]struct{}, len(ls))
+
+	for _, l := range ls {
+		if !model.LabelName(l.Name).IsValid() {
+			return fmt.Errorf("invalid label name %q", l.Name)
+		}
+		if !utf8.ValidString(l.Value) {
+			return fmt.Errorf("invalid value of label %s: %q", l.Name, l.Value)
+		}
+		if _, found := seen[l.Name]; found {
+			return fmt.Errorf("duplicate label %s", l.Name)
+		}
+		seen[l.Name] = struct{}{}
+
+		if l.Name == labels.MetricName {
+			metric = l.Value
+		}
+	}
+
+	if metric == "" {
+		return errors.New("missing metric name (__name__ label)")
+	}
+	if !model.IsValidMetricName(model.LabelValue(metric)) {
+		return fmt.Errorf("invalid metric name %q", metric)
+	}
+
+	return nil
+}
+
+// rejectedSamples counts the samples of a write request ftsdb did not take.
+type rejectedSamples struct {
+	outOfOrder int
+	duplicate  int
+	first      error
+}
+
+func (r *rejectedSamples) add(err error) {
+	switch {
+	case errors.Is(err, ftsdb.ErrOutOfOrderSample):
+		r.outOfOrder++
+	case errors.Is(err, ftsdb.ErrDuplicateSample):
+		r.duplicate++
+	}
+	if r.first == nil {
+		r.first = err
+	}
+}
+
+func (r *rejectedSamples) count() int {
+	return r.outOfOrder + r.duplicate
+}
+
+func (r *rejectedSamples) Error() string {
+	return fmt.Sprintf("rejected %d out of order and %d duplicate samples, first: %s", r.outOfOrder, r.duplicate, r.first)
+}
+
+func (s *Server) appendWriteRequest(req *prompb.WriteRequest) *rejectedSamples {
+	rejected := &rejectedSamples{}
+
+	for _, ts := range req.Timeseries {
+		metric := ""
+		series := make(map[string]string, len(ts.Labels))
+		for _, l := range ts.Labels {
+			switch {
+			case l.Name == labels.MetricName:
+				metric = l.Value
+			case l.Value != "":
+				series[l.Name] = l.Value
+			}
+		}
+
+		m := s.db.CreateMetric(metric)
+		ref := m.Ref(series)
+
+		for _, sample := range ts.Samples {
+			if err := m.AppendRef(ref, sample.Timestamp, sample.Value); err != nil {
+				rejected.add(err)
+			}
+		}
+	}
+
+	return rejected
+}
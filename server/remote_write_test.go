@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/stretchr/testify/require"
+)
+
+func writeClient(t *testing.T, srv *httptest.Server) remote.WriteClient {
+	u, err := url.Parse(srv.URL + "/api/v1/write")
+	require.NoError(t, err)
+
+	client, err := remote.NewWriteClient("ftsdb", &remote.ClientConfig{
+		URL:     &config_util.URL{URL: u},
+		Timeout: model.Duration(10 * time.Second),
+	})
+	require.NoError(t, err)
+
+	return client
+}
+
+func store(t *testing.T, client remote.WriteClient, series ...prompb.TimeSeries) error {
+	b, err := (&prompb.WriteRequest{Timeseries: series}).Marshal()
+	require.NoError(t, err)
+
+	return client.Store(context.Background(), snappy.Encode(nil, b), 0)
+}
+
+func timeSeries(ls map[string]string, samples ...prompb.Sample) prompb.TimeSeries {
+	ts := prompb.TimeSeries{Samples: samples}
+	for name, value := range ls {
+		ts.Labels = append(ts.Labels, prompb.Label{Name: name, Value: value})
+	}
+	return ts
+}
+
+func TestRemoteWrite(t *testing.T) {
+	_, srv := newTestServer(t)
+	client := writeClient(t, srv)
+
+	require.NoError(t, store(t, client,
+		timeSeries(map[string]string{"__name__": "up", "job": "node", "instance": "a"},
+			prompb.Sample{Timestamp: 1000000, Value: 1},
+			prompb.Sample{Timestamp: 1010000, Value: 0},
+		),
+		timeSeries(map[string]string{"__name__": "up", "job": "node", "instance": "b", "zone": ""},
+			prompb.Sample{Timestamp: 1000000, Value: 1},
+		),
+	))
+
+	code, body := get(t, srv, "/api/v1/series", url.Values{"match[]": {"up"}})
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{
+		"status": "success",
+		"data": [
+			{"__name__": "up", "instance": "a", "job": "node"},
+			{"__name__": "up", "instance": "b", "job": "node"}
+		]
+	}`, body)
+
+	code, body = get(t, srv, "/api/v1/query", url.Values{"query": {`up{instance="a"}`}, "time": {"1010"}})
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{
+		"status": "success",
+		"data": {
+			"resultType": "vector",
+			"result": [{"metric": {"__name__": "up", "instance": "a", "job": "node"}, "value": [1010, "0"]}]
+		}
+	}`, body)
+}
+
+func TestRemoteWriteOutOfOrder(t *testing.T) {
+	_, srv := newTestServer(t)
+	client := writeClient(t, srv)
+
+	a := map[string]string{"__name__": "up", "instance": "a"}
+
+	require.NoError(t, store(t, client, timeSeries(a, prompb.Sample{Timestamp: 2000, Value: 1})))
+
+	// resending the same sample is fine
+	require.NoError(t, store(t, client, timeSeries(a, prompb.Sample{Timestamp: 2000, Value: 1})))
+
+	err := store(t, client,
+		timeSeries(a,
+			prompb.Sample{Timestamp: 1000, Value: 1},
+			prompb.Sample{Timestamp: 2000, Value: 5},
+			prompb.Sample{Timestamp: 3000, Value: 1},
+		),
+		timeSeries(map[string]string{"__name__": "up", "instance": "b"}, prompb.Sample{Timestamp: 1000, Value: 1}),
+	)
+	require.EqualError(t, err, "server returned HTTP status 400 Bad Request: rejected 1 out of order and 1 duplicate samples, first: out of order sample: 1000 is older than 2000 of up map[instance:a]")
+
+	// a 400 is not retried by senders
+	var recoverable remote.RecoverableError
+	require.False(t, errors.As(err, &recoverable))
+
+	// samples that were in order made it
+	code, body := get(t, srv, "/api/v1/query", url.Values{"query": {`count_over_time(up[1h])`}, "time": {"3"}})
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{
+		"status": "success",
+		"data": {
+			"resultType": "vector",
+			"result": [
+				{"metric": {"instance": "a"}, "value": [3, "2"]},
+				{"metric": {"instance": "b"}, "value": [3, "1"]}
+			]
+		}
+	}`, sortedResult(t, body))
+}
+
+func TestRemoteWriteValidation(t *testing.T) {
+	_, srv := newTestServer(t)
+	client := writeClient(t, srv)
+
+	for _, tc := range []struct {
+		series prompb.TimeSeries
+		err    string
+	}{
+		{
+			timeSeries(map[string]string{"job": "node"}, prompb.Sample{Timestamp: 1, Value: 1}),
+			"invalid series 1: missing metric name (__name__ label)",
+		},
+		{
+			timeSeries(map[string]string{"__name__": "up", "job-name": "node"}, prompb.Sample{Timestamp: 1, Value: 1}),
+			`invalid series 1: invalid label name "job-name"`,
+		},
+		{
+			timeSeries(map[string]string{"__name__": "1up"}, prompb.Sample{Timestamp: 1, Value: 1}),
+			`invalid series 1: invalid metric name "1up"`,
+		},
+		{
+			prompb.TimeSeries{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "a"}, {Name: "job", Value: "b"}},
+				Samples: []prompb.Sample{{Timestamp: 1, Value: 1}},
+			},
+			"invalid series 1: duplicate label job",
+		},
+		{
+			prompb.TimeSeries{
+				Labels:     []prompb.Label{{Name: "__name__", Value: "latency"}},
+				Histograms: []prompb.Histogram{{Timestamp: 1}},
+			},
+			"invalid series 1: native histograms are not supported",
+		},
+	} {
+		valid := timeSeries(map[string]string{"__name__": "valid"}, prompb.Sample{Timestamp: 1, Value: 1})
+
+		err := store(t, client, valid, tc.series)
+		require.EqualError(t, err, "server returned HTTP status 400 Bad Request: "+tc.err)
+	}
+
+	// nothing of a rejected request is appended
+	code, body := get(t, srv, "/api/v1/labels", url.Values{})
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{"status": "success", "data": []}`, body)
+
+	resp, err := http.Post(srv.URL+"/api/v1/write", "application/x-protobuf", strings.NewReader("not snappy"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Get(srv.URL + "/api/v1/write")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestRemoteWriteMaxRequestSize(t *testing.T) {
+	_, srv := newTestServer(t)
+	srv.Config.Handler.(*Server).SetMaxRequestSize(1024)
+	client := writeClient(t, srv)
+
+	require.NoError(t, store(t, client, timeSeries(map[string]string{"__name__": "up"}, prompb.Sample{Timestamp: 1, Value: 1})))
+
+	// over the limit once decompressed
+	err := store(t, client, timeSeries(map[string]string{"__name__": "up", "padding": strings.Repeat("a", 2048)}, prompb.Sample{Timestamp: 2, Value: 1}))
+	require.EqualError(t, err, "server returned HTTP status 413 Request Entity Too Large: http: request body too large")
+
+	// over the limit as sent, without reading all of it
+	body := make([]byte, 4096)
+	_, err = rand.Read(body)
+	require.NoError(t, err)
+
+	resp, err := http.Post(srv.URL+"/api/v1/write", "application/x-protobuf", bytes.NewReader(body))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+}
@@ -0,0 +1,185 @@
+// Package server serves an ftsdb database over HTTP with the query API of
+// Prometheus, so tools speaking it, like Grafana's Prometheus data source,
+// can query ftsdb.
+package server
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Marvin9/ftsdb/ftsdb"
+	"github.com/Marvin9/ftsdb/otlp"
+	"github.com/Marvin9/ftsdb/promadapter"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage"
+	"go.uber.org/zap"
+)
+
+// DefaultCommitInterval is how often Run commits the head, which writes a
+// chunk once the head reaches the flush limit of the database.
+const DefaultCommitInterval = time.Minute
+
+// DefaultMaxRequestSize bounds the body of write requests, as sent and once
+// decompressed.
+const DefaultMaxRequestSize = 64 << 20
+
+// ShutdownTimeout bounds how long Run waits for in-flight requests once its
+// context is done.
+const ShutdownTimeout = 30 * time.Second
+
+type Server struct {
+	db        ftsdb.DBInterface
+	queryable storage.Queryable
+	engine    *promql.Engine
+	logger    *zap.Logger
+	mux       *http.ServeMux
+	otlp      *otlp.Receiver
+
+	commitInterval        time.Duration
+	remoteReadSampleLimit int
+	maxRequestSize        int64
+}
+
+// NewServer serves db as it is configured. The write endpoints only report
+// out of order samples to writers when db rejects them, see
+// ftsdb.DBInterface.SetRejectOutOfOrder.
+func NewServer(logger *zap.Logger, db ftsdb.DBInterface) *Server {
+	s := &Server{
+		db:        db,
+		queryable: promadapter.NewQueryable(db),
+		engine: promql.NewEngine(promql.EngineOpts{
+			MaxSamples:    50000000,
+			Timeout:       2 * time.Minute,
+			LookbackDelta: 5 * time.Minute,
+		}),
+		logger:                logger,
+		mux:                   http.NewServeMux(),
+		otlp:                  otlp.NewReceiver(db),
+		commitInterval:        DefaultCommitInterval,
+		remoteReadSampleLimit: DefaultRemoteReadSampleLimit,
+		maxRequestSize:        DefaultMaxRequestSize,
+	}
+
+	s.mux.HandleFunc("/api/v1/query", s.query)
+	s.mux.HandleFunc("/api/v1/query_range", s.queryRange)
+	s.mux.HandleFunc("/api/v1/series", s.series)
+	s.mux.HandleFunc("/api/v1/labels", s.labelNames)
+	s.mux.HandleFunc("/api/v1/label/", s.labelValues)
+
+	s.mux.HandleFunc("/api/v1/write", s.remoteWrite)
+	s.mux.HandleFunc("/api/v1/read", s.remoteRead)
+
+	s.mux.HandleFunc("/write", s.influxWrite)
+	s.mux.HandleFunc("/ping", s.influxPing)
+
+	s.mux.HandleFunc("/api/put", s.openTSDBPut)
+
+	s.mux.HandleFunc("/v1/metrics", s.otlpWrite)
+
+	return s
+}
+
+func (s *Server) SetCommitInterval(interval time.Duration) {
+	if interval > 0 {
+		s.commitInterval = interval
+	}
+}
+
+// SetMaxRequestSize bounds the body of write requests, as sent and once
+// decompressed. Larger requests are rejected with a 413.
+func (s *Server) SetMaxRequestSize(size int64) {
+	if size > 0 {
+		s.maxRequestSize = size
+	}
+}
+
+// readBody reads the body of a write request, decompressing it if it is gzip
+// encoded. It fails with an *http.MaxBytesError once the body is over the
+// max request size, as sent or decompressed.
+func (s *Server) readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body := http.MaxBytesReader(w, r.Body, s.maxRequestSize)
+	if r.Header.Get("Content-Encoding") != "gzip" {
+		return io.ReadAll(body)
+	}
+
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	data, err := io.ReadAll(io.LimitReader(gz, s.maxRequestSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > s.maxRequestSize {
+		return nil, &http.MaxBytesError{Limit: s.maxRequestSize}
+	}
+
+	return data, nil
+}
+
+// bodyErrorCode is the status code to answer a request with whose body
+// failed to be read or decoded with err.
+func bodyErrorCode(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Run serves requests on l until ctx is done, committing the head every
+// commit interval. It then stops accepting requests, waits for the ones in
+// flight and commits the whole head, so nothing is left to replay from the
+// WAL on the next start.
+func (s *Server) Run(ctx context.Context, l net.Listener) error {
+	srv := &http.Server{Handler: s}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(l)
+	}()
+
+	ticker := time.NewTicker(s.commitInterval)
+	defer ticker.Stop()
+
+serve:
+	for {
+		select {
+		case err := <-served:
+			return err
+		case <-ticker.C:
+			if err := s.db.Commit(); err != nil {
+				s.logger.Error("committing head", zap.Error(err))
+			}
+		case <-ctx.Done():
+			break serve
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+
+	err := srv.Shutdown(shutdownCtx)
+	if serveErr := <-served; !errors.Is(serveErr, http.ErrServerClosed) {
+		err = errors.Join(err, serveErr)
+	}
+
+	// Commit only writes a chunk once the head reaches the flush limit
+	s.db.SetFlushLimit(1)
+	if commitErr := s.db.Commit(); commitErr != nil {
+		return errors.Join(err, commitErr)
+	}
+
+	return err
+}
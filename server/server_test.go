@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/Marvin9/ftsdb/ftsdb"
+	"github.com/Marvin9/ftsdb/ftsdb/ftsdbtest"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// newTestServer serves a new database with the HTTP API.
+func newTestServer(t *testing.T) (ftsdb.DBInterface, *httptest.Server) {
+	db := ftsdbtest.NewDB(t)
+	db.SetRejectOutOfOrder(true)
+
+	srv := httptest.NewServer(NewServer(zap.NewNop(), db))
+	t.Cleanup(srv.Close)
+
+	return db, srv
+}
+
+func TestRunCommitsOnShutdown(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := ftsdb.NewFTSDB(zap.NewNop(), dir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// far below the flush limit, so only the shutdown commit writes a chunk
+	cpu := db.CreateMetric("cpu")
+	for ts := int64(0); ts < 10; ts++ {
+		require.NoError(t, cpu.Append(map[string]string{"host": "web-1"}, ts, float64(ts)))
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- NewServer(zap.NewNop(), db).Run(ctx, l)
+	}()
+
+	resp, err := http.Get("http://" + l.Addr().String() + "/api/v1/labels")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	cancel()
+	require.NoError(t, <-done)
+
+	chunks := 0
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	for _, file := range files {
+		if _, err := strconv.Atoi(file.Name()); err == nil && file.IsDir() {
+			chunks++
+		}
+	}
+	require.Equal(t, 1, chunks)
+
+	_, err = http.Get("http://" + l.Addr().String() + "/api/v1/labels")
+	require.Error(t, err)
+}
@@ -0,0 +1,115 @@
+package shared
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// LineServer accepts TCP connections and hands every line they send to a
+// handler, for plaintext protocols like Graphite's. Replies written by the
+// handler go back to the sender.
+type LineServer struct {
+	logger *zap.Logger
+	handle func(w io.Writer, line string)
+
+	mtx       sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+func NewLineServer(logger *zap.Logger, handle func(w io.Writer, line string)) *LineServer {
+	return &LineServer{
+		logger:    logger,
+		handle:    handle,
+		listeners: map[net.Listener]struct{}{},
+		conns:     map[net.Conn]struct{}{},
+	}
+}
+
+// Serve accepts connections on l until Close is called, then returns nil.
+func (s *LineServer) Serve(l net.Listener) error {
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		l.Close()
+		return nil
+	}
+	s.listeners[l] = struct{}{}
+	s.mtx.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mtx.Lock()
+			closed := s.closed
+			s.mtx.Unlock()
+
+			if closed || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		s.mtx.Lock()
+		if s.closed {
+			s.mtx.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mtx.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+func (s *LineServer) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mtx.Lock()
+		delete(s.conns, conn)
+		s.mtx.Unlock()
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		s.handle(conn, scanner.Text())
+	}
+
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		s.logger.Warn("reading connection", zap.String("remote", conn.RemoteAddr().String()), zap.Error(err))
+	}
+}
+
+// Close stops accepting connections, closes the open ones and waits until
+// the lines already read are handled.
+func (s *LineServer) Close() error {
+	s.mtx.Lock()
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		err = errors.Join(err, l.Close())
+	}
+	for conn := range s.conns {
+		// only stop reading, so replies to lines being handled still go out
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.CloseRead()
+		} else {
+			conn.Close()
+		}
+	}
+	s.mtx.Unlock()
+
+	s.wg.Wait()
+
+	return err
+}
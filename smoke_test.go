@@ -33,7 +33,8 @@ func TestSmoke(t *testing.T) {
 		"host": "wind",
 	}
 
-	fftsdb := ftsdb.NewFTSDB(logger, experiments.GetIngestionDir())
+	fftsdb, err := ftsdb.NewFTSDB(logger, experiments.GetIngestionDir())
+	noErr(err)
 
 	defer fftsdb.Close()
 
@@ -136,7 +137,8 @@ func TestSmoke(t *testing.T) {
 	err = os.RemoveAll(dir)
 	noErr(err)
 
-	fftsdb = ftsdb.NewFTSDB(logger, experiments.GetIngestionDir())
+	fftsdb, err = ftsdb.NewFTSDB(logger, experiments.GetIngestionDir())
+	noErr(err)
 
 	metric = fftsdb.CreateMetric("jay")
 
@@ -202,7 +204,8 @@ func TestSmoke(t *testing.T) {
 	err = os.RemoveAll(dir)
 	noErr(err)
 
-	fftsdb = ftsdb.NewFTSDB(logger, shared.GetIngestionDir())
+	fftsdb, err = ftsdb.NewFTSDB(logger, shared.GetIngestionDir())
+	noErr(err)
 
 	metric = fftsdb.CreateMetric("mayur")
 